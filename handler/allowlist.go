@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+const (
+	unexpectedKey  = "Unexpected"
+	unexpectedRule = "unexpected-resource"
+)
+
+// allowlist ... the resource types and regions expected in the account, as
+// globs. An empty list allows any type or region
+type allowlist struct {
+	ResourceTypes []string `json:"resource_types"`
+	Regions       []string `json:"regions"`
+}
+
+// unexpectedResource ... a resource whose type or region is not allowed
+type unexpectedResource struct {
+	Label        string `json:"label"`
+	ResourceType string `json:"resource_type"`
+	ResourceID   string `json:"resource_id"`
+	AccountID    string `json:"account_id"`
+	Region       string `json:"region"`
+	Arn          string `json:"arn,omitempty"`
+	Property     string `json:"property"`
+	Reason       string `json:"reason"`
+	Changed      bool   `json:"changed"`
+}
+
+// item ... the resource as an item, so routing rules match it by type,
+// account and region like a change, at the high severity of its finding
+func (u unexpectedResource) item() map[string]interface{} {
+	return map[string]interface{}{
+		"ResourceType": u.ResourceType,
+		"ResourceId":   u.ResourceID,
+		"AccountId":    u.AccountID,
+		"AwsRegion":    u.Region,
+		severityKey:    severityHigh.String(),
+	}
+}
+
+// parseAllowlist ... parses and validates an allowlist
+func parseAllowlist(b []byte) (*allowlist, error) {
+	var a allowlist
+
+	if err := json.Unmarshal(b, &a); err != nil {
+		return nil, err
+	}
+
+	if len(a.ResourceTypes) == 0 && len(a.Regions) == 0 {
+		return nil, errors.New("allowlist requires resource_types or regions")
+	}
+
+	for _, p := range append(a.ResourceTypes[:len(a.ResourceTypes):len(a.ResourceTypes)], a.Regions...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("allowlist: invalid pattern %q: %v", p, err)
+		}
+	}
+
+	return &a, nil
+}
+
+// loadAllowlist ... reads the allowlist from S3, returning nil when it is not configured
+func loadAllowlist(cfg *config, svc s3iface.S3API) (*allowlist, error) {
+	if cfg.AllowlistKey == "" {
+		return nil, nil
+	}
+
+	bucket := cfg.AllowlistBucket
+	if bucket == "" {
+		bucket = cfg.S3Bucket
+	}
+
+	s, err := getObject(svc, bucket, cfg.AllowlistKey)
+	if err != nil {
+		return nil, err
+	}
+
+	a, err := parseAllowlist([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("using allowlist s3://%s/%s\n", bucket, cfg.AllowlistKey)
+
+	return a, nil
+}
+
+// violation ... the property of the item that is not allowed and why, empty
+// if it is allowed. Global resources, such as IAM roles, are only checked
+// against the resource types
+func (a *allowlist) violation(item map[string]interface{}) (string, string) {
+	resourceType := stringValue(item, "ResourceType")
+	if len(a.ResourceTypes) > 0 && !matchAny(a.ResourceTypes, resourceType) {
+		return "ResourceType", fmt.Sprintf("resource type %s is not allowed", resourceType)
+	}
+
+	region := stringValue(item, "AwsRegion")
+	if len(a.Regions) > 0 && region != "" && region != "global" && !matchAny(a.Regions, region) {
+		return "AwsRegion", fmt.Sprintf("region %s is not allowed", region)
+	}
+
+	return "", ""
+}
+
+// unexpectedResources ... checks the changed items, every resource in the
+// latest snapshot of each region delivering to the Config bucket and every
+// resource discovered in the home region against the configured allowlist
+func unexpectedResources(
+	cfg *config,
+	svc s3iface.S3API,
+	c *CfgSvc,
+	id stsiface.STSAPI,
+	items []map[string]interface{},
+	now time.Time) ([]unexpectedResource, error) {
+	a, err := loadAllowlist(cfg, svc)
+	if a == nil || err != nil {
+		return nil, err
+	}
+
+	out, err := id.GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	account := aws.StringValue(out.Account)
+
+	known, _, err := regionSnapshots(svc, cfg.S3Bucket, account, now)
+	if err != nil {
+		return nil, err
+	}
+
+	discovered, err := c.GetDiscoveredResources()
+	if err != nil {
+		return nil, err
+	}
+
+	return checkAllowlist(a, items, append(known, discoveredItems(discovered, account, cfg.DefaultRegion)...)), nil
+}
+
+// discoveredItems ... the resources discovered in the region as items, without
+// those that were deleted
+func discoveredItems(discovered []*configservice.ResourceIdentifier, account, region string) []map[string]interface{} {
+	items := make([]map[string]interface{}, 0, len(discovered))
+
+	for _, d := range discovered {
+		if d.ResourceDeletionTime != nil {
+			continue
+		}
+
+		items = append(items, map[string]interface{}{
+			"ResourceType": aws.StringValue(d.ResourceType),
+			"ResourceId":   aws.StringValue(d.ResourceId),
+			"ResourceName": aws.StringValue(d.ResourceName),
+			"AccountId":    account,
+			"AwsRegion":    region,
+		})
+	}
+
+	return items
+}
+
+// checkAllowlist ... marks the changed items that are not allowed and lists
+// every resource that is not allowed, changed or not, sorted by type
+func checkAllowlist(a *allowlist, changed, known []map[string]interface{}) []unexpectedResource {
+	if a == nil {
+		return nil
+	}
+
+	var unexpected []unexpectedResource
+
+	seen := make(map[string]bool)
+
+	for n, items := range [][]map[string]interface{}{changed, known} {
+		for _, i := range items {
+			key := stringValue(i, "ResourceType") + "|" + stringValue(i, "ResourceId")
+			if seen[key] {
+				continue
+			}
+
+			property, reason := a.violation(i)
+			if reason == "" {
+				continue
+			}
+
+			u := unexpectedResource{
+				Label:        resourceLabel(i),
+				ResourceType: stringValue(i, "ResourceType"),
+				ResourceID:   stringValue(i, "ResourceId"),
+				AccountID:    stringValue(i, "AccountId"),
+				Region:       stringValue(i, "AwsRegion"),
+				Arn:          stringValue(i, "Arn"),
+				Property:     property,
+				Reason:       reason,
+				Changed:      n == 0,
+			}
+
+			if u.Changed {
+				i[unexpectedKey] = &u
+			}
+
+			seen[key] = true
+			unexpected = append(unexpected, u)
+		}
+	}
+
+	sort.SliceStable(unexpected, func(i, j int) bool {
+		if unexpected[i].ResourceType != unexpected[j].ResourceType {
+			return unexpected[i].ResourceType < unexpected[j].ResourceType
+		}
+
+		return unexpected[i].Label < unexpected[j].Label
+	})
+
+	if len(unexpected) > 0 {
+		log.Printf("found %d resources outside the allowlist\n", len(unexpected))
+	}
+
+	return unexpected
+}
+
+// itemUnexpected ... why the item is outside the allowlist, whether set by
+// checkAllowlist or read back from JSON, nil if it is allowed
+func itemUnexpected(i map[string]interface{}) *unexpectedResource {
+	switch v := i[unexpectedKey].(type) {
+	case *unexpectedResource:
+		return v
+	case map[string]interface{}:
+		var u unexpectedResource
+		if decodeItemValue(v, &u) {
+			return &u
+		}
+	}
+
+	return nil
+}
+
+// unexpectedReason ... why the item is outside the allowlist, empty if it is allowed
+func unexpectedReason(i map[string]interface{}) string {
+	if u := itemUnexpected(i); u != nil {
+		return u.Reason
+	}
+
+	return ""
+}
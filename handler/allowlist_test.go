@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/aws/aws-sdk-go/service/sts/stsiface"
+)
+
+const allowlistTestJSON = `{"resource_types": ["AWS::EC2::*", "AWS::S3::Bucket", "AWS::IAM::Role"], "regions": ["us-east-1", "us-west-2"]}`
+
+type mockSTS struct {
+	stsiface.STSAPI
+}
+
+func (m *mockSTS) GetCallerIdentity(in *sts.GetCallerIdentityInput) (*sts.GetCallerIdentityOutput, error) {
+	return &sts.GetCallerIdentityOutput{Account: aws.String("123456789012")}, nil
+}
+
+// test functions //
+func TestCheckAllowlist(t *testing.T) {
+	a, err := parseAllowlist([]byte(allowlistTestJSON))
+	chkErr(t, err)
+
+	tt := map[string]struct {
+		item     map[string]interface{}
+		expected string
+	}{
+		"allowed": {
+			item: map[string]interface{}{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-1", "AwsRegion": "us-west-2"},
+		},
+		"type not allowed": {
+			item:     map[string]interface{}{"ResourceType": "AWS::SageMaker::NotebookInstance", "ResourceId": "nb", "AwsRegion": "us-east-1"},
+			expected: "ResourceType",
+		},
+		"region not allowed": {
+			item:     map[string]interface{}{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-2", "AwsRegion": "eu-west-3"},
+			expected: "AwsRegion",
+		},
+		"global resource": {
+			item: map[string]interface{}{"ResourceType": "AWS::IAM::Role", "ResourceId": "admin", "AwsRegion": "global"},
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			if property, _ := a.violation(tc.item); property != tc.expected {
+				t.Errorf("violation() failed. Expected %q, got: %q", tc.expected, property)
+			}
+		})
+	}
+
+	changed := []map[string]interface{}{tt["region not allowed"].item, tt["allowed"].item}
+	known := []map[string]interface{}{tt["type not allowed"].item, tt["region not allowed"].item, tt["global resource"].item}
+
+	unexpected := checkAllowlist(a, changed, known)
+	if len(unexpected) != 2 || unexpected[0].ResourceID != "i-2" || !unexpected[0].Changed ||
+		unexpected[1].ResourceID != "nb" || unexpected[1].Changed {
+		t.Fatalf("checkAllowlist() failed. Expected the changed instance and the unchanged notebook, got: %+v", unexpected)
+	}
+
+	if itemUnexpected(changed[0]) == nil || itemUnexpected(changed[1]) != nil || itemUnexpected(known[0]) != nil {
+		t.Errorf("checkAllowlist() failed. Expected only the changed instance to be marked, got: %v", changed)
+	}
+
+	for _, s := range []string{`{}`, `{"regions": ["us-[east-1"]}`} {
+		if _, err := parseAllowlist([]byte(s)); err == nil {
+			t.Errorf("parseAllowlist() failed. Expected error for %s", s)
+		}
+	}
+}
+
+func TestUnexpectedSeverity(t *testing.T) {
+	item := configTestItem(t, "AWS::EC2::Instance", `{"monitoring": "disabled"}`, `{"monitoring": "enabled"}`)
+	item["AwsRegion"] = "eu-west-3"
+	item[maintenanceKey] = "patching"
+
+	a, err := parseAllowlist([]byte(allowlistTestJSON))
+	chkErr(t, err)
+
+	unexpected := checkAllowlist(a, []map[string]interface{}{item}, nil)
+
+	// archived items are read back from JSON
+	b, err := json.Marshal(item)
+	chkErr(t, err)
+	chkErr(t, json.Unmarshal(b, &item))
+
+	scoreItems([]map[string]interface{}{item}, defaultRiskRules())
+
+	if s := itemSeverity(item); s != severityHigh {
+		t.Errorf("scoreItems() failed. Expected an unexpected resource to be high severity, got: %s", s)
+	}
+
+	if r := itemRisks(item); len(r) == 0 || r[0].Rule != unexpectedRule || r[0].Path != "AwsRegion" {
+		t.Errorf("scoreItems() failed. Expected an unexpected resource finding, got: %+v", r)
+	}
+
+	r := &report{Items: []map[string]interface{}{item}, Time: time.Date(2020, 3, 8, 3, 0, 0, 0, time.UTC), Unexpected: unexpected}
+	if s := reportSubject(r); !strings.HasPrefix(s, "1 change (1 high, 1 unexpected)") {
+		t.Errorf("reportSubject() failed. Expected the unexpected count, got: %s", s)
+	}
+
+	text, err := reportToText(r)
+	chkErr(t, err)
+
+	if !strings.Contains(text, "resource (AWS::EC2::Instance, eu-west-3): region eu-west-3 is not allowed") {
+		t.Errorf("reportToText() failed. Expected the unexpected resource in:\n%s", text)
+	}
+
+	h, err := reportToHTML(r)
+	chkErr(t, err)
+
+	if !strings.Contains(h, "Unexpected Resources") {
+		t.Errorf("reportToHTML() failed. Expected the unexpected resources section in:\n%s", h)
+	}
+}
+
+func TestUnexpectedResources(t *testing.T) {
+	now := time.Date(2020, 3, 8, 3, 0, 0, 0, time.UTC)
+	snapshot := func(id, region string) []byte {
+		return []byte(`{"configurationItems": [{"resourceType": "AWS::EC2::Instance", "resourceId": "` + id +
+			`", "awsRegion": "` + region + `", "accountId": "123456789012"}]}`)
+	}
+
+	m := &mockS3{Modified: now.Add(-time.Hour), Puts: map[string][]byte{
+		"allowlist.json": []byte(allowlistTestJSON),
+		snapshotPrefix("123456789012", "us-east-1", now) + "/east.json":                                snapshot("i-east", "us-east-1"),
+		snapshotPrefix("123456789012", "eu-west-3", now) + "/paris.json":                               snapshot("i-paris", "eu-west-3"),
+		"awsconfig/AWSLogs/123456789012/Config/ap-south-1/2020/3/7/OversizedChangeNotification/x.json": []byte(`{}`),
+	}}
+
+	c := &CfgSvc{Client: &mockCfgSvcClient{ResourcesResp: configservice.ListDiscoveredResourcesOutput{
+		ResourceIdentifiers: []*configservice.ResourceIdentifier{
+			{ResourceType: aws.String("AWS::SageMaker::NotebookInstance"), ResourceId: aws.String("nb")},
+			{ResourceType: aws.String("AWS::SageMaker::Model"), ResourceId: aws.String("gone"), ResourceDeletionTime: aws.Time(now)},
+		},
+	}}}
+
+	cfg := &config{S3Bucket: "config", AllowlistKey: "allowlist.json", DefaultRegion: "us-east-1"}
+
+	unexpected, err := unexpectedResources(cfg, m, c, &mockSTS{}, nil, now)
+	chkErr(t, err)
+
+	var ids []string
+	for _, u := range unexpected {
+		ids = append(ids, u.ResourceID)
+	}
+
+	if strings.Join(ids, ",") != "i-paris,nb" || unexpected[0].Region != "eu-west-3" || unexpected[1].AccountID != "123456789012" {
+		t.Fatalf("unexpectedResources() failed. Expected the instance in another region and the discovered notebook, got: %+v", unexpected)
+	}
+
+	r := &report{Time: now, Unexpected: unexpected}
+	if s := reportSubject(r); !strings.Contains(s, "2 unexpected") {
+		t.Errorf("reportSubject() failed. Expected the unexpected count without changes, got: %s", s)
+	}
+
+	text, err := reportToText(r)
+	chkErr(t, err)
+
+	if !strings.Contains(text, "region eu-west-3 is not allowed") {
+		t.Errorf("reportToText() failed. Expected the unexpected resource without changes in:\n%s", text)
+	}
+
+	unexpected, err = unexpectedResources(&config{S3Bucket: "config"}, m, c, &mockSTS{}, nil, now)
+	if err != nil || unexpected != nil {
+		t.Errorf("unexpectedResources() failed. Expected nothing checked without an allowlist, got: %v, %v", unexpected, err)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	archiveTimeFormat = "20060102T150405Z"
+	indexJSON         = "index.json"
+	indexHTML         = "index.html"
+	// each report's index entry is kept in its own object under this prefix
+	indexShardPrefix = "index"
+	indexMonthFormat = "2006/01"
+)
+
+// archiveEntry ... a single archived report listed in the index
+type archiveEntry struct {
+	Time      time.Time `json:"time"`
+	Path      string    `json:"path"`
+	Snapshot  string    `json:"snapshot"`
+	Resources int       `json:"resources"`
+	Files     []string  `json:"files"`
+}
+
+// archiveIndex ... the archived reports of one month, newest first. The index
+// at the archive prefix lists the latest month and links to the earlier months
+type archiveIndex struct {
+	Reports []archiveEntry `json:"reports"`
+	Months  []string       `json:"months,omitempty"`
+	// relative path from the index to the archive prefix
+	Base string `json:"-"`
+}
+
+// archiveFile ... a rendered report format written to the archive
+type archiveFile struct {
+	Name        string
+	ContentType string
+	Body        []byte
+}
+
+// archiveReport ... writes every rendering of the report to a date partitioned
+// S3 prefix, updates the index and sets the report's ArchiveURL
+func archiveReport(r *report, svc s3iface.S3API, cfg *config) error {
+	if cfg.ArchiveBucket == "" {
+		return nil
+	}
+
+	files, err := renderArchiveFiles(r)
+	if err != nil {
+		return err
+	}
+
+	dir := reportDir(r)
+	entry := archiveEntry{
+		Time:      r.Time,
+		Path:      dir,
+		Snapshot:  r.snapshotName(),
+		Resources: len(r.Items),
+	}
+
+	for _, f := range files {
+		if err := putArchiveObject(svc, cfg, path.Join(dir, f.Name), f.ContentType, f.Body); err != nil {
+			return err
+		}
+
+		entry.Files = append(entry.Files, f.Name)
+	}
+
+	if err := updateArchiveIndex(svc, cfg, entry, r.templates()); err != nil {
+		return err
+	}
+
+	r.ArchiveURL = archiveURL(cfg, path.Join(dir, files[0].Name))
+
+	log.Printf("Report archived to s3://%s/%s\n", cfg.ArchiveBucket, archiveKey(cfg, dir))
+
+	return nil
+}
+
+func renderArchiveFiles(r *report) ([]archiveFile, error) {
+	// the archived report is the full diff linked from truncated emails
+	full := *r
+	full.MaxDiffLines = 0
+
+	h, err := reportToHTML(&full)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := reportToJSON(r)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := reportToText(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return []archiveFile{
+		{Name: "report.html", ContentType: "text/html; charset=utf-8", Body: []byte(h)},
+		{Name: "items.json", ContentType: "application/json", Body: j},
+		{Name: "report.txt", ContentType: "text/plain; charset=utf-8", Body: []byte(t)},
+	}, nil
+}
+
+// archiveDir ... the date partitioned directory of a report relative to the archive prefix
+func archiveDir(t time.Time) string {
+	t = t.UTC()
+
+	return path.Join(t.Format("2006"), t.Format("01"), t.Format("02"), t.Format(archiveTimeFormat))
+}
+
+// reportDir ... the archive directory of a report. Held changes are reported
+// at the end of their window, so the window name keeps apart the reports of
+// windows closing at the same time
+func reportDir(r *report) string {
+	if r.Maintenance != nil {
+		return archiveDir(r.Time) + "-" + r.Maintenance.Name
+	}
+
+	return archiveDir(r.Time)
+}
+
+func archiveKey(cfg *config, rel string) string {
+	return path.Join(cfg.ArchivePrefix, rel)
+}
+
+// archiveURL ... link to an archived object, under archive_url when set or in
+// the S3 console otherwise
+func archiveURL(cfg *config, rel string) string {
+	if cfg.ArchiveURL != "" {
+		return strings.TrimSuffix(cfg.ArchiveURL, "/") + "/" + rel
+	}
+
+	return fmt.Sprintf("https://s3.console.aws.amazon.com/s3/object/%s?region=%s&prefix=%s",
+		cfg.ArchiveBucket, cfg.DefaultRegion, url.QueryEscape(archiveKey(cfg, rel)))
+}
+
+func putArchiveObject(svc s3iface.S3API, cfg *config, rel, contentType string, body []byte) error {
+	return putObject(svc, cfg.ArchiveBucket, archiveKey(cfg, rel), contentType, cfg.KmsKeyArn, body)
+}
+
+// putObject ... writes an object to S3, encrypted with the KMS key when one is given
+func putObject(svc s3iface.S3API, bucket, key, contentType, kmsKeyArn string, body []byte) error {
+	input := &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		Body:        bytes.NewReader(body),
+	}
+
+	if kmsKeyArn != "" {
+		input.ServerSideEncryption = aws.String(s3.ServerSideEncryptionAwsKms)
+		input.SSEKMSKeyId = aws.String(kmsKeyArn)
+	}
+
+	_, err := svc.PutObject(input)
+
+	return err
+}
+
+// shardKey ... the index entry of an archived report, relative to the archive prefix
+func shardKey(entry archiveEntry) string {
+	return path.Join(indexShardPrefix, entry.Path+".json")
+}
+
+// monthDir ... the directory of a month's reports and index, e.g. 2020/03
+func monthDir(t time.Time) string {
+	return t.UTC().Format(indexMonthFormat)
+}
+
+// getArchiveMonth ... the index of the reports archived in a month. Entries
+// are read from the month's index and any shard missing from it, such as one
+// written by a concurrent run, so no report is ever dropped from the index
+func getArchiveMonth(svc s3iface.S3API, cfg *config, month string) (index archiveIndex, err error) {
+	s, err := getObject(svc, cfg.ArchiveBucket, archiveKey(cfg, path.Join(month, indexJSON)))
+	if err == nil {
+		err = json.Unmarshal([]byte(s), &index)
+	}
+
+	var aerr awserr.Error
+	if err != nil && (!errors.As(err, &aerr) || aerr.Code() != s3.ErrCodeNoSuchKey) {
+		return index, err
+	}
+
+	known := make(map[string]bool, len(index.Reports))
+	for _, e := range index.Reports {
+		known[shardKey(e)] = true
+	}
+
+	prefix := archiveKey(cfg, path.Join(indexShardPrefix, month)) + "/"
+	input := &s3.ListObjectsInput{Bucket: aws.String(cfg.ArchiveBucket), Prefix: aws.String(prefix)}
+
+	for {
+		results, err := svc.ListObjects(input)
+		if err != nil {
+			return index, err
+		}
+
+		for _, o := range results.Contents {
+			key := aws.StringValue(o.Key)
+			if known[strings.TrimPrefix(strings.TrimPrefix(key, cfg.ArchivePrefix), "/")] {
+				continue
+			}
+
+			s, err := getObject(svc, cfg.ArchiveBucket, key)
+			if err != nil {
+				return index, err
+			}
+
+			var e archiveEntry
+			if err := json.Unmarshal([]byte(s), &e); err != nil {
+				return index, fmt.Errorf("error parsing archive index entry %s: %v", key, err)
+			}
+
+			index.Reports = append(index.Reports, e)
+		}
+
+		if !aws.BoolValue(results.IsTruncated) || len(results.Contents) == 0 {
+			break
+		}
+
+		input.Marker = results.Contents[len(results.Contents)-1].Key
+	}
+
+	sort.SliceStable(index.Reports, func(i, j int) bool {
+		return index.Reports[i].Time.After(index.Reports[j].Time)
+	})
+
+	return index, nil
+}
+
+// archiveMonths ... the months with archived reports, newest first
+func archiveMonths(svc s3iface.S3API, cfg *config) ([]string, error) {
+	var months []string
+
+	root := archiveKey(cfg, indexShardPrefix) + "/"
+
+	years, err := svc.ListObjects(&s3.ListObjectsInput{
+		Bucket:    aws.String(cfg.ArchiveBucket),
+		Prefix:    aws.String(root),
+		Delimiter: aws.String("/"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, y := range years.CommonPrefixes {
+		results, err := svc.ListObjects(&s3.ListObjectsInput{
+			Bucket:    aws.String(cfg.ArchiveBucket),
+			Prefix:    y.Prefix,
+			Delimiter: aws.String("/"),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range results.CommonPrefixes {
+			months = append(months, strings.Trim(strings.TrimPrefix(aws.StringValue(m.Prefix), root), "/"))
+		}
+	}
+
+	sort.Sort(sort.Reverse(sort.StringSlice(months)))
+
+	return months, nil
+}
+
+// archiveEntries ... the reports archived during a period, oldest first
+func archiveEntries(svc s3iface.S3API, cfg *config, start, end time.Time) ([]archiveEntry, error) {
+	var entries []archiveEntry
+
+	for m := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, time.UTC); m.Before(end); m = m.AddDate(0, 1, 0) {
+		index, err := getArchiveMonth(svc, cfg, monthDir(m))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range index.Reports {
+			if !e.Time.Before(start) && e.Time.Before(end) {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return entries, nil
+}
+
+// updateArchiveIndex ... writes the report's index entry, then rebuilds the
+// index of its month and the index at the archive prefix
+func updateArchiveIndex(svc s3iface.S3API, cfg *config, entry archiveEntry, templates *template.Template) error {
+	j, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	if err := putArchiveObject(svc, cfg, shardKey(entry), "application/json", j); err != nil {
+		return err
+	}
+
+	month := monthDir(entry.Time)
+
+	index, err := getArchiveMonth(svc, cfg, month)
+	if err != nil {
+		return err
+	}
+
+	// replace an earlier archive of the same execution
+	reports := []archiveEntry{entry}
+
+	for _, e := range index.Reports {
+		if e.Path != entry.Path {
+			reports = append(reports, e)
+		}
+	}
+
+	sort.SliceStable(reports, func(i, j int) bool {
+		return reports[i].Time.After(reports[j].Time)
+	})
+
+	index.Reports, index.Base = reports, "../../"
+
+	if err := writeArchiveIndex(svc, cfg, month, index, templates); err != nil {
+		return err
+	}
+
+	months, err := archiveMonths(svc, cfg)
+	if err != nil {
+		return err
+	}
+
+	// the index at the prefix lists the latest month, which a report archived
+	// late may not belong to
+	if len(months) > 0 && months[0] != month {
+		if index, err = getArchiveMonth(svc, cfg, months[0]); err != nil {
+			return err
+		}
+	}
+
+	index.Base = ""
+	if len(months) > 0 {
+		index.Months = months[1:]
+	}
+
+	return writeArchiveIndex(svc, cfg, "", index, templates)
+}
+
+// writeArchiveIndex ... writes the JSON and HTML index to a directory of the archive
+func writeArchiveIndex(svc s3iface.S3API, cfg *config, dir string, index archiveIndex, templates *template.Template) error {
+	j, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := putArchiveObject(svc, cfg, path.Join(dir, indexJSON), "application/json", j); err != nil {
+		return err
+	}
+
+	h, err := executeTemplate(templates, "index", index)
+	if err != nil {
+		return err
+	}
+
+	return putArchiveObject(svc, cfg, path.Join(dir, indexHTML), "text/html; charset=utf-8", []byte(h))
+}
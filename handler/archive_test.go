@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveReport(t *testing.T) {
+	m := &mockS3{}
+	cfg := config{ArchiveBucket: "archive", ArchivePrefix: "reports", DefaultRegion: "us-east-1"}
+
+	r := testReport(t)
+
+	err := archiveReport(r, m, &cfg)
+	if err != nil {
+		t.Fatalf("archiveReport() failed. Unexpected error: %v", err)
+	}
+
+	for _, k := range []string{
+		"reports/2020/01/30/20200130T133519Z/report.html",
+		"reports/2020/01/30/20200130T133519Z/items.json",
+		"reports/2020/01/30/20200130T133519Z/report.txt",
+		"reports/index.json",
+		"reports/index.html",
+	} {
+		if _, ok := m.Puts[k]; !ok {
+			t.Errorf("archiveReport() failed. Expected object %s to be written", k)
+		}
+	}
+
+	expected := "https://s3.console.aws.amazon.com/s3/object/archive?region=us-east-1&prefix=" +
+		"reports%2F2020%2F01%2F30%2F20200130T133519Z%2Freport.html"
+	if r.ArchiveURL != expected {
+		t.Errorf("archiveReport() failed. Expected ArchiveURL: %s\nGot: %s", expected, r.ArchiveURL)
+	}
+
+	// a second report is added to the front of the index
+	r2 := testReport(t)
+	r2.Time = r.Time.Add(time.Hour * 3)
+	cfg.ArchiveURL = "https://reports.example.com/"
+
+	err = archiveReport(r2, m, &cfg)
+	if err != nil {
+		t.Fatalf("archiveReport() failed. Unexpected error: %v", err)
+	}
+
+	if r2.ArchiveURL != "https://reports.example.com/2020/01/30/20200130T163519Z/report.html" {
+		t.Errorf("archiveReport() failed. Unexpected ArchiveURL: %s", r2.ArchiveURL)
+	}
+
+	var index archiveIndex
+
+	chkErr(t, json.Unmarshal(m.Puts["reports/index.json"], &index))
+
+	if len(index.Reports) != 2 || index.Reports[0].Path != "2020/01/30/20200130T163519Z" {
+		t.Errorf("archiveReport() failed. Unexpected index: %+v", index)
+	}
+
+	if !strings.Contains(string(m.Puts["reports/index.html"]), `<a href="2020/01/30/20200130T133519Z/items.json">`) {
+		t.Errorf("archiveReport() failed. Index missing link:\n%s", m.Puts["reports/index.html"])
+	}
+
+	h, err := reportToHTML(r2)
+	chkErr(t, err)
+
+	if !strings.Contains(h, `<a href="`+r2.ArchiveURL+`">`) {
+		t.Errorf("reportToHTML() failed. Report missing archive link:\n%s", h)
+	}
+
+	// the held changes of windows closing at the same time are archived apart
+	for _, name := range []string{"patching", "migration"} {
+		held := testReport(t)
+		held.Maintenance = &maintenanceRun{Name: name, End: held.Time}
+
+		chkErr(t, archiveReport(held, m, &cfg))
+
+		if _, ok := m.Puts["reports/2020/01/30/20200130T133519Z-"+name+"/items.json"]; !ok {
+			t.Errorf("archiveReport() failed. Expected the %s window's changes archived apart", name)
+		}
+	}
+}
+
+func TestArchiveReportDisabled(t *testing.T) {
+	m := &mockS3{}
+	r := testReport(t)
+
+	if err := archiveReport(r, m, &config{}); err != nil {
+		t.Fatalf("archiveReport() failed. Unexpected error: %v", err)
+	}
+
+	if len(m.Puts) != 0 || r.ArchiveURL != "" {
+		t.Errorf("archiveReport() failed. Expected nothing to be archived")
+	}
+}
+
+func TestUpdateArchiveIndex(t *testing.T) {
+	m := &mockS3{}
+	cfg := &config{ArchiveBucket: "archive", ArchivePrefix: "reports"}
+	january := time.Date(2020, 1, 30, 13, 0, 0, 0, time.UTC)
+
+	for _, at := range []time.Time{january, january.Add(time.Hour)} {
+		chkErr(t, updateArchiveIndex(m, cfg, archiveEntry{Time: at, Path: archiveDir(at), Files: []string{"items.json"}}, reportTemplates))
+	}
+
+	// a concurrent run overwrote the month's index without the first report
+	var index archiveIndex
+
+	chkErr(t, json.Unmarshal(m.Puts["reports/2020/01/index.json"], &index))
+	index.Reports = index.Reports[:1]
+	b, err := json.Marshal(index)
+	chkErr(t, err)
+	m.Puts["reports/2020/01/index.json"] = b
+
+	february := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+	chkErr(t, updateArchiveIndex(m, cfg, archiveEntry{Time: february, Path: archiveDir(february)}, reportTemplates))
+	chkErr(t, updateArchiveIndex(m, cfg, archiveEntry{Time: january.Add(2 * time.Hour), Path: archiveDir(january.Add(2 * time.Hour))}, reportTemplates))
+
+	chkErr(t, json.Unmarshal(m.Puts["reports/2020/01/index.json"], &index))
+
+	if len(index.Reports) != 3 {
+		t.Errorf("updateArchiveIndex() failed. Expected the report missing from the month's index restored, got: %+v", index.Reports)
+	}
+
+	if !strings.Contains(string(m.Puts["reports/2020/01/index.html"]), `<a href="../../2020/01/30/20200130T130000Z/items.json">`) {
+		t.Errorf("updateArchiveIndex() failed. Expected links relative to the month:\n%s", m.Puts["reports/2020/01/index.html"])
+	}
+
+	index = archiveIndex{}
+	chkErr(t, json.Unmarshal(m.Puts["reports/index.json"], &index))
+
+	if len(index.Reports) != 1 || !index.Reports[0].Time.Equal(february) || len(index.Months) != 1 || index.Months[0] != "2020/01" {
+		t.Errorf("updateArchiveIndex() failed. Expected the latest month linking to the earlier one, got: %+v", index)
+	}
+
+	entries, err := archiveEntries(m, cfg, january, february.Add(time.Hour))
+	chkErr(t, err)
+
+	if len(entries) != 4 || !entries[0].Time.Equal(january) || !entries[3].Time.Equal(february) {
+		t.Errorf("archiveEntries() failed. Expected the reports of both months oldest first, got: %+v", entries)
+	}
+}
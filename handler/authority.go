@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Whether a change is covered by an approved change record
+const (
+	changeAuthorized   = "authorized"
+	changeUnauthorized = "unauthorized"
+)
+
+const authorizationKey = "Authorization"
+
+// ApprovedChange ... an approved change record: the resources it covers, as
+// IDs, names or ARNs (globs), and the window in which they may change
+type ApprovedChange struct {
+	Ticket    string    `json:"ticket"`
+	URL       string    `json:"url"`
+	Resources []string  `json:"resources"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+}
+
+// approvedChangeSet ... the JSON document holding approved change records
+type approvedChangeSet struct {
+	Changes []ApprovedChange `json:"changes"`
+}
+
+// changeAuthorization ... whether a change was authorized, and by which ticket
+type changeAuthorization struct {
+	Status string `json:"status"`
+	Ticket string `json:"ticket,omitempty"`
+	URL    string `json:"url,omitempty"`
+}
+
+// ChangeAuthority ... a source of approved change records, such as the change
+// control board's ticketing system
+type ChangeAuthority interface {
+	ApprovedChanges(start, end time.Time) ([]ApprovedChange, error)
+}
+
+// S3ChangeAuthority ... reads approved change records from a JSON or CSV feed
+// in S3. Keys ending in .csv are read as CSV with a header row naming the
+// ticket, url, resources (separated by semicolons), start and end columns
+type S3ChangeAuthority struct {
+	Client s3iface.S3API
+	Bucket string
+	Key    string
+}
+
+// ApprovedChanges ... implements ChangeAuthority for S3 feeds
+func (a *S3ChangeAuthority) ApprovedChanges(start, end time.Time) ([]ApprovedChange, error) {
+	s, err := getObject(a.Client, a.Bucket, a.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(strings.ToLower(a.Key), ".csv") {
+		return parseApprovedChangesCSV(strings.NewReader(s))
+	}
+
+	return parseApprovedChanges([]byte(s))
+}
+
+// HTTPChangeAuthority ... queries an HTTPS endpoint for the approved change
+// records overlapping a period, passed as RFC 3339 start and end query
+// parameters. The endpoint responds with the same JSON as the S3 feed
+type HTTPChangeAuthority struct {
+	Client *http.Client
+	URL    string
+}
+
+// ApprovedChanges ... implements ChangeAuthority for HTTP endpoints
+func (a *HTTPChangeAuthority) ApprovedChanges(start, end time.Time) ([]ApprovedChange, error) {
+	u, err := url.Parse(a.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	q := u.Query()
+	q.Set("start", start.UTC().Format(time.RFC3339))
+	q.Set("end", end.UTC().Format(time.RFC3339))
+	u.RawQuery = q.Encode()
+
+	res, err := a.Client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("change authority returned unexpected status: %s", res.Status)
+	}
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseApprovedChanges(b)
+}
+
+// parseApprovedChanges ... parses and validates a JSON approved change set
+func parseApprovedChanges(b []byte) ([]ApprovedChange, error) {
+	var set approvedChangeSet
+
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	for _, c := range set.Changes {
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return set.Changes, nil
+}
+
+// parseApprovedChangesCSV ... parses and validates a CSV approved change feed
+func parseApprovedChangesCSV(r io.Reader) ([]ApprovedChange, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for n, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = n
+	}
+
+	for _, name := range []string{"ticket", "resources", "start", "end"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("approved changes CSV is missing the %s column", name)
+		}
+	}
+
+	field := func(row []string, name string) string {
+		if n, ok := columns[name]; ok && n < len(row) {
+			return strings.TrimSpace(row[n])
+		}
+
+		return ""
+	}
+
+	changes := make([]ApprovedChange, 0, len(rows)-1)
+
+	for n, row := range rows[1:] {
+		c := ApprovedChange{Ticket: field(row, "ticket"), URL: field(row, "url")}
+
+		for _, r := range strings.Split(field(row, "resources"), ";") {
+			if r = strings.TrimSpace(r); r != "" {
+				c.Resources = append(c.Resources, r)
+			}
+		}
+
+		if c.Start, err = time.Parse(time.RFC3339, field(row, "start")); err != nil {
+			return nil, fmt.Errorf("approved changes CSV row %d: invalid start: %v", n+2, err)
+		}
+
+		if c.End, err = time.Parse(time.RFC3339, field(row, "end")); err != nil {
+			return nil, fmt.Errorf("approved changes CSV row %d: invalid end: %v", n+2, err)
+		}
+
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, c)
+	}
+
+	return changes, nil
+}
+
+// validate ... checks the record has a ticket, resources and a valid window
+func (c *ApprovedChange) validate() error {
+	if c.Ticket == "" || len(c.Resources) == 0 {
+		return fmt.Errorf("approved change %q: requires a ticket and resources", c.Ticket)
+	}
+
+	if !c.End.After(c.Start) {
+		return fmt.Errorf("approved change %s: end must be after start", c.Ticket)
+	}
+
+	for _, r := range c.Resources {
+		if _, err := path.Match(r, ""); err != nil {
+			return fmt.Errorf("approved change %s: invalid pattern %q: %v", c.Ticket, r, err)
+		}
+	}
+
+	return nil
+}
+
+// covers ... whether the record approves a change to the item at time t
+func (c *ApprovedChange) covers(item map[string]interface{}, t time.Time) bool {
+	if t.Before(c.Start) || t.After(c.End) {
+		return false
+	}
+
+	for _, id := range []string{stringValue(item, "ResourceId"), stringValue(item, "ResourceName"), stringValue(item, "Arn")} {
+		if id != "" && matchAny(c.Resources, id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newChangeAuthorities ... creates the change authorities that are configured,
+// requiring https for the endpoint so change records are not read in the clear
+func newChangeAuthorities(cfg *config, svc s3iface.S3API) ([]ChangeAuthority, error) {
+	var authorities []ChangeAuthority
+
+	if cfg.ApprovedChangesKey != "" {
+		bucket := cfg.ApprovedChangesBucket
+		if bucket == "" {
+			bucket = cfg.S3Bucket
+		}
+
+		authorities = append(authorities, &S3ChangeAuthority{Client: svc, Bucket: bucket, Key: cfg.ApprovedChangesKey})
+	}
+
+	if cfg.ChangeAuthorityURL != "" {
+		if err := checkWebhookURL("change authority", "change_authority_url", cfg.ChangeAuthorityURL); err != nil {
+			return nil, err
+		}
+
+		authorities = append(authorities, &HTTPChangeAuthority{
+			Client: &http.Client{Timeout: time.Second * httpTimeout},
+			URL:    cfg.ChangeAuthorityURL,
+		})
+	}
+
+	return authorities, nil
+}
+
+// authorizeChanges ... marks each item authorized by the first approved change
+// record covering it, or unauthorized. Items are left unmarked when any
+// authority cannot be read, so an outage does not flag every change
+func authorizeChanges(items []map[string]interface{}, authorities []ChangeAuthority, fallback time.Time) error {
+	if len(authorities) == 0 || len(items) == 0 {
+		return nil
+	}
+
+	start, end := captureTime(items[0], fallback), captureTime(items[0], fallback)
+
+	for _, i := range items {
+		t := captureTime(i, fallback)
+		if t.Before(start) {
+			start = t
+		}
+
+		if t.After(end) {
+			end = t
+		}
+	}
+
+	var approved []ApprovedChange
+
+	for _, a := range authorities {
+		changes, err := a.ApprovedChanges(start, end)
+		if err != nil {
+			return err
+		}
+
+		approved = append(approved, changes...)
+	}
+
+	for _, i := range items {
+		auth := &changeAuthorization{Status: changeUnauthorized}
+		t := captureTime(i, fallback)
+
+		for n := range approved {
+			if c := &approved[n]; c.covers(i, t) {
+				auth = &changeAuthorization{Status: changeAuthorized, Ticket: c.Ticket, URL: c.URL}
+				break
+			}
+		}
+
+		i[authorizationKey] = auth
+	}
+
+	log.Printf("checked %d changes against %d approved change records\n", len(items), len(approved))
+
+	return nil
+}
+
+// itemAuthorization ... the authorization of an item, whether set by
+// authorizeChanges or read back from JSON
+func itemAuthorization(i map[string]interface{}) *changeAuthorization {
+	switch v := i[authorizationKey].(type) {
+	case *changeAuthorization:
+		return v
+	case map[string]interface{}:
+		var a changeAuthorization
+		if decodeItemValue(v, &a) {
+			return &a
+		}
+	}
+
+	return nil
+}
+
+// isUnauthorized ... whether no approved change record covers the item
+func isUnauthorized(i map[string]interface{}) bool {
+	a := itemAuthorization(i)
+	return a != nil && a.Status == changeUnauthorized
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const authorityTestJSON = `{"changes": [
+	{"ticket": "CHG1", "url": "https://tickets/CHG1", "resources": ["i-1", "arn:aws:s3:::logs-*"],
+		"start": "2020-03-08T00:00:00Z", "end": "2020-03-08T06:00:00Z"}
+]}`
+
+// helper functions //
+// mockAuthority ... a change authority returning fixed records or an error
+type mockAuthority struct {
+	changes []ApprovedChange
+	err     error
+}
+
+func (m *mockAuthority) ApprovedChanges(start, end time.Time) ([]ApprovedChange, error) {
+	return m.changes, m.err
+}
+
+// test functions //
+func TestAuthorizeChanges(t *testing.T) {
+	changes, err := parseApprovedChanges([]byte(authorityTestJSON))
+	chkErr(t, err)
+
+	execution := time.Date(2020, 3, 8, 3, 0, 0, 0, time.UTC)
+	tt := map[string]struct {
+		item     map[string]interface{}
+		expected string
+	}{
+		"by resource id": {
+			item:     map[string]interface{}{"ResourceId": "i-1"},
+			expected: changeAuthorized,
+		},
+		"by arn glob": {
+			item:     map[string]interface{}{"ResourceId": "logs-prod", "Arn": "arn:aws:s3:::logs-prod"},
+			expected: changeAuthorized,
+		},
+		"outside window": {
+			item:     map[string]interface{}{"ResourceId": "i-1", captureTimeKey: "2020-03-08T07:00:00Z"},
+			expected: changeUnauthorized,
+		},
+		"other resource": {
+			item:     map[string]interface{}{"ResourceId": "i-2"},
+			expected: changeUnauthorized,
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			chkErr(t, authorizeChanges([]map[string]interface{}{tc.item}, []ChangeAuthority{&mockAuthority{changes: changes}}, execution))
+
+			a := itemAuthorization(tc.item)
+			if a == nil || a.Status != tc.expected {
+				t.Fatalf("authorizeChanges() failed. Expected %s, got: %+v", tc.expected, a)
+			}
+
+			if a.Status == changeAuthorized && (a.Ticket != "CHG1" || a.URL != "https://tickets/CHG1") {
+				t.Errorf("authorizeChanges() failed. Expected ticket CHG1, got: %+v", a)
+			}
+		})
+	}
+
+	item := map[string]interface{}{"ResourceId": "i-2"}
+	if err := authorizeChanges([]map[string]interface{}{item}, []ChangeAuthority{&mockAuthority{err: errors.New("down")}}, execution); err == nil ||
+		itemAuthorization(item) != nil {
+		t.Errorf("authorizeChanges() failed. Expected an error and no authorization when an authority fails, got: %v", item)
+	}
+}
+
+func TestUnauthorizedEscalation(t *testing.T) {
+	item := configTestItem(t, "AWS::SQS::Queue", `{"delaySeconds": 0}`, `{"delaySeconds": 5}`)
+	item[authorizationKey] = &changeAuthorization{Status: changeUnauthorized}
+
+	scoreItems([]map[string]interface{}{item}, defaultRiskRules())
+
+	if s := itemSeverity(item); s != severityLow {
+		t.Errorf("scoreItems() failed. Expected an unauthorized info change to be escalated to low, got: %s", s)
+	}
+
+	r := &report{Items: []map[string]interface{}{item}, Time: time.Date(2020, 3, 8, 3, 0, 0, 0, time.UTC)}
+	if s := reportSubject(r); !strings.HasPrefix(s, "1 change (1 unauthorized)") {
+		t.Errorf("reportSubject() failed. Expected the unauthorized count, got: %s", s)
+	}
+
+	text, err := reportToText(r)
+	chkErr(t, err)
+
+	if !strings.Contains(text, "Unauthorized (no approved change ticket)") || !strings.Contains(text, "UNAUTHORIZED") {
+		t.Errorf("reportToText() failed. Expected the change to be escalated in:\n%s", text)
+	}
+}
+
+func TestChangeAuthorities(t *testing.T) {
+	csv := "Ticket,Resources,Start,End,URL\n" +
+		"CHG2,i-1;i-2,2020-03-08T00:00:00Z,2020-03-08T06:00:00Z,https://tickets/CHG2\n"
+	m := &mockS3{Puts: map[string][]byte{"approved.json": []byte(authorityTestJSON), "approved.csv": []byte(csv)}}
+
+	var query string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		fmt.Fprint(w, authorityTestJSON)
+	}))
+	defer srv.Close()
+
+	start := time.Date(2020, 3, 8, 0, 0, 0, 0, time.UTC)
+	tt := map[string]struct {
+		authority ChangeAuthority
+		expected  string
+	}{
+		"s3 json": {&S3ChangeAuthority{Client: m, Bucket: "bucket", Key: "approved.json"}, "CHG1"},
+		"s3 csv":  {&S3ChangeAuthority{Client: m, Bucket: "bucket", Key: "approved.csv"}, "CHG2"},
+		"http":    {&HTTPChangeAuthority{Client: srv.Client(), URL: srv.URL + "?team=ops"}, "CHG1"},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			changes, err := tc.authority.ApprovedChanges(start, start.Add(time.Hour))
+			chkErr(t, err)
+
+			if len(changes) != 1 || changes[0].Ticket != tc.expected || len(changes[0].Resources) != 2 {
+				t.Errorf("ApprovedChanges() failed. Expected %s, got: %+v", tc.expected, changes)
+			}
+		})
+	}
+
+	if query != "end=2020-03-08T01%3A00%3A00Z&start=2020-03-08T00%3A00%3A00Z&team=ops" {
+		t.Errorf("ApprovedChanges() failed. Unexpected query: %s", query)
+	}
+
+	for _, s := range []string{
+		`{"changes": [{"ticket": "CHG3", "start": "2020-03-08T00:00:00Z", "end": "2020-03-08T06:00:00Z"}]}`,
+		`{"changes": [{"ticket": "CHG3", "resources": ["i-1"], "start": "2020-03-08T06:00:00Z", "end": "2020-03-08T00:00:00Z"}]}`,
+	} {
+		if _, err := parseApprovedChanges([]byte(s)); err == nil {
+			t.Errorf("parseApprovedChanges() failed. Expected error for %s", s)
+		}
+	}
+
+	if _, err := parseApprovedChangesCSV(strings.NewReader("ticket,start,end\nCHG4,x,y\n")); err == nil {
+		t.Errorf("parseApprovedChangesCSV() failed. Expected error for a missing column")
+	}
+
+	for u, expected := range map[string]int{"https://tickets/approved": 2, "http://tickets/approved": 0} {
+		authorities, err := newChangeAuthorities(&config{S3Bucket: "bucket", ApprovedChangesKey: "approved.json", ChangeAuthorityURL: u}, m)
+		if len(authorities) != expected || (err != nil) != (expected == 0) {
+			t.Errorf("newChangeAuthorities() failed. Expected %d authorities for %s, got: %v, %v", expected, u, authorities, err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sort"
+)
+
+// propertyChange ... a single changed property of a configuration item
+type propertyChange struct {
+	Path     string      `json:"path"`
+	Previous interface{} `json:"previous"`
+	Current  interface{} `json:"current"`
+}
+
+// itemChanges ... flattens the nested diffs of an item into a list of
+// property changes sorted by path
+func itemChanges(item map[string]interface{}) []propertyChange {
+	diffs, ok := item["diffs"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	changes := collectChanges(diffs, item, "")
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+func collectChanges(diffs, item map[string]interface{}, prefix string) (changes []propertyChange) {
+	for key, value := range diffs {
+		if m, ok := value.(map[string]interface{}); ok {
+			if val, ok := m["diffs"]; ok {
+				sub, _ := item[key].(map[string]interface{})
+				changes = append(changes, collectChanges(val.(map[string]interface{}), sub, prefix+key+".")...)
+
+				continue
+			}
+		}
+
+		changes = append(changes, propertyChange{
+			Path:     prefix + key,
+			Previous: value,
+			Current:  item[key],
+		})
+	}
+
+	return changes
+}
+
+// resourceLabel ... returns the name of the resource, or its ID if unnamed
+func resourceLabel(item map[string]interface{}) string {
+	if s, ok := item["ResourceName"].(string); ok && s != "" {
+		return s
+	}
+
+	s, _ := item["ResourceId"].(string)
+
+	return s
+}
+
+// stringValue ... returns the string value of a key in an item map
+func stringValue(item map[string]interface{}, key string) string {
+	s, _ := item[key].(string)
+	return s
+}
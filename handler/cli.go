@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const suppressUsage = `usage: grace-config-differ suppress <command> [flags]
+
+commands:
+  add     acknowledge changes matching a resource and property path
+  list    list suppressions and whether they are active
+  expire  expire a suppression immediately
+
+The store defaults to s3://$suppressions_bucket/$suppressions_key, falling
+back to $s3_bucket for the bucket. Run "<command> -h" for the flags.
+`
+
+const rulesUsage = `usage: grace-config-differ rules -snapshot <snapshot.json> [-rules <rules.json>] <items.json>...
+
+Scores configuration items offline against the risk rules, as a report would,
+and prints every finding. Each items file holds a JSON list of configuration
+items, such as handler/testdata/test2_items.json, which are compared to the
+snapshot. Without -rules only the embedded rules are used.
+`
+
+// suppressCLI ... the suppress subcommand: adds, lists and expires entries
+// in the suppression store
+type suppressCLI struct {
+	Client s3iface.S3API
+	Out    io.Writer
+	Now    func() time.Time
+}
+
+// runCLI ... runs the subcommand named by the arguments, returning the exit code
+func runCLI(args []string) int {
+	if len(args) > 0 && args[0] == "rules" {
+		if err := runRules(args[1:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+
+		return 0
+	}
+
+	if len(args) == 0 || args[0] != "suppress" {
+		fmt.Fprintf(os.Stderr, "unknown command: %v\n\n%s\n%s", args, suppressUsage, rulesUsage)
+		return 2
+	}
+
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(envOr("AWS_DEFAULT_REGION", "us-east-1"))})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error creating new session: %v\n", err)
+		return 1
+	}
+
+	c := &suppressCLI{Client: s3.New(sess), Out: os.Stdout, Now: time.Now}
+	if err := c.run(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func (c *suppressCLI) run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing command\n\n%s", suppressUsage)
+	}
+
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	bucket := fs.String("bucket", envOr("suppressions_bucket", os.Getenv("s3_bucket")), "S3 bucket of the suppression store")
+	key := fs.String("key", os.Getenv("suppressions_key"), "S3 key of the suppression store")
+	kmsKeyArn := fs.String("kms-key-arn", os.Getenv("kms_key_arn"), "KMS key encrypting the suppression store")
+
+	var add suppression
+
+	var expires, id string
+
+	switch args[0] {
+	case "add":
+		fs.StringVar(&add.ResourceType, "resource-type", "", "glob matching the resource type, e.g. AWS::EC2::*")
+		fs.StringVar(&add.ResourceID, "resource-id", "", "glob matching the resource ID")
+		fs.StringVar(&add.Path, "path", "", "regular expression matching the property path, e.g. ^Configuration\\.ipPermissions")
+		fs.StringVar(&expires, "expires", "72h", "duration or RFC 3339 time when the suppression expires")
+		fs.StringVar(&add.AcknowledgedBy, "by", os.Getenv("USER"), "who acknowledged the change")
+		fs.StringVar(&add.Reason, "reason", "", "why the change is expected, e.g. a change ticket")
+	case "expire":
+		fs.StringVar(&id, "id", "", "ID of the suppression to expire")
+	case "list":
+	default:
+		return fmt.Errorf("unknown command: %s\n\n%s", args[0], suppressUsage)
+	}
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	if *bucket == "" || *key == "" {
+		return fmt.Errorf("the suppression store bucket and key are required")
+	}
+
+	store, err := getSuppressions(c.Client, *bucket, *key)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		if err := c.add(&store, add, expires); err != nil {
+			return err
+		}
+	case "expire":
+		if err := c.expire(&store, id); err != nil {
+			return err
+		}
+	default:
+		return c.list(&store)
+	}
+
+	return putSuppressions(c.Client, *bucket, *key, *kmsKeyArn, store)
+}
+
+func (c *suppressCLI) add(store *suppressionStore, s suppression, expires string) (err error) {
+	if s.ResourceType == "" && s.ResourceID == "" {
+		return fmt.Errorf("add requires -resource-type or -resource-id")
+	}
+
+	if s.AcknowledgedBy == "" || s.Reason == "" {
+		return fmt.Errorf("add requires -by and -reason")
+	}
+
+	now := c.Now().UTC()
+
+	if s.Expires, err = parseExpiry(expires, now); err != nil {
+		return err
+	}
+
+	if s.ID, err = newSuppressionID(); err != nil {
+		return err
+	}
+
+	s.Created = now
+
+	if err := s.compile(); err != nil {
+		return err
+	}
+
+	store.Suppressions = append(store.Suppressions, s)
+
+	fmt.Fprintf(c.Out, "added suppression %s, expires %s\n", s.ID, s.Expires.Format(time.RFC3339))
+
+	return nil
+}
+
+func (c *suppressCLI) expire(store *suppressionStore, id string) error {
+	for n := range store.Suppressions {
+		if s := &store.Suppressions[n]; s.ID == id {
+			s.Expires = c.Now().UTC()
+
+			fmt.Fprintf(c.Out, "expired suppression %s\n", id)
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("suppression not found: %q", id)
+}
+
+func (c *suppressCLI) list(store *suppressionStore) error {
+	w := tabwriter.NewWriter(c.Out, 0, 0, 2, ' ', 0)
+	now := c.Now()
+
+	fmt.Fprintln(w, "ID\tSTATUS\tRESOURCE TYPE\tRESOURCE ID\tPATH\tEXPIRES\tBY\tREASON")
+
+	for n := range store.Suppressions {
+		s := &store.Suppressions[n]
+
+		status := "expired"
+		if s.active(now) {
+			status = "active"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", s.ID, status, s.ResourceType, s.ResourceID, s.Path,
+			s.Expires.Format(time.RFC3339), s.AcknowledgedBy, s.Reason)
+	}
+
+	return w.Flush()
+}
+
+// parseExpiry ... an RFC 3339 time, or a duration from now
+func parseExpiry(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return time.Time{}, fmt.Errorf("invalid expiry %q: expected a positive duration or RFC 3339 time", s)
+	}
+
+	return now.Add(d), nil
+}
+
+func envOr(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+
+	return fallback
+}
+
+// runRules ... the rules subcommand: tests a rule set against fixture files
+// without AWS access, failing when a condition cannot be evaluated
+func runRules(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("rules", flag.ContinueOnError)
+	rulesFile := fs.String("rules", "", "JSON rule set, evaluated with the embedded rules unless include_defaults is false")
+	snapshotFile := fs.String("snapshot", "", "Config snapshot the items are compared to")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *snapshotFile == "" || fs.NArg() == 0 {
+		return fmt.Errorf("missing snapshot or items\n\n%s", rulesUsage)
+	}
+
+	rules, err := readRulesFile(*rulesFile)
+	if err != nil {
+		return err
+	}
+
+	known, err := readSnapshotFile(*snapshotFile)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	failures := 0
+
+	fmt.Fprintln(w, "RESOURCE\tTYPE\tSEVERITY\tRULE\tPATH\tREASON")
+
+	for _, f := range fs.Args() {
+		changed, err := readChangedItems(f, known)
+		if err != nil {
+			return fmt.Errorf("%s: %v", f, err)
+		}
+
+		failures += checkConditions(out, f, changed, rules)
+
+		scoreItems(changed, rules)
+
+		for _, i := range changed {
+			for _, r := range itemRisks(i) {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", resourceLabel(i), stringValue(i, "ResourceType"),
+					r.Severity, r.Rule, r.Path, r.Reason)
+			}
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if failures > 0 {
+		return fmt.Errorf("%d conditions could not be evaluated", failures)
+	}
+
+	return nil
+}
+
+// readRulesFile ... the embedded rules, extended or replaced by a rule set file
+func readRulesFile(path string) ([]riskRule, error) {
+	rules := defaultRiskRules()
+	if path == "" {
+		return rules, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseRiskRules(b, rules)
+}
+
+// readSnapshotFile ... the configuration items of a Config snapshot file
+func readSnapshotFile(path string) ([]map[string]interface{}, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := unmarshalSnapshot(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseItemsToMap(snapshot.ConfigurationItems)
+}
+
+// readChangedItems ... the items of a JSON file of configuration items that
+// changed since the snapshot
+func readChangedItems(path string, known []map[string]interface{}) ([]map[string]interface{}, error) {
+	var items []*configservice.ConfigurationItem
+
+	b, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &items)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	maps, err := parseItemsToMap(items)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffAgainstSnapshot(maps, known), nil
+}
+
+// checkConditions ... writes every rule condition that cannot be evaluated
+// for a change of the file, returning how many there were
+func checkConditions(out io.Writer, file string, changed []map[string]interface{}, rules []riskRule) int {
+	failures := 0
+
+	for _, i := range changed {
+		for _, c := range itemChanges(i) {
+			for n := range rules {
+				if _, err := rules[n].match(i, c); err != nil {
+					fmt.Fprintf(out, "%s: %s %s: %v\n", file, resourceLabel(i), c.Path, err)
+					failures++
+				}
+			}
+		}
+	}
+
+	return failures
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+)
+
+const (
+	actorsKey      = "Actors"
+	lookbackWindow = 15 // minutes before capture time to search for events by resource name
+	maxActors      = 5
+	lookupInterval = 500 * time.Millisecond // LookupEvents allows 2 requests per second per account and region
+)
+
+// errLookupLimit ... the lookups allowed in a run have been made
+var errLookupLimit = errors.New("CloudTrail lookup limit reached")
+
+// CloudTrailSvc ... provides interface to AWS CloudTrail. Lookups are spaced
+// by Interval and, when MaxLookups is set, limited to MaxLookups per run
+type CloudTrailSvc struct {
+	Client     cloudtrailiface.CloudTrailAPI
+	Interval   time.Duration
+	MaxLookups int
+	lookups    int
+	last       time.Time
+}
+
+// actor ... who made a change, from the CloudTrail event that caused it
+type actor struct {
+	EventID         string    `json:"eventId"`
+	EventName       string    `json:"eventName"`
+	EventTime       time.Time `json:"eventTime"`
+	PrincipalArn    string    `json:"principalArn"`
+	SourceIPAddress string    `json:"sourceIPAddress"`
+	UserAgent       string    `json:"userAgent"`
+}
+
+// cloudTrailEvent ... the fields of the CloudTrailEvent JSON document used for attribution
+type cloudTrailEvent struct {
+	UserIdentity struct {
+		Arn       string `json:"arn"`
+		Principal string `json:"principalId"`
+		Type      string `json:"type"`
+		InvokedBy string `json:"invokedBy"`
+	} `json:"userIdentity"`
+	SourceIPAddress string `json:"sourceIPAddress"`
+	UserAgent       string `json:"userAgent"`
+}
+
+// AttributeChanges ... adds the CloudTrail actors responsible for each changed
+// item under the "Actors" key, resolving the item's RelatedEvents or falling
+// back to write events on the resource shortly before it was captured. Items
+// whose lookups fail, or that are past the lookup limit, are left without
+// actors and counted in the error returned
+func (c *CloudTrailSvc) AttributeChanges(items []map[string]interface{}) error {
+	var (
+		failed  int
+		lastErr error
+	)
+
+	for _, i := range items {
+		if _, ok := i["diffs"]; !ok {
+			continue
+		}
+
+		actors, err := c.itemActors(i)
+		if err != nil {
+			if !errors.Is(err, errLookupLimit) {
+				log.Printf("error attributing %s, actor unknown: %v\n", resourceLabel(i), err)
+			}
+
+			failed++
+			lastErr = err
+
+			continue
+		}
+
+		if len(actors) > 0 {
+			i[actorsKey] = actors
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d changes not attributed: %w", failed, lastErr)
+	}
+
+	return nil
+}
+
+func (c *CloudTrailSvc) itemActors(i map[string]interface{}) ([]actor, error) {
+	var events []*cloudtrail.Event
+
+	related, _ := i["RelatedEvents"].([]interface{})
+	for _, e := range related {
+		id, ok := e.(string)
+		if !ok || id == "" {
+			continue
+		}
+
+		found, err := c.lookupEvents(&cloudtrail.LookupEventsInput{
+			LookupAttributes: []*cloudtrail.LookupAttribute{{
+				AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyEventId),
+				AttributeValue: aws.String(id),
+			}},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, found...)
+	}
+
+	if len(events) == 0 {
+		found, err := c.lookupResourceEvents(i)
+		if err != nil {
+			return nil, err
+		}
+
+		events = found
+	}
+
+	return eventsToActors(events), nil
+}
+
+// lookupResourceEvents ... write events naming the resource in the window
+// before the configuration item was captured
+func (c *CloudTrailSvc) lookupResourceEvents(i map[string]interface{}) ([]*cloudtrail.Event, error) {
+	captured, err := time.Parse(time.RFC3339, stringValue(i, "ConfigurationItemCaptureTime"))
+	if err != nil {
+		// no capture time to search around
+		return nil, nil //nolint:nilerr
+	}
+
+	var events []*cloudtrail.Event
+
+	for _, name := range uniqueStrings(stringValue(i, "ResourceId"), stringValue(i, "ResourceName")) {
+		found, err := c.lookupEvents(&cloudtrail.LookupEventsInput{
+			LookupAttributes: []*cloudtrail.LookupAttribute{{
+				AttributeKey:   aws.String(cloudtrail.LookupAttributeKeyResourceName),
+				AttributeValue: aws.String(name),
+			}},
+			StartTime: aws.Time(captured.Add(time.Minute * time.Duration(-lookbackWindow))),
+			EndTime:   aws.Time(captured),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, e := range found {
+			if aws.StringValue(e.ReadOnly) != "true" {
+				events = append(events, e)
+			}
+		}
+	}
+
+	return events, nil
+}
+
+func (c *CloudTrailSvc) lookupEvents(input *cloudtrail.LookupEventsInput) ([]*cloudtrail.Event, error) {
+	result, err := c.lookup(input)
+	if err != nil {
+		return nil, err
+	}
+
+	events := result.Events
+
+	for aws.StringValue(result.NextToken) != "" {
+		input.NextToken = result.NextToken
+
+		result, err = c.lookup(input)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, result.Events...)
+	}
+
+	return events, nil
+}
+
+// lookup ... one LookupEvents request, waiting out the interval since the
+// last one and failing once the lookup limit is reached
+func (c *CloudTrailSvc) lookup(input *cloudtrail.LookupEventsInput) (*cloudtrail.LookupEventsOutput, error) {
+	if c.MaxLookups > 0 && c.lookups >= c.MaxLookups {
+		return nil, errLookupLimit
+	}
+
+	if wait := c.Interval - time.Since(c.last); c.Interval > 0 && wait > 0 {
+		time.Sleep(wait)
+	}
+
+	c.last = time.Now()
+	c.lookups++
+
+	return c.Client.LookupEvents(input)
+}
+
+// eventsToActors ... converts events to actors, most recent first
+func eventsToActors(events []*cloudtrail.Event) []actor {
+	seen := make(map[string]bool)
+	actors := make([]actor, 0)
+
+	for _, e := range events {
+		id := aws.StringValue(e.EventId)
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+
+		var detail cloudTrailEvent
+
+		if err := json.Unmarshal([]byte(aws.StringValue(e.CloudTrailEvent)), &detail); err != nil {
+			log.Printf("error unmarshaling CloudTrail event %s: %v", id, err)
+		}
+
+		a := actor{
+			EventID:         id,
+			EventName:       aws.StringValue(e.EventName),
+			EventTime:       aws.TimeValue(e.EventTime),
+			PrincipalArn:    detail.UserIdentity.Arn,
+			SourceIPAddress: detail.SourceIPAddress,
+			UserAgent:       detail.UserAgent,
+		}
+
+		if a.PrincipalArn == "" {
+			a.PrincipalArn = firstNonEmpty(detail.UserIdentity.InvokedBy, aws.StringValue(e.Username))
+		}
+
+		actors = append(actors, a)
+	}
+
+	sort.SliceStable(actors, func(i, j int) bool {
+		return actors[i].EventTime.After(actors[j].EventTime)
+	})
+
+	if len(actors) > maxActors {
+		actors = actors[:maxActors]
+	}
+
+	return actors
+}
+
+// itemActors ... the actors attributed to an item, whether set by
+// AttributeChanges or read back from JSON
+func itemActors(i map[string]interface{}) []actor {
+	var actors []actor
+
+	switch v := i[actorsKey].(type) {
+	case []actor:
+		return v
+	case []interface{}:
+		decodeItemValue(v, &actors)
+	}
+
+	return actors
+}
+
+func uniqueStrings(s ...string) []string {
+	var u []string
+
+	seen := make(map[string]bool)
+
+	for _, v := range s {
+		if v != "" && !seen[v] {
+			seen[v] = true
+
+			u = append(u, v)
+		}
+	}
+
+	return u
+}
+
+func firstNonEmpty(s ...string) string {
+	for _, v := range s {
+		if v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
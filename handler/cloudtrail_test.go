@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
+	"github.com/aws/aws-sdk-go/service/cloudtrail/cloudtrailiface"
+)
+
+// AWS Service Mocks //
+type mockCloudTrailClient struct {
+	cloudtrailiface.CloudTrailAPI
+	Events map[string][]*cloudtrail.Event // keyed by lookup attribute value
+	Inputs []*cloudtrail.LookupEventsInput
+	Err    error
+}
+
+func (m *mockCloudTrailClient) LookupEvents(in *cloudtrail.LookupEventsInput) (*cloudtrail.LookupEventsOutput, error) {
+	m.Inputs = append(m.Inputs, in)
+	if m.Err != nil {
+		return nil, m.Err
+	}
+
+	events := m.Events[aws.StringValue(in.LookupAttributes[0].AttributeValue)]
+
+	// return one event per page to exercise paging
+	start := 0
+	if in.NextToken != nil {
+		start = len(aws.StringValue(in.NextToken))
+	}
+
+	out := &cloudtrail.LookupEventsOutput{}
+	if start < len(events) {
+		out.Events = events[start : start+1]
+		if start+1 < len(events) {
+			out.NextToken = aws.String(strings.Repeat("x", start+1))
+		}
+	}
+
+	return out, nil
+}
+
+// helper functions //
+func testEvent(id, name, arn, readOnly string, t time.Time) *cloudtrail.Event {
+	detail, _ := json.Marshal(map[string]interface{}{
+		"userIdentity":    map[string]interface{}{"arn": arn},
+		"sourceIPAddress": "192.0.2.1",
+		"userAgent":       "aws-cli/2.0",
+	})
+
+	return &cloudtrail.Event{
+		EventId:         aws.String(id),
+		EventName:       aws.String(name),
+		EventTime:       aws.Time(t),
+		ReadOnly:        aws.String(readOnly),
+		CloudTrailEvent: aws.String(string(detail)),
+	}
+}
+
+// test functions //
+func TestAttributeChanges(t *testing.T) {
+	captured := time.Date(2020, 1, 30, 13, 35, 19, 0, time.UTC)
+	user := "arn:aws:iam::123456789012:user/alice"
+	role := "arn:aws:sts::123456789012:assumed-role/deploy/bob"
+	events := map[string][]*cloudtrail.Event{
+		"e1":       {testEvent("e1", "PutBucketPolicy", user, "false", captured.Add(-time.Minute))},
+		"e2":       {testEvent("e2", "PutBucketTagging", role, "false", captured.Add(-time.Second))},
+		"bucket-1": {testEvent("e3", "GetBucketPolicy", user, "true", captured), testEvent("e4", "PutBucketAcl", role, "false", captured)},
+	}
+	tt := map[string]struct {
+		item      map[string]interface{}
+		expected  []string
+		byName    bool
+		attribute bool
+	}{
+		"related events": {
+			item: map[string]interface{}{
+				"ResourceId":    "bucket-1",
+				"RelatedEvents": []interface{}{"e1", "e2"},
+				"diffs":         map[string]interface{}{},
+			},
+			expected:  []string{"e2", "e1"},
+			attribute: true,
+		},
+		"fallback to resource name": {
+			item: map[string]interface{}{
+				"ResourceId":                   "bucket-1",
+				"ConfigurationItemCaptureTime": captured.Format(time.RFC3339),
+				"diffs":                        map[string]interface{}{},
+			},
+			expected:  []string{"e4"},
+			byName:    true,
+			attribute: true,
+		},
+		"no events": {
+			item: map[string]interface{}{
+				"ResourceId":                   "bucket-2",
+				"ConfigurationItemCaptureTime": captured.Format(time.RFC3339),
+				"diffs":                        map[string]interface{}{},
+			},
+			byName: true,
+		},
+		"new item": {
+			item: map[string]interface{}{
+				"ResourceId":    "bucket-1",
+				"RelatedEvents": []interface{}{"e1"},
+			},
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			m := &mockCloudTrailClient{Events: events}
+			c := CloudTrailSvc{Client: m}
+
+			err := c.AttributeChanges([]map[string]interface{}{tc.item})
+			if err != nil {
+				t.Fatalf("AttributeChanges() failed. Unexpected error: %v", err)
+			}
+
+			_, ok := tc.item[actorsKey]
+			if ok != tc.attribute {
+				t.Fatalf("AttributeChanges() failed. Expected %s set: %v, got: %v", actorsKey, tc.attribute, ok)
+			}
+
+			actors := itemActors(tc.item)
+			if len(actors) != len(tc.expected) {
+				t.Fatalf("AttributeChanges() failed. Expected %d actors, got: %v", len(tc.expected), actors)
+			}
+
+			for n, id := range tc.expected {
+				if actors[n].EventID != id {
+					t.Errorf("AttributeChanges() failed. Expected actor %d event %q, got: %q", n, id, actors[n].EventID)
+				}
+
+				if actors[n].PrincipalArn == "" || actors[n].SourceIPAddress != "192.0.2.1" || actors[n].UserAgent != "aws-cli/2.0" {
+					t.Errorf("AttributeChanges() failed. Incomplete actor: %#v", actors[n])
+				}
+			}
+
+			if tc.byName && len(m.Inputs) > 0 {
+				in := m.Inputs[0]
+				if aws.StringValue(in.LookupAttributes[0].AttributeKey) != cloudtrail.LookupAttributeKeyResourceName ||
+					!aws.TimeValue(in.EndTime).Equal(captured) ||
+					!aws.TimeValue(in.StartTime).Equal(captured.Add(-lookbackWindow*time.Minute)) {
+					t.Errorf("AttributeChanges() failed. Unexpected lookup: %v", in)
+				}
+			}
+		})
+	}
+}
+
+func TestAttributeChangesError(t *testing.T) {
+	captured := time.Date(2020, 1, 30, 13, 35, 19, 0, time.UTC)
+	events := map[string][]*cloudtrail.Event{
+		"e1": {testEvent("e1", "PutBucketPolicy", "arn:aws:iam::123456789012:user/alice", "false", captured)},
+	}
+	items := func() []map[string]interface{} {
+		return []map[string]interface{}{
+			{"ResourceId": "bucket-1", "RelatedEvents": []interface{}{"e1"}, "diffs": map[string]interface{}{}},
+			{"ResourceId": "bucket-2", "RelatedEvents": []interface{}{"e1"}, "diffs": map[string]interface{}{}},
+		}
+	}
+
+	i := items()
+	c := CloudTrailSvc{Client: &mockCloudTrailClient{Err: errors.New("throttled")}}
+
+	if err := c.AttributeChanges(i); err == nil || !strings.Contains(err.Error(), "2 changes not attributed: throttled") {
+		t.Errorf("AttributeChanges() failed. Expected every failed change counted, got: %v", err)
+	}
+
+	i = items()
+	m := &mockCloudTrailClient{Events: events}
+	c = CloudTrailSvc{Client: m, MaxLookups: 1}
+
+	if err := c.AttributeChanges(i); !errors.Is(err, errLookupLimit) || len(itemActors(i[0])) != 1 || i[1][actorsKey] != nil {
+		t.Errorf("AttributeChanges() failed. Expected the first change attributed within the lookup limit, got: %v, %v", err, i)
+	}
+
+	if len(m.Inputs) != 1 {
+		t.Errorf("AttributeChanges() failed. Expected 1 lookup, got: %d", len(m.Inputs))
+	}
+
+	start := time.Now()
+	c = CloudTrailSvc{Client: &mockCloudTrailClient{Events: events}, Interval: 20 * time.Millisecond}
+
+	chkErr(t, c.AttributeChanges(items()))
+
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("AttributeChanges() failed. Expected lookups spaced by the interval, took: %v", d)
+	}
+}
+
+func TestItemActorsRendering(t *testing.T) {
+	a := actor{
+		EventID:         "e1",
+		EventName:       "PutBucketPolicy",
+		EventTime:       time.Date(2020, 1, 30, 13, 30, 0, 0, time.UTC),
+		PrincipalArn:    "arn:aws:iam::123456789012:user/alice",
+		SourceIPAddress: "192.0.2.1",
+		UserAgent:       "<script>",
+	}
+
+	// round trip through JSON as the archived items.json would be read back
+	b, err := json.Marshal([]actor{a})
+	chkErr(t, err)
+
+	var decoded []interface{}
+
+	chkErr(t, json.Unmarshal(b, &decoded))
+
+	item := changedTestItem(t, `{"ResourceName": "old", "ResourceType": "AWS::S3::Bucket", "ResourceId": "bucket-1"}`,
+		`{"ResourceName": "bucket-1", "ResourceType": "AWS::S3::Bucket", "ResourceId": "bucket-1"}`)
+	item[actorsKey] = decoded
+
+	h, err := parseItemsToHTML([]map[string]interface{}{item})
+	chkErr(t, err)
+
+	for _, s := range []string{a.PrincipalArn, "PutBucketPolicy", "192.0.2.1", "&lt;script&gt;"} {
+		if !strings.Contains(h, s) {
+			t.Errorf("parseItemsToHTML() failed. Expected actor %q in: %s", s, h)
+		}
+	}
+
+	text, err := itemsToText([]map[string]interface{}{item}, 0)
+	chkErr(t, err)
+
+	if !strings.Contains(text, "Changed by "+a.PrincipalArn) {
+		t.Errorf("itemsToText() failed. Expected actor in: %s", text)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+// clusterIgnored ... top level properties that identify a configuration item
+// rather than describe its change, ignored when comparing changes
+var clusterIgnored = map[string]bool{
+	"Arn":                          true,
+	"ConfigurationItemCaptureTime": true,
+	"ConfigurationItemMD5Hash":     true,
+	"ConfigurationStateId":         true,
+	"RelatedEvents":                true,
+	"ResourceCreationTime":         true,
+	"ResourceId":                   true,
+	"ResourceName":                 true,
+	"Version":                      true,
+}
+
+// hasPropertyChanges ... whether any diff is to a property other than those
+// clusterIgnored lists, which change with every capture of the resource
+func hasPropertyChanges(diffs map[string]interface{}) bool {
+	for k := range diffs {
+		if !clusterIgnored[k] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clusterMember ... one of the resources sharing a clustered change
+type clusterMember struct {
+	Label      string
+	ConsoleURL string
+	Owner      string
+}
+
+// clusterItems ... groups the items whose property changes are identical,
+// apart from identity fields, in order of first appearance. Groups smaller
+// than the threshold are split back into single items; a threshold below 2
+// disables clustering
+func clusterItems(items []map[string]interface{}, threshold int) [][]map[string]interface{} {
+	clusters := make([][]map[string]interface{}, 0, len(items))
+	index := make(map[string]int)
+
+	for _, i := range items {
+		sig, ok := changeSignature(i)
+		if threshold < 2 || !ok {
+			clusters = append(clusters, []map[string]interface{}{i})
+			continue
+		}
+
+		n, seen := index[sig]
+		if !seen {
+			n = len(clusters)
+			index[sig] = n
+			clusters = append(clusters, nil)
+		}
+
+		clusters[n] = append(clusters[n], i)
+	}
+
+	split := make([][]map[string]interface{}, 0, len(clusters))
+
+	for _, c := range clusters {
+		if len(c) >= threshold {
+			split = append(split, c)
+			continue
+		}
+
+		for _, i := range c {
+			split = append(split, []map[string]interface{}{i})
+		}
+	}
+
+	return split
+}
+
+// changeSignature ... identifies an item's change by its resource type,
+// severity, maintenance window and property changes, ignoring identity
+// fields. Items without changes beyond identity fields are not clustered
+func changeSignature(i map[string]interface{}) (string, bool) {
+	item, ok := withoutIdentity(i)
+	if !ok || len(item["diffs"].(map[string]interface{})) == 0 {
+		return "", false
+	}
+
+	return compactJSON([]interface{}{
+		stringValue(i, "ResourceType"),
+		stringValue(i, severityKey),
+		stringValue(i, maintenanceKey),
+		terraformStatus(i),
+		itemAuthorization(i),
+		unexpectedReason(i),
+		signatureChanges(itemChanges(item)),
+	}), true
+}
+
+// signatureChanges ... the changes with the values of changed maps, such as
+// Tags, reduced to the keys added, removed or changed, so resources whose
+// untouched keys differ, e.g. their Name tag, share the same edit
+func signatureChanges(changes []propertyChange) []propertyChange {
+	for k, c := range changes {
+		previous, ok := c.Previous.(map[string]interface{})
+		current, isMap := c.Current.(map[string]interface{})
+
+		if ok && isMap {
+			changes[k].Previous, changes[k].Current = mapDelta(previous, current)
+		}
+	}
+
+	return changes
+}
+
+// mapDelta ... the previous and current values of the keys that differ
+// between the maps, a key missing from one side being added or removed
+func mapDelta(previous, current map[string]interface{}) (map[string]interface{}, map[string]interface{}) {
+	p := make(map[string]interface{})
+	c := make(map[string]interface{})
+
+	for _, k := range sortedKeys(previous, current) {
+		pv, inPrevious := previous[k]
+		cv, inCurrent := current[k]
+
+		if inPrevious && inCurrent && compactJSON(pv) == compactJSON(cv) {
+			continue
+		}
+
+		if inPrevious {
+			p[k] = pv
+		}
+
+		if inCurrent {
+			c[k] = cv
+		}
+	}
+
+	return p, c
+}
+
+// withoutIdentity ... a copy of the item whose diffs omit its identity fields
+func withoutIdentity(i map[string]interface{}) (map[string]interface{}, bool) {
+	diffs, ok := i["diffs"].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	filtered := make(map[string]interface{}, len(diffs))
+
+	for k, v := range diffs {
+		if !clusterIgnored[k] {
+			filtered[k] = v
+		}
+	}
+
+	item := make(map[string]interface{}, len(i))
+
+	for k, v := range i {
+		item[k] = v
+	}
+
+	item["diffs"] = filtered
+
+	return item, true
+}
+
+// clusterRepresentative ... the item rendered for a cluster: the first
+// member without its identity fields or the details that differ between
+// members, which are listed with each member instead
+func clusterRepresentative(cluster []map[string]interface{}) map[string]interface{} {
+	item, _ := withoutIdentity(cluster[0])
+
+	for _, k := range []string{ownerKey, ownerSourceKey, actorsKey, consoleURLKey, timelineURLKey} {
+		delete(item, k)
+	}
+
+	reduceMapChanges(item)
+
+	// members share the Terraform status but not the state address
+	if status := terraformStatus(item); status != "" {
+		item[terraformKey] = &terraformMatch{Status: status}
+	}
+
+	return item
+}
+
+// reduceMapChanges ... replaces the values of the changed maps of the item,
+// at the top level and in its configuration, with the keys that changed, as
+// the members only share those
+func reduceMapChanges(item map[string]interface{}) {
+	diffs := item["diffs"].(map[string]interface{})
+
+	for k, d := range diffs {
+		if !isConfigurationKey(k) {
+			if p, c := reduceMaps(d, item[k]); p != nil {
+				diffs[k], item[k] = p, c
+			}
+
+			continue
+		}
+
+		nested, _ := d.(map[string]interface{})
+		properties, _ := nested["diffs"].(map[string]interface{})
+		current, _ := item[k].(map[string]interface{})
+
+		if properties == nil || current == nil {
+			continue
+		}
+
+		reducedDiffs := make(map[string]interface{}, len(properties))
+		reduced := make(map[string]interface{}, len(current))
+
+		for name, v := range current {
+			reduced[name] = v
+		}
+
+		for name, v := range properties {
+			reducedDiffs[name] = v
+
+			if p, c := reduceMaps(v, current[name]); p != nil {
+				reducedDiffs[name], reduced[name] = p, c
+			}
+		}
+
+		diffs[k] = map[string]interface{}{"diffs": reducedDiffs}
+		item[k] = reduced
+	}
+}
+
+// reduceMaps ... the delta of the values when both are maps, nil otherwise
+func reduceMaps(previous, current interface{}) (map[string]interface{}, map[string]interface{}) {
+	p, ok := previous.(map[string]interface{})
+	c, isMap := current.(map[string]interface{})
+
+	if !ok || !isMap {
+		return nil, nil
+	}
+
+	return mapDelta(p, c)
+}
+
+// clusterMembers ... the resources of a cluster
+func clusterMembers(cluster []map[string]interface{}) []clusterMember {
+	members := make([]clusterMember, 0, len(cluster))
+
+	for _, i := range cluster {
+		members = append(members, clusterMember{
+			Label:      resourceLabel(i),
+			ConsoleURL: stringValue(i, consoleURLKey),
+			Owner:      stringValue(i, ownerKey),
+		})
+	}
+
+	return members
+}
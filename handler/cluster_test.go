@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// helper functions //
+func clusterTestItem(t *testing.T, id, description string) map[string]interface{} {
+	item := `{"ResourceType": "AWS::EC2::SecurityGroup", "ResourceId": %q,
+		"ConfigurationItemCaptureTime": %q, "Configuration": {"description": %q}}`
+
+	return changedTestItem(t, fmt.Sprintf(item, id, "2020-03-07T00:00:00Z", "old"),
+		fmt.Sprintf(item, id, fmt.Sprintf("2020-03-08T0%d:00:00Z", len(id)), description))
+}
+
+// test functions //
+func TestClusterItems(t *testing.T) {
+	items := []map[string]interface{}{
+		clusterTestItem(t, "sg-1", "new"),
+		clusterTestItem(t, "sg-22", "other"),
+		clusterTestItem(t, "sg-333", "new"),
+		clusterTestItem(t, "sg-4444", "new"),
+	}
+
+	tt := map[string]struct {
+		threshold int
+		expected  []int
+	}{
+		"disabled":          {0, []int{1, 1, 1, 1}},
+		"clustered":         {3, []int{3, 1}},
+		"below threshold":   {4, []int{1, 1, 1, 1}},
+		"pairs":             {2, []int{3, 1}},
+		"threshold of one":  {1, []int{1, 1, 1, 1}},
+		"negative disables": {-1, []int{1, 1, 1, 1}},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			clusters := clusterItems(items, tc.threshold)
+
+			sizes := make([]int, 0, len(clusters))
+			for _, c := range clusters {
+				sizes = append(sizes, len(c))
+			}
+
+			if fmt.Sprint(sizes) != fmt.Sprint(tc.expected) {
+				t.Errorf("clusterItems() failed. Expected cluster sizes %v, got: %v", tc.expected, sizes)
+			}
+		})
+	}
+
+	// new items have no diffs to compare
+	if clusters := clusterItems([]map[string]interface{}{{"ResourceId": "a"}, {"ResourceId": "b"}}, 2); len(clusters) != 2 {
+		t.Errorf("clusterItems() failed. Expected new items to stay separate, got: %v", clusters)
+	}
+}
+
+func TestClusterRendering(t *testing.T) {
+	items := []map[string]interface{}{
+		clusterTestItem(t, "sg-1", "new"),
+		clusterTestItem(t, "sg-22", "new"),
+		clusterTestItem(t, "sg-333", "new"),
+	}
+	items[0][ownerKey] = "team@example.com"
+	items[0][consoleURLKey] = "https://console/sg-1"
+
+	r := &report{Items: items, ClusterThreshold: 3}
+
+	html, err := reportToHTML(r)
+	chkErr(t, err)
+
+	for _, s := range []string{"3 resources", "<th>Resources</th>", `<a href="https://console/sg-1">sg-1</a> (team@example.com), sg-22, sg-333`} {
+		if !strings.Contains(html, s) {
+			t.Errorf("reportToHTML() failed. Expected %q in:\n%s", s, html)
+		}
+	}
+
+	if strings.Count(html, "<th>description</th>") != 1 || strings.Contains(html, "<th>ConfigurationItemCaptureTime</th>") {
+		t.Errorf("reportToHTML() failed. Expected the change once without identity fields:\n%s", html)
+	}
+
+	text, err := reportToText(r)
+	chkErr(t, err)
+
+	for _, s := range []string{"3 resources (AWS::EC2::SecurityGroup)", "Resources: sg-1, sg-22, sg-333"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("reportToText() failed. Expected %q in:\n%s", s, text)
+		}
+	}
+
+	if strings.Contains(text, "Owner:") {
+		t.Errorf("reportToText() failed. Expected no owner for a cluster:\n%s", text)
+	}
+
+	// the JSON attachment keeps every resource
+	if len(r.Items) != 3 || resourceLabel(r.Items[2]) != "sg-333" {
+		t.Errorf("clusterItems() failed. Expected the report items to be unchanged, got: %v", r.Items)
+	}
+}
+
+func TestClusterMapChanges(t *testing.T) {
+	eni := func(id, name, env string) map[string]interface{} {
+		return changedTestItem(t,
+			fmt.Sprintf(`{"ResourceType": "AWS::EC2::NetworkInterface", "ResourceId": %q, "Tags": {"Name": %q}}`, id, name),
+			fmt.Sprintf(`{"ResourceType": "AWS::EC2::NetworkInterface", "ResourceId": %q, "Tags": {"Name": %q, "Env": %q}}`,
+				id, name, env))
+	}
+
+	items := []map[string]interface{}{eni("eni-1", "web", "prod"), eni("eni-2", "db", "prod"), eni("eni-3", "cache", "dev"),
+		eni("eni-4", "queue", "prod")}
+
+	clusters := clusterItems(items, 3)
+	if len(clusters) != 2 || len(clusters[0]) != 3 || resourceLabel(clusters[1][0]) != "eni-3" {
+		t.Fatalf("clusterItems() failed. Expected the interfaces gaining Env=prod clustered despite their Name tags, got: %v", clusters)
+	}
+
+	rep := clusterRepresentative(clusters[0])
+	if compactJSON(rep["Tags"]) != `{"Env":"prod"}` || compactJSON(rep["diffs"].(map[string]interface{})["Tags"]) != `{}` {
+		t.Errorf("clusterRepresentative() failed. Expected only the added tag, got: %v", rep)
+	}
+
+	if compactJSON(items[0]["Tags"]) != `{"Env":"prod","Name":"web"}` {
+		t.Errorf("clusterRepresentative() failed. Expected the members unchanged, got: %v", items[0]["Tags"])
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule ... a standard five field cron expression (minute, hour, day of
+// month, month, day of week) evaluated in UTC. Fields accept *, lists, ranges
+// and steps; months and days of the week also accept three letter names
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+
+	// when both day fields are restricted either may match, as in cron
+	domStar, dowStar bool
+}
+
+var (
+	cronMonths = []string{"", "JAN", "FEB", "MAR", "APR", "MAY", "JUN", "JUL", "AUG", "SEP", "OCT", "NOV", "DEC"}
+	cronDays   = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+)
+
+// parseCron ... parses a five field cron expression
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	s := &cronSchedule{domStar: fields[2] == "*", dowStar: fields[4] == "*"}
+
+	for _, f := range []struct {
+		field       string
+		bits        *uint64
+		first, last int
+		names       []string
+	}{
+		{fields[0], &s.minute, 0, 59, nil},
+		{fields[1], &s.hour, 0, 23, nil},
+		{fields[2], &s.dom, 1, 31, nil},
+		{fields[3], &s.month, 1, 12, cronMonths},
+		{fields[4], &s.dow, 0, 7, cronDays},
+	} {
+		bits, err := parseCronField(f.field, f.first, f.last, f.names)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %v", expr, err)
+		}
+
+		*f.bits = bits
+	}
+
+	// 7 is another name for Sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+func parseCronField(field string, first, last int, names []string) (bits uint64, err error) {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+
+		if n := strings.Index(part, "/"); n >= 0 {
+			if step, err = strconv.Atoi(part[n+1:]); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+
+			part = part[:n]
+		}
+
+		lo, hi := first, last
+
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			if lo, err = cronValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+
+			hi = lo
+
+			if len(bounds) == 2 {
+				if hi, err = cronValue(bounds[1], names); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// a/n runs from a to the end of the range
+				hi = last
+			}
+		}
+
+		if lo < first || hi > last || lo > hi {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, first, last)
+		}
+
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+
+	return bits, nil
+}
+
+func cronValue(s string, names []string) (int, error) {
+	for n, name := range names {
+		if name != "" && strings.EqualFold(s, name) {
+			return n, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+
+	return v, nil
+}
+
+// matches ... whether the schedule fires at the minute of t
+func (s *cronSchedule) matches(t time.Time) bool {
+	t = t.UTC()
+
+	if s.minute&(1<<t.Minute()) == 0 || s.hour&(1<<t.Hour()) == 0 || s.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+
+	return dom || dow
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronSchedule(t *testing.T) {
+	// 2020-03-08 is a Sunday
+	sunday := time.Date(2020, 3, 8, 2, 30, 0, 0, time.UTC)
+	tt := map[string]struct {
+		expr     string
+		time     time.Time
+		expected bool
+	}{
+		"every minute":           {"* * * * *", sunday, true},
+		"named day and hour":     {"30 2 * * SUN", sunday, true},
+		"seven is sunday":        {"30 2 * * 7", sunday, true},
+		"wrong day":              {"30 2 * * MON-FRI", sunday, false},
+		"step":                   {"*/15 * * * *", sunday, true},
+		"step misses":            {"*/20 * * * *", sunday, false},
+		"list and range":         {"0,30 1-3 * mar *", sunday, true},
+		"day of month or week":   {"30 2 1 * SUN", sunday, true},
+		"day of month restricts": {"30 2 1 * *", sunday, false},
+		"offset step":            {"10/20 * * * *", sunday, true},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			s, err := parseCron(tc.expr)
+			chkErr(t, err)
+
+			if got := s.matches(tc.time); got != tc.expected {
+				t.Errorf("matches() failed. Expected %v for %q at %v, got: %v", tc.expected, tc.expr, tc.time, got)
+			}
+		})
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "* * * * FUNDAY", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron() failed. Expected error for %q", expr)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"sort"
+)
+
+const (
+	deploymentKey      = "Deployment"
+	deploymentToolKey  = "DeploymentTool"
+	stackNameTag       = "aws:cloudformation:stack-name"
+	stackResourceType  = "AWS::CloudFormation::Stack"
+	toolCloudFormation = "CloudFormation"
+	toolTerraform      = "Terraform"
+)
+
+// deployment ... the CloudFormation stack or Terraform workspace that deployed
+// a resource, empty for resources changed outside of either
+type deployment struct {
+	Tool string
+	Name string
+}
+
+// Title ... the heading of the deployment's changes in reports
+func (d deployment) Title() string {
+	switch d.Tool {
+	case toolCloudFormation:
+		return "CloudFormation stack " + d.Name
+	case toolTerraform:
+		return "Terraform workspace " + d.Name
+	}
+
+	return "Out-of-band changes"
+}
+
+// deploymentGroup ... the changes to the resources of one deployment
+type deploymentGroup struct {
+	deployment
+	Items []map[string]interface{}
+}
+
+// resolveDeployments ... sets the deployment of each item from its stack name
+// or workspace tags, falling back to the tags of the same resource in the
+// known items so deleted resources keep the deployment they were part of
+func resolveDeployments(items, known []map[string]interface{}, cfg *config) {
+	index := make(map[string]deployment, len(known))
+
+	for _, k := range known {
+		if _, ok := index[resourceKey(k)]; ok {
+			continue
+		}
+
+		if d := taggedDeployment(k, cfg.WorkspaceTags); d.Tool != "" {
+			index[resourceKey(k)] = d
+		}
+	}
+
+	for _, i := range items {
+		d := taggedDeployment(i, cfg.WorkspaceTags)
+		if d.Tool == "" {
+			d = index[resourceKey(i)]
+		}
+
+		if d.Tool != "" {
+			i[deploymentKey] = d.Name
+			i[deploymentToolKey] = d.Tool
+		}
+	}
+}
+
+func taggedDeployment(i map[string]interface{}, workspaceTags []string) deployment {
+	if stack, _ := firstTag(i, []string{stackNameTag}); stack != "" {
+		return deployment{Tool: toolCloudFormation, Name: stack}
+	}
+
+	if stringValue(i, "ResourceType") == stackResourceType && resourceLabel(i) != "" {
+		return deployment{Tool: toolCloudFormation, Name: resourceLabel(i)}
+	}
+
+	if workspace, _ := firstTag(i, workspaceTags); workspace != "" {
+		return deployment{Tool: toolTerraform, Name: workspace}
+	}
+
+	return deployment{}
+}
+
+// itemDeployment ... the deployment set by resolveDeployments
+func itemDeployment(i map[string]interface{}) deployment {
+	return deployment{Tool: stringValue(i, deploymentToolKey), Name: stringValue(i, deploymentKey)}
+}
+
+// groupByDeployment ... groups the items by deployment, with the out-of-band
+// changes first and then each stack and workspace by name. Returns nil when
+// no item belongs to a deployment, so reports of accounts not managed by
+// CloudFormation or Terraform are not grouped
+func groupByDeployment(items []map[string]interface{}) []deploymentGroup {
+	index := make(map[deployment]int)
+
+	var groups []deploymentGroup
+
+	deployed := false
+
+	for _, i := range items {
+		d := itemDeployment(i)
+		deployed = deployed || d.Tool != ""
+
+		n, ok := index[d]
+		if !ok {
+			n = len(groups)
+			index[d] = n
+			groups = append(groups, deploymentGroup{deployment: d})
+		}
+
+		groups[n].Items = append(groups[n].Items, i)
+	}
+
+	if !deployed {
+		return nil
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		a, b := groups[i].deployment, groups[j].deployment
+		if a.Tool != b.Tool {
+			return a.Tool < b.Tool
+		}
+
+		return a.Name < b.Name
+	})
+
+	return groups
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestResolveDeployments(t *testing.T) {
+	cfg := &config{WorkspaceTags: []string{"Workspace"}}
+	snapshot := ownerTestItem("AWS::EC2::Volume", "vol-gone", map[string]interface{}{stackNameTag: "data"})
+	tt := map[string]struct {
+		item     map[string]interface{}
+		expected deployment
+	}{
+		"stack tag": {
+			item:     ownerTestItem("AWS::EC2::Instance", "i-1", map[string]interface{}{stackNameTag: "web", "Workspace": "prod"}),
+			expected: deployment{Tool: toolCloudFormation, Name: "web"},
+		},
+		"stack resource": {
+			item:     ownerTestItem(stackResourceType, "web", nil),
+			expected: deployment{Tool: toolCloudFormation, Name: "web"},
+		},
+		"workspace tag": {
+			item:     ownerTestItem("AWS::S3::Bucket", "logs", map[string]interface{}{"workspace": "prod"}),
+			expected: deployment{Tool: toolTerraform, Name: "prod"},
+		},
+		"deleted resource": {
+			item:     ownerTestItem("AWS::EC2::Volume", "vol-gone", nil),
+			expected: deployment{Tool: toolCloudFormation, Name: "data"},
+		},
+		"out of band": {
+			item: ownerTestItem("AWS::EC2::Volume", "vol-1", map[string]interface{}{"Name": "scratch"}),
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			resolveDeployments([]map[string]interface{}{tc.item}, []map[string]interface{}{snapshot}, cfg)
+
+			if d := itemDeployment(tc.item); d != tc.expected {
+				t.Errorf("resolveDeployments() failed. Expected %+v, got: %+v", tc.expected, d)
+			}
+		})
+	}
+}
+
+func TestGroupByDeployment(t *testing.T) {
+	item := func(id, tool, name string) map[string]interface{} {
+		queue := `{"ResourceType": "AWS::SQS::Queue", "ResourceId": %q, "Configuration": {"delay": %d}}`
+		i := changedTestItem(t, fmt.Sprintf(queue, id, 0), fmt.Sprintf(queue, id, 5))
+
+		if tool != "" {
+			i[deploymentToolKey], i[deploymentKey] = tool, name
+		}
+
+		return i
+	}
+
+	if groups := groupByDeployment([]map[string]interface{}{item("q-1", "", "")}); groups != nil {
+		t.Errorf("groupByDeployment() failed. Expected no groups without deployments, got: %v", groups)
+	}
+
+	items := []map[string]interface{}{
+		item("q-1", toolTerraform, "prod"),
+		item("q-2", toolCloudFormation, "web"),
+		item("q-3", "", ""),
+		item("q-4", toolTerraform, "prod"),
+	}
+
+	groups := groupByDeployment(items)
+
+	var titles []string
+	for _, g := range groups {
+		titles = append(titles, g.Title())
+	}
+
+	expected := "Out-of-band changes, CloudFormation stack web, Terraform workspace prod"
+	if strings.Join(titles, ", ") != expected || len(groups[2].Items) != 2 {
+		t.Errorf("groupByDeployment() failed. Expected %s, got: %v", expected, titles)
+	}
+
+	r := &report{Items: items}
+
+	html, err := reportToHTML(r)
+	chkErr(t, err)
+
+	text, err := reportToText(r)
+	chkErr(t, err)
+
+	for name, body := range map[string]string{"reportToHTML": html, "reportToText": text} {
+		for _, s := range append(titles, "outside of the deployment pipeline") {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s() failed. Expected %q in:\n%s", name, s, body)
+			}
+		}
+
+		if strings.Index(body, "Terraform workspace prod") > strings.Index(body, "q-4") {
+			t.Errorf("%s() failed. Expected q-4 under its workspace in:\n%s", name, body)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	diffContext  = 3    // unchanged lines shown around each change in a unified diff
+	maxDiffEdits = 1000 // edits searched for before the changed lines are replaced wholesale
+	opEqual      = ' '
+	opDelete     = '-'
+	opInsert     = '+'
+)
+
+// diffLine ... a single line of a line-oriented diff
+type diffLine struct {
+	Op   byte
+	Text string
+}
+
+// hunk ... a group of changed lines and their surrounding context
+type hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []diffLine
+}
+
+// lineDiff ... computes the shortest edit script between two slices of lines
+// using the Myers O(ND) algorithm. Its trace takes O(D²) memory, so when the
+// script needs more than maxDiffEdits edits the lines between the common
+// prefix and suffix are replaced wholesale instead
+func lineDiff(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	maxD := n + m
+
+	if maxD > maxDiffEdits {
+		maxD = maxDiffEdits
+	}
+
+	offset := maxD + 1
+	v := make([]int, 2*maxD+4)
+	trace := make([][]int, 0)
+
+	for d := 0; d <= maxD; d++ {
+		// only diagonals -d-1 through d+1 are read when backtracking
+		vc := make([]int, 2*d+3)
+		copy(vc, v[offset-d-1:offset+d+2])
+		trace = append(trace, vc)
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, d)
+			}
+		}
+	}
+
+	return replaceLines(a, b)
+}
+
+// replaceLines ... a diff keeping the common prefix and suffix of the lines
+// and replacing every line between them
+func replaceLines(a, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]diffLine, 0, len(a)+len(b)-prefix-suffix)
+
+	for _, l := range a[:prefix] {
+		lines = append(lines, diffLine{Op: opEqual, Text: l})
+	}
+
+	for _, l := range a[prefix : len(a)-suffix] {
+		lines = append(lines, diffLine{Op: opDelete, Text: l})
+	}
+
+	for _, l := range b[prefix : len(b)-suffix] {
+		lines = append(lines, diffLine{Op: opInsert, Text: l})
+	}
+
+	for _, l := range a[len(a)-suffix:] {
+		lines = append(lines, diffLine{Op: opEqual, Text: l})
+	}
+
+	return lines
+}
+
+func backtrack(a, b []string, trace [][]int, d int) []diffLine {
+	x, y := len(a), len(b)
+	lines := make([]diffLine, 0, x+y)
+
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[d+k] < v[d+k+2]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+
+		prevX := v[d+1+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			lines = append(lines, diffLine{Op: opEqual, Text: a[x]})
+		}
+
+		if x == prevX {
+			y--
+			lines = append(lines, diffLine{Op: opInsert, Text: b[y]})
+		} else {
+			x--
+			lines = append(lines, diffLine{Op: opDelete, Text: a[x]})
+		}
+	}
+
+	for x > 0 {
+		x--
+		lines = append(lines, diffLine{Op: opEqual, Text: a[x]})
+	}
+
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+
+	return lines
+}
+
+// diffHunks ... groups a diff into hunks with the given number of context
+// lines, dropping unchanged regions between them
+func diffHunks(lines []diffLine, context int) []hunk {
+	var hunks []hunk
+
+	oldLine, newLine := 1, 1
+	i := 0
+
+	for i < len(lines) {
+		if lines[i].Op == opEqual {
+			oldLine++
+			newLine++
+			i++
+
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		h := hunk{OldStart: oldLine - (i - start), NewStart: newLine - (i - start)}
+		h.Lines = append(h.Lines, lines[start:i]...)
+		h.OldLines, h.NewLines = i-start, i-start
+
+		// extend the hunk until a run of unchanged lines longer than twice the context
+		for i < len(lines) {
+			if lines[i].Op == opEqual {
+				run := i
+				for run < len(lines) && lines[run].Op == opEqual {
+					run++
+				}
+
+				if run == len(lines) || run-i > 2*context {
+					end := i + context
+					if end > run {
+						end = run
+					}
+
+					h.Lines = append(h.Lines, lines[i:end]...)
+					h.OldLines += end - i
+					h.NewLines += end - i
+					oldLine += run - i
+					newLine += run - i
+					i = run
+
+					break
+				}
+			}
+
+			switch lines[i].Op {
+			case opEqual:
+				h.OldLines++
+				h.NewLines++
+				oldLine++
+				newLine++
+			case opDelete:
+				h.OldLines++
+				oldLine++
+			case opInsert:
+				h.NewLines++
+				newLine++
+			}
+
+			h.Lines = append(h.Lines, lines[i])
+			i++
+		}
+
+		hunks = append(hunks, h)
+	}
+
+	return hunks
+}
+
+// unifiedDiff ... returns a unified diff of two multi-line strings
+func unifiedDiff(a, b string, context int) string {
+	hunks := diffHunks(lineDiff(splitLines(a), splitLines(b)), context)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString("--- previous\n+++ current\n")
+
+	for _, h := range hunks {
+		sb.WriteString(h.Header() + "\n")
+
+		for _, l := range h.Lines {
+			sb.WriteByte(l.Op)
+			sb.WriteString(l.Text + "\n")
+		}
+	}
+
+	return sb.String()
+}
+
+// truncateHunks ... keeps at most limit lines of the hunks, returning the
+// number of lines dropped; limit <= 0 keeps every line
+func truncateHunks(hunks []hunk, limit int) ([]hunk, int) {
+	if limit <= 0 {
+		return hunks, 0
+	}
+
+	var (
+		kept    []hunk
+		omitted int
+	)
+
+	for _, h := range hunks {
+		switch {
+		case limit <= 0:
+			omitted += len(h.Lines)
+		case len(h.Lines) > limit:
+			omitted += len(h.Lines) - limit
+			h.Lines = h.Lines[:limit]
+			kept = append(kept, h)
+			limit = 0
+		default:
+			kept = append(kept, h)
+			limit -= len(h.Lines)
+		}
+	}
+
+	return kept, omitted
+}
+
+// Class ... the CSS class used to color the line in HTML reports
+func (l diffLine) Class() string {
+	switch l.Op {
+	case opDelete:
+		return "del"
+	case opInsert:
+		return "ins"
+	}
+
+	return "ctx"
+}
+
+// Prefix ... the unified diff prefix of the line
+func (l diffLine) Prefix() string {
+	return string(l.Op)
+}
+
+// Header ... the unified diff hunk header, e.g. "@@ -1,4 +1,5 @@"
+func (h hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+}
+
+func hunkRange(start, lines int) string {
+	switch lines {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprintf("%d", start)
+	}
+
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tt := map[string]struct {
+		a        string
+		b        string
+		expected string
+	}{
+		"equal": {
+			a:        "a\nb\nc",
+			b:        "a\nb\nc",
+			expected: "",
+		},
+		"change": {
+			a: "a\nb\nc",
+			b: "a\nx\nc",
+			expected: `--- previous
++++ current
+@@ -1,3 +1,3 @@
+ a
+-b
++x
+ c
+`,
+		},
+		"insert into empty": {
+			a: "",
+			b: "a",
+			expected: `--- previous
++++ current
+@@ -0,0 +1 @@
++a
+`,
+		},
+		"collapse": {
+			a: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12",
+			b: "0\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n13",
+			expected: `--- previous
++++ current
+@@ -1,4 +1,4 @@
+-1
++0
+ 2
+ 3
+ 4
+@@ -9,4 +9,4 @@
+ 9
+ 10
+ 11
+-12
++13
+`,
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			d := unifiedDiff(tc.a, tc.b, diffContext)
+			if d != tc.expected {
+				t.Errorf("unifiedDiff() failed. Expected:\n%s\nGot:\n%s", tc.expected, d)
+			}
+		})
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	a := strings.Split("a b c a b b a", " ")
+	b := strings.Split("c b a b a c", " ")
+
+	lines := lineDiff(a, b)
+
+	var old, newer []string
+
+	for _, l := range lines {
+		switch l.Op {
+		case opEqual:
+			old = append(old, l.Text)
+			newer = append(newer, l.Text)
+		case opDelete:
+			old = append(old, l.Text)
+		case opInsert:
+			newer = append(newer, l.Text)
+		}
+	}
+
+	if strings.Join(old, " ") != strings.Join(a, " ") || strings.Join(newer, " ") != strings.Join(b, " ") {
+		t.Errorf("lineDiff() failed. Edit script does not reproduce inputs: %v", lines)
+	}
+
+	// the shortest edit script for this classic example has five edits
+	edits := 0
+
+	for _, l := range lines {
+		if l.Op != opEqual {
+			edits++
+		}
+	}
+
+	if edits != 5 {
+		t.Errorf("lineDiff() failed. Expected 5 edits, got %d", edits)
+	}
+}
+
+func TestLineDiffFallback(t *testing.T) {
+	a := []string{"{"}
+	b := []string{"{"}
+
+	for n := 0; n < maxDiffEdits; n++ {
+		a = append(a, fmt.Sprintf("old %d", n))
+		b = append(b, fmt.Sprintf("new %d", n))
+	}
+
+	a, b = append(a, "}"), append(b, "}")
+
+	lines := lineDiff(a, b)
+
+	if len(lines) != 2*maxDiffEdits+2 || lines[0].Text != "{" || lines[0].Op != opEqual || lines[len(lines)-1].Op != opEqual ||
+		lines[1].Op != opDelete || lines[maxDiffEdits].Op != opDelete || lines[maxDiffEdits+1].Op != opInsert {
+		t.Errorf("lineDiff() failed. Expected the changed lines replaced between the common prefix and suffix, got %d lines", len(lines))
+	}
+}
+
+func TestTruncateHunks(t *testing.T) {
+	hunks := []hunk{
+		{Lines: []diffLine{{opEqual, "a"}, {opDelete, "b"}, {opInsert, "c"}}},
+		{Lines: []diffLine{{opDelete, "d"}, {opInsert, "e"}}},
+	}
+	tt := map[string]struct {
+		max     int
+		hunks   int
+		lines   int
+		omitted int
+	}{
+		"no limit":     {max: 0, hunks: 2, lines: 5},
+		"under limit":  {max: 10, hunks: 2, lines: 5},
+		"at boundary":  {max: 3, hunks: 1, lines: 3, omitted: 2},
+		"within hunk":  {max: 4, hunks: 2, lines: 4, omitted: 1},
+		"single line":  {max: 1, hunks: 1, lines: 1, omitted: 4},
+		"exact length": {max: 5, hunks: 2, lines: 5},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			kept, omitted := truncateHunks(hunks, tc.max)
+
+			lines := 0
+			for _, h := range kept {
+				lines += len(h.Lines)
+			}
+
+			if len(kept) != tc.hunks || lines != tc.lines || omitted != tc.omitted {
+				t.Errorf("truncateHunks() failed. Expected %d hunks, %d lines, %d omitted. Got: %d, %d, %d",
+					tc.hunks, tc.lines, tc.omitted, len(kept), lines, omitted)
+			}
+		})
+	}
+
+	if len(hunks[0].Lines) != 3 {
+		t.Errorf("truncateHunks() failed. Input hunks were modified")
+	}
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// Digest names accepted in the schedule payload
+const (
+	digestDaily  = "daily"
+	digestWeekly = "weekly"
+)
+
+const (
+	digestStepsKey       = "DigestSteps"
+	digestSourceArchive  = "archived change sets"
+	digestSourceSnapshot = "the first and last snapshots"
+)
+
+// digestEvent ... the constant input of the EventBridge schedule invoking a
+// digest, e.g. {"digest": "daily"}, the component inventory, e.g.
+// {"inventory": true}, or the report of held changes, {"held_changes": true}.
+// The period ends at the start of the current hour unless an end time is given
+type digestEvent struct {
+	Digest      string     `json:"digest"`
+	Inventory   bool       `json:"inventory"`
+	HeldChanges bool       `json:"held_changes"`
+	End         *time.Time `json:"end"`
+}
+
+// digest ... the period a digest report covers and its trend counts
+type digest struct {
+	Name   string
+	Start  time.Time
+	End    time.Time
+	Source string
+	Trend  []trendBucket
+}
+
+// trendBucket ... the archived change sets in one interval of a digest period
+type trendBucket struct {
+	Start     time.Time
+	Reports   int
+	Resources int
+	High      int
+}
+
+// Title ... the digest name for headings, e.g. "Daily"
+func (d *digest) Title() string {
+	if d.Name == "" {
+		return ""
+	}
+
+	return strings.ToUpper(d.Name[:1]) + d.Name[1:]
+}
+
+// newDigest ... the period covered by the digest named in the event
+func newDigest(e digestEvent, now time.Time) (*digest, error) {
+	end := now.UTC().Truncate(time.Hour)
+	if e.End != nil {
+		end = e.End.UTC()
+	}
+
+	d := &digest{Name: strings.ToLower(strings.TrimSpace(e.Digest)), End: end}
+
+	switch d.Name {
+	case digestDaily:
+		d.Start = end.Add(-24 * time.Hour)
+	case digestWeekly:
+		d.Start = end.Add(-7 * 24 * time.Hour)
+	default:
+		return nil, fmt.Errorf("unknown digest: %q", e.Digest)
+	}
+
+	return d, nil
+}
+
+// bucketSize ... the interval of the trend counts: one per snapshot for daily
+// digests and one per day for weekly digests
+func (d *digest) bucketSize() time.Duration {
+	if d.Name == digestWeekly {
+		return 24 * time.Hour
+	}
+
+	return snapshotFrequency * time.Hour
+}
+
+// archivedChanges ... consolidates the change sets archived during the period,
+// filling in the digest's trend counts
+func archivedChanges(svc s3iface.S3API, cfg *config, d *digest) ([]map[string]interface{}, error) {
+	entries, err := archiveEntries(svc, cfg, d.Start, d.End)
+	if err != nil {
+		return nil, err
+	}
+
+	for t := d.Start; t.Before(d.End); t = t.Add(d.bucketSize()) {
+		d.Trend = append(d.Trend, trendBucket{Start: t})
+	}
+
+	sets := make([][]map[string]interface{}, 0, len(entries))
+
+	for _, e := range entries {
+		s, err := getObject(svc, cfg.ArchiveBucket, archiveKey(cfg, path.Join(e.Path, "items.json")))
+		if err != nil {
+			return nil, err
+		}
+
+		var items []map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &items); err != nil {
+			return nil, fmt.Errorf("error parsing archived change set %s: %v", e.Path, err)
+		}
+
+		b := &d.Trend[int(e.Time.Sub(d.Start)/d.bucketSize())]
+		b.Reports++
+		b.Resources += len(items)
+
+		for _, i := range items {
+			if isHighRisk(i) {
+				b.High++
+			}
+		}
+
+		sets = append(sets, items)
+	}
+
+	return consolidateChanges(sets), nil
+}
+
+// consolidateChanges ... combines successive change sets into the net change of
+// each resource from before its first change to after its last, dropping
+// resources whose changes were reverted. Sets must be ordered oldest first
+func consolidateChanges(sets [][]map[string]interface{}) []map[string]interface{} {
+	var keys []string
+
+	net := make(map[string]map[string]interface{})
+
+	for _, set := range sets {
+		for _, i := range set {
+			key := resourceKey(i)
+
+			prev, ok := net[key]
+			if !ok {
+				i[digestStepsKey] = 1
+				net[key] = i
+				keys = append(keys, key)
+
+				continue
+			}
+
+			i[digestStepsKey] = prev[digestStepsKey].(int) + 1
+
+			earlier, hadDiffs := prev["diffs"].(map[string]interface{})
+			later, hasDiffs := i["diffs"].(map[string]interface{})
+
+			switch {
+			case !hadDiffs:
+				// created during the period, so there is no earlier state to compare to
+				delete(i, "diffs")
+			case hasDiffs:
+				i["diffs"] = mergeDiffs(earlier, later)
+			}
+
+			if actors, ok := prev[actorsKey].([]interface{}); ok {
+				later, _ := i[actorsKey].([]interface{})
+				i[actorsKey] = append(later, actors...)
+			}
+
+			net[key] = i
+		}
+	}
+
+	items := make([]map[string]interface{}, 0, len(keys))
+
+	for _, key := range keys {
+		i := net[key]
+
+		// findings are scored again on the net change
+		delete(i, risksKey)
+
+		// a reverted change still differs in its capture time and state ID
+		if diffs, ok := i["diffs"].(map[string]interface{}); ok {
+			if !hasPropertyChanges(pruneDiffs(diffs, i)) && changeKind(i) != changeDeleted {
+				continue
+			}
+		}
+
+		items = append(items, i)
+	}
+
+	return items
+}
+
+// mergeDiffs ... the previous values of two successive diffs, keeping the
+// earlier value of properties changed in both
+func mergeDiffs(earlier, later map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(later))
+
+	for k, v := range later {
+		merged[k] = v
+	}
+
+	for k, v := range earlier {
+		e, eok := nestedDiffs(v)
+		l, lok := nestedDiffs(merged[k])
+
+		if eok && lok {
+			merged[k] = map[string]interface{}{"diffs": mergeDiffs(e, l)}
+		} else {
+			merged[k] = v
+		}
+	}
+
+	return merged
+}
+
+// pruneDiffs ... removes the properties whose previous value equals the
+// current value of the item, returning the remaining diffs
+func pruneDiffs(diffs, item map[string]interface{}) map[string]interface{} {
+	for k, v := range diffs {
+		if nested, ok := nestedDiffs(v); ok {
+			sub, _ := item[k].(map[string]interface{})
+			if len(pruneDiffs(nested, sub)) == 0 {
+				delete(diffs, k)
+			}
+
+			continue
+		}
+
+		if reflect.DeepEqual(v, item[k]) {
+			delete(diffs, k)
+		}
+	}
+
+	return diffs
+}
+
+func nestedDiffs(v interface{}) (map[string]interface{}, bool) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	diffs, ok := m["diffs"].(map[string]interface{})
+
+	return diffs, ok
+}
+
+// snapshotChanges ... the net changes between the first and last snapshots
+// delivered during the period
+func snapshotChanges(svc s3iface.S3API, cfg *config, account string, d *digest) ([]map[string]interface{}, error) {
+	snapshots, err := listSnapshots(svc, cfg.S3Bucket, account, cfg.DefaultRegion, d.Start, d.End)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(snapshots) < 2 {
+		return nil, fmt.Errorf("found %d snapshots between %v and %v, need 2", len(snapshots), d.Start, d.End)
+	}
+
+	_, first, err := getSnapshot(svc, cfg.S3Bucket, snapshots[0])
+	if err != nil {
+		return nil, err
+	}
+
+	_, last, err := getSnapshot(svc, cfg.S3Bucket, snapshots[len(snapshots)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	return diffSnapshots([]byte(first), []byte(last), cfg)
+}
+
+// listSnapshots ... the snapshots delivered during the period, oldest first
+func listSnapshots(svc s3iface.S3API, bucket, account, region string, start, end time.Time) ([]*s3.Object, error) {
+	var snapshots []*s3.Object
+
+	seen := make(map[string]bool)
+
+	for day := start.UTC().Truncate(24 * time.Hour); day.Before(end); day = day.Add(24 * time.Hour) {
+		results, err := svc.ListObjects(&s3.ListObjectsInput{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(snapshotPrefix(account, region, day)),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range results.Contents {
+			m := aws.TimeValue(o.LastModified)
+			if !m.Before(start) && m.Before(end) && !seen[aws.StringValue(o.Key)] {
+				seen[aws.StringValue(o.Key)] = true
+
+				snapshots = append(snapshots, o)
+			}
+		}
+	}
+
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return aws.TimeValue(snapshots[i].LastModified).Before(aws.TimeValue(snapshots[j].LastModified))
+	})
+
+	return snapshots, nil
+}
+
+// diffSnapshots ... compares every resource in the last snapshot to the first,
+// reporting resources missing from the first as created and resources missing
+// from the last as deleted
+func diffSnapshots(first, last []byte, cfg *config) ([]map[string]interface{}, error) {
+	var maps [2][]map[string]interface{}
+
+	r := newRedactor(cfg)
+
+	for n, b := range [][]byte{first, last} {
+		s, err := unmarshalSnapshot(b)
+		if err != nil {
+			return nil, err
+		}
+
+		if maps[n], err = parseItemsToMap(s.ConfigurationItems); err != nil {
+			return nil, err
+		}
+
+		r.redactItems(maps[n])
+	}
+
+	var items []map[string]interface{}
+
+	for _, v := range maps[1] {
+		old := getSnapshotOfItem(v, maps[0])
+		if old == nil {
+			v["ConfigurationItemStatus"] = "ResourceDiscovered"
+			items = append(items, v)
+
+			continue
+		}
+
+		v["diffs"] = makeDiffs(removeNulls(old), removeNulls(v))
+		if len(v["diffs"].(map[string]interface{})) != 0 {
+			items = append(items, v)
+		}
+	}
+
+	for _, v := range maps[0] {
+		if getSnapshotOfItem(v, maps[1]) == nil {
+			v["ConfigurationItemStatus"] = "ResourceDeleted"
+			items = append(items, v)
+		}
+	}
+
+	resolveOwners(items, append(maps[1], maps[0]...), cfg)
+	resolveDeployments(items, append(maps[1], maps[0]...), cfg)
+
+	return items, nil
+}
+
+// digestReport ... consolidates the changes of a daily or weekly period and
+// sends them to the configured notifiers. Digests are built from the archive
+// when archive_bucket is set, otherwise, or when redacted values would not
+// compare across archived change sets, from the period's snapshots
+func digestReport(e digestEvent) error {
+	cfg, sess, err := getSess()
+	if err != nil {
+		return err
+	}
+
+	d, err := newDigest(e, time.Now())
+	if err != nil {
+		return err
+	}
+
+	s3Svc := s3.New(sess)
+
+	var items []map[string]interface{}
+
+	// archived change sets are redacted, and without a configured salt each
+	// with its own, so their redacted values are compared in the snapshots
+	archived := cfg.ArchiveBucket != "" && (cfg.RedactionSalt != "" || newRedactor(&cfg) == nil)
+	if cfg.ArchiveBucket != "" && !archived {
+		log.Printf("redaction_salt is not set, building the %s digest from snapshots\n", d.Name)
+	}
+
+	if archived {
+		d.Source = digestSourceArchive
+		items, err = archivedChanges(s3Svc, &cfg, d)
+	} else {
+		var id *sts.GetCallerIdentityOutput
+
+		if id, err = sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{}); err == nil {
+			d.Source = digestSourceSnapshot
+			items, err = snapshotChanges(s3Svc, &cfg, aws.StringValue(id.Account), d)
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("error building %s digest: %v", d.Name, err)
+	}
+
+	if d.Source == digestSourceSnapshot {
+		store, err := loadSuppressions(&cfg, s3Svc)
+		if err != nil {
+			log.Printf("error loading suppressions, not suppressing: %v\n", err)
+		}
+
+		if store != nil {
+			rules, err := loadRiskRules(&cfg, s3Svc)
+			if err != nil {
+				log.Printf("error loading risk rules, using default: %v\n", err)
+			}
+
+			items = applySuppressions(items, store, cfg.SuppressionMode, rules, time.Now())
+		}
+	}
+
+	if len(items) == 0 {
+		log.Printf("no configuration changes between %v and %v\n", d.Start, d.End)
+		return nil
+	}
+
+	addConsoleLinks(items, cfg.DefaultRegion)
+
+	r := &report{
+		Items:            items,
+		Time:             d.End,
+		MaxDiffLines:     cfg.MaxDiffLines,
+		ClusterThreshold: cfg.ClusterThreshold,
+		Digest:           d,
+	}
+
+	return sendReport(r, &cfg, sess, s3Svc, false, severityInfo)
+}
+
+// digestSteps ... the number of change sets consolidated into a digest item
+func digestSteps(i map[string]interface{}) int {
+	switch v := i[digestStepsKey].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+
+	return 0
+}
+
+// digestToText ... the plain text heading and trend counts of a digest
+func digestToText(d *digest) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s Configuration Digest: %v to %v\nNet change of each resource over the period, built from %s.\n",
+		d.Title(), d.Start, d.End, d.Source)
+
+	if len(d.Trend) > 0 {
+		fmt.Fprintf(&sb, "\nTrend\n")
+
+		for _, b := range d.Trend {
+			fmt.Fprintf(&sb, "%s%s: %d change sets, %d resources changed, %d high risk\n",
+				textIndent, b.Start.Format(subjectTime), b.Reports, b.Resources, b.High)
+		}
+	}
+
+	return sb.String()
+}
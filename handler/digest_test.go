@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helper functions //
+func digestTestItem(t *testing.T, s string) map[string]interface{} {
+	var m map[string]interface{}
+
+	chkErr(t, json.Unmarshal([]byte(s), &m))
+
+	return m
+}
+
+func digestTestArchive(t *testing.T, sets map[string]string) *mockS3 {
+	m := &mockS3{Puts: make(map[string][]byte)}
+
+	for ts, items := range sets {
+		at, err := time.Parse(time.RFC3339, ts)
+		chkErr(t, err)
+
+		entry := archiveEntry{Time: at, Path: archiveDir(at)}
+		m.Puts["prefix/"+entry.Path+"/items.json"] = []byte(items)
+
+		b, err := json.Marshal(entry)
+		chkErr(t, err)
+
+		m.Puts["prefix/"+shardKey(entry)] = b
+	}
+
+	return m
+}
+
+// test functions //
+func TestNewDigest(t *testing.T) {
+	now := time.Date(2020, 3, 10, 12, 34, 0, 0, time.UTC)
+	end := time.Date(2020, 3, 1, 0, 0, 0, 0, time.UTC)
+	tt := map[string]struct {
+		event         digestEvent
+		expectedStart time.Time
+		expectedEnd   time.Time
+		expectedErr   bool
+	}{
+		"daily": {
+			event:         digestEvent{Digest: "daily"},
+			expectedStart: time.Date(2020, 3, 9, 12, 0, 0, 0, time.UTC),
+			expectedEnd:   time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC),
+		},
+		"weekly with end": {
+			event:         digestEvent{Digest: " Weekly", End: &end},
+			expectedStart: time.Date(2020, 2, 23, 0, 0, 0, 0, time.UTC),
+			expectedEnd:   end,
+		},
+		"unknown": {
+			event:       digestEvent{Digest: "monthly"},
+			expectedErr: true,
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			d, err := newDigest(tc.event, now)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("newDigest() failed. Expected error: %v, got: %v", tc.expectedErr, err)
+			}
+
+			if err == nil && (!d.Start.Equal(tc.expectedStart) || !d.End.Equal(tc.expectedEnd)) {
+				t.Errorf("newDigest() failed. Expected %v to %v, got: %v to %v", tc.expectedStart, tc.expectedEnd, d.Start, d.End)
+			}
+		})
+	}
+}
+
+func TestConsolidateChanges(t *testing.T) {
+	sets := [][]map[string]interface{}{{
+		changedTestItem(t,
+			`{"ResourceType": "AWS::EC2::Volume", "ResourceId": "vol-1", "Tags": {"a": "1"},
+				"Configuration": {"size": 10, "encrypted": true}}`,
+			`{"ResourceType": "AWS::EC2::Volume", "ResourceId": "vol-1", "Tags": {"a": "2"},
+				"Configuration": {"size": 20, "encrypted": true}}`),
+		changedTestItem(t,
+			`{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "ConfigurationStateId": 1,
+				"ConfigurationItemCaptureTime": "2020-03-08T01:00:00Z", "Configuration": {"delay": 0}}`,
+			`{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "ConfigurationStateId": 2,
+				"ConfigurationItemCaptureTime": "2020-03-08T02:00:00Z", "Configuration": {"delay": 5}}`),
+		digestTestItem(t, `{"ResourceType": "AWS::SNS::Topic", "ResourceId": "t-1", "Configuration": {"name": "t"}}`),
+	}, {
+		changedTestItem(t,
+			`{"ResourceType": "AWS::EC2::Volume", "ResourceId": "vol-1", "Tags": {"a": "2"},
+				"Configuration": {"size": 20, "encrypted": true}}`,
+			`{"ResourceType": "AWS::EC2::Volume", "ResourceId": "vol-1", "Tags": {"a": "2"},
+				"Configuration": {"size": 30, "encrypted": false}}`),
+		changedTestItem(t,
+			`{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "ConfigurationStateId": 2,
+				"ConfigurationItemCaptureTime": "2020-03-08T02:00:00Z", "Configuration": {"delay": 5}}`,
+			`{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "ConfigurationStateId": 3,
+				"ConfigurationItemCaptureTime": "2020-03-08T03:00:00Z", "Configuration": {"delay": 0}}`),
+		changedTestItem(t,
+			`{"ResourceType": "AWS::SNS::Topic", "ResourceId": "t-1", "Configuration": {"name": "t"}}`,
+			`{"ResourceType": "AWS::SNS::Topic", "ResourceId": "t-1", "Configuration": {"name": "u"}}`),
+	}}
+
+	items := consolidateChanges(sets)
+
+	// the queue change was reverted, leaving only capture metadata diffs
+	if len(items) != 2 {
+		t.Fatalf("consolidateChanges() failed. Expected 2 items, got: %v", items)
+	}
+
+	changes := itemChanges(items[0])
+	expected := []propertyChange{
+		{Path: "Configuration.encrypted", Previous: true, Current: false},
+		{Path: "Configuration.size", Previous: 10.0, Current: 30.0},
+		{Path: "Tags", Previous: map[string]interface{}{"a": "1"}, Current: map[string]interface{}{"a": "2"}},
+	}
+
+	if compactJSON(changes) != compactJSON(expected) {
+		t.Errorf("consolidateChanges() failed. Expected net changes: %v\nGot: %v", expected, changes)
+	}
+
+	if digestSteps(items[0]) != 2 {
+		t.Errorf("consolidateChanges() failed. Expected 2 steps, got: %d", digestSteps(items[0]))
+	}
+
+	if changeKind(items[1]) != changeCreated {
+		t.Errorf("consolidateChanges() failed. Expected topic created during the period, got: %s", changeKind(items[1]))
+	}
+}
+
+func TestArchivedChanges(t *testing.T) {
+	m := digestTestArchive(t, map[string]string{
+		"2020-03-09T10:00:00Z": `[{"ResourceType": "AWS::SNS::Topic", "ResourceId": "t-0"}]`,
+		"2020-03-09T13:00:00Z": `[{"ResourceType": "AWS::SNS::Topic", "ResourceId": "t-1", "Severity": "high"}]`,
+		"2020-03-10T11:00:00Z": `[{"ResourceType": "AWS::SNS::Topic", "ResourceId": "t-2"},` +
+			`{"ResourceType": "AWS::SNS::Topic", "ResourceId": "t-3"}]`,
+	})
+	cfg := &config{ArchiveBucket: "bucket", ArchivePrefix: "prefix"}
+
+	d, err := newDigest(digestEvent{Digest: digestDaily}, time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC))
+	chkErr(t, err)
+
+	items, err := archivedChanges(m, cfg, d)
+	chkErr(t, err)
+
+	if len(items) != 3 {
+		t.Errorf("archivedChanges() failed. Expected 3 items, got: %d", len(items))
+	}
+
+	if len(d.Trend) != 8 {
+		t.Fatalf("archivedChanges() failed. Expected 8 trend buckets, got: %d", len(d.Trend))
+	}
+
+	first, last := d.Trend[0], d.Trend[7]
+	if first.Reports != 1 || first.High != 1 || last.Reports != 1 || last.Resources != 2 {
+		t.Errorf("archivedChanges() failed. Unexpected trend: %+v", d.Trend)
+	}
+
+	r := &report{Items: items, Time: d.End, Digest: d}
+
+	if s := reportSubject(r); !strings.HasPrefix(s, "Daily digest: 3 changes") {
+		t.Errorf("reportSubject() failed. Expected digest subject, got: %s", s)
+	}
+
+	text, err := reportToText(r)
+	chkErr(t, err)
+
+	if !strings.Contains(text, "Daily Configuration Digest") || !strings.Contains(text, "2020-03-10 09:00Z: 1 change sets, 2 resources changed") {
+		t.Errorf("reportToText() failed. Expected digest heading and trend in:\n%s", text)
+	}
+
+	h, err := reportToHTML(r)
+	chkErr(t, err)
+
+	if !strings.Contains(h, "<h1>Daily Configuration Digest") || strings.Contains(h, "Snapshot") {
+		t.Errorf("reportToHTML() failed. Expected digest heading in:\n%s", h)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	first := `{"configurationItems": [
+		{"resourceType": "AWS::SQS::Queue", "resourceId": "q-1", "configuration": {"delay": 0}},
+		{"resourceType": "AWS::SQS::Queue", "resourceId": "q-2", "configuration": {"delay": 0}}
+	]}`
+	last := `{"configurationItems": [
+		{"resourceType": "AWS::SQS::Queue", "resourceId": "q-1", "configuration": {"delay": 5}},
+		{"resourceType": "AWS::SQS::Queue", "resourceId": "q-3", "configuration": {"delay": 0}}
+	]}`
+
+	items, err := diffSnapshots([]byte(first), []byte(last), &config{})
+	chkErr(t, err)
+
+	kinds := make(map[string]string)
+	for _, i := range items {
+		kinds[stringValue(i, "ResourceId")] = changeKind(i)
+	}
+
+	expected := map[string]string{"q-1": changeModified, "q-2": changeDeleted, "q-3": changeCreated}
+	if compactJSON(kinds) != compactJSON(expected) {
+		t.Errorf("diffSnapshots() failed. Expected: %v\nGot: %v", expected, kinds)
+	}
+}
@@ -8,51 +8,36 @@ import (
 
 	"encoding/json"
 	"log"
-	"path/filepath"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/ses"
 	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
 	"gopkg.in/gomail.v2"
 )
 
 const (
-	style = `<head>
-<style>
-	table {border-collapse: collapse;}
-	td, th {border: 1px solid Black;}
-	th {background: LightGray;}
-	tr:nth-child(even) {background: #F3F3F3;}
-	tr:nth-child(odd) {background: White;}
-	.resource {background-color: RoyalBlue; color: White; font-weight: bold;}
-	.blank {background-color: White; border: none;}
-	.group {background-color: LightBlue;}
-</style>
-</head>
-`
-	jsonFile = "/tmp/items.json"
+	jsonFile       = "/tmp/items.json"
+	defaultCharSet = "UTF-8"
 )
 
 // sendEmail ... sends an email to recipients specified in environment variable
-func sendEmail(
-	itemsMap []map[string]interface{},
-	t time.Time,
-	ssObject *s3.Object,
-	svc sesiface.SESAPI,
-	cfg *config) (htmlBody string, err error) {
-	html, err := parseItemsToHTML(itemsMap)
+func sendEmail(r *report, svc sesiface.SESAPI, s3Svc s3iface.S3API, cfg *config) (htmlBody string, err error) {
+	htmlBody, err = reportToHTML(r)
 	if err != nil {
 		log.Fatalf("error parsing configuration items: %v", err)
+		return htmlBody, err
 	}
 
-	htmlBody = fmt.Sprintf("%s<h1>Configuration Changes at %v (+/- %v min)</h1>\n",
-		style, t, window)
-	htmlBody += fmt.Sprintf("<table>\n<tr><td class=\"resource\">Snapshot</td><td colspan=3>%s</td></tr>\n%s</table>",
-		filepath.Base(aws.StringValue(ssObject.Key)), html)
+	textBody, err := reportToText(r)
+	if err != nil {
+		log.Fatalf("error parsing configuration items to text: %v", err)
+		return htmlBody, err
+	}
 
-	slice, err := json.MarshalIndent(itemsMap, "", "  ")
+	slice, err := reportToJSON(r)
 	if err != nil {
 		log.Fatalf("error marshaling itemsMap: %v", err)
 		return htmlBody, err
@@ -64,14 +49,20 @@ func sendEmail(
 		return htmlBody, err
 	}
 
-	subject := fmt.Sprintf("Changed/Discovered Configuration Items (%v)", t)
-
-	input, err := buildEmailInput(subject, htmlBody, jsonFile, cfg)
+	input, err := buildEmailInput(reportSubject(r), htmlBody, textBody, jsonFile, cfg)
 	if err != nil {
 		log.Fatalf("error building raw email input: %v", err)
 		return htmlBody, err
 	}
 
+	if len(input.RawMessage.Data) > maxEmailSize(cfg) {
+		input, err = fitEmail(r, htmlBody, textBody, slice, s3Svc, cfg)
+		if err != nil {
+			log.Fatalf("error reducing email size: %v", err)
+			return htmlBody, err
+		}
+	}
+
 	result, err := svc.SendRawEmail(input)
 	if err != nil {
 		log.Fatalf("error sending email: %v", err)
@@ -84,17 +75,66 @@ func sendEmail(
 	return htmlBody, err
 }
 
-func buildEmailInput(subject, htmlBody, jsonFile string, cfg *config) (*ses.SendRawEmailInput, error) {
-	msg := gomail.NewMessage()
+// reportToJSON ... the change set, or the inventory, as indented JSON, as
+// attached to the email
+func reportToJSON(r *report) ([]byte, error) {
+	if r.Inventory != nil {
+		return json.MarshalIndent(r.Inventory, "", "  ")
+	}
+
+	return json.MarshalIndent(r.Items, "", "  ")
+}
+
+// buildEmailInput ... builds a multipart/alternative message with plain text
+// and HTML bodies encoded in the configured character set
+func buildEmailInput(subject, htmlBody, textBody, jsonFile string, cfg *config) (*ses.SendRawEmailInput, error) {
+	charSet := cfg.CharSet
+	if charSet == "" {
+		charSet = defaultCharSet
+	}
+
+	enc, err := htmlindex.Get(charSet)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported char_set %q: %v", charSet, err)
+	}
+
+	// Characters the charset cannot represent are replaced in the text body
+	// and written as HTML character references in the HTML body
+	textBody, err = encoding.ReplaceUnsupported(enc.NewEncoder()).String(textBody)
+	if err != nil {
+		return nil, err
+	}
+
+	htmlBody, err = encoding.HTMLEscapeUnsupported(enc.NewEncoder()).String(htmlBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// The subject is encoded in the same charset, with the dash most legacy
+	// charsets lack written as a hyphen
+	if !strings.EqualFold(charSet, defaultCharSet) {
+		subject = strings.ReplaceAll(subject, "–", "-")
+	}
+
+	subject, err = encoding.ReplaceUnsupported(enc.NewEncoder()).String(subject)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := gomail.NewMessage(gomail.SetCharset(charSet))
 	msg.SetHeader("From", cfg.Sender)
 	msg.SetHeader("To", strings.Join(cfg.Recipients, ","))
 	msg.SetHeader("Subject", subject)
-	msg.SetBody("text/html", htmlBody)
-	msg.Attach(jsonFile)
+	msg.SetBody("text/plain", textBody)
+	msg.AddAlternative("text/html", htmlBody)
+
+	if jsonFile != "" {
+		msg.Attach(jsonFile)
+	}
 
 	var s bytes.Buffer
 
-	_, err := msg.WriteTo(&s)
+	_, err = msg.WriteTo(&s)
 	if err != nil {
 		log.Fatalf("error writing to buffer: %v", err)
 		return nil, err
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -65,7 +66,9 @@ func TestSendEmail(t *testing.T) {
 				log.SetOutput(os.Stderr)
 			}()
 
-			htmlBody, err := sendEmail(itemsMap, tc.lastExecution, ssObject, mockSvc, &cfg)
+			r := &report{Items: itemsMap, Time: tc.lastExecution, Snapshot: ssObject}
+
+			htmlBody, err := sendEmail(r, mockSvc, &mockS3{}, &cfg)
 			if err != nil {
 				t.Errorf("sendEmail() failed. Unexpected error: %v\n", err)
 			}
@@ -80,3 +83,53 @@ func TestSendEmail(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildEmailInput(t *testing.T) {
+	tt := map[string]struct {
+		charSet  string
+		expected []string
+		err      bool
+	}{
+		"default": {
+			expected: []string{"multipart/alternative", "text/plain; charset=UTF-8", "text/html; charset=UTF-8", "items.json",
+				"Subject: =?UTF-8?q?caf=C3=A9_=E2=80=93_3_changes?="},
+		},
+		"latin1": {
+			charSet:  "ISO-8859-1",
+			expected: []string{"text/plain; charset=ISO-8859-1", "caf=E9", "&#9731;", "Subject: =?ISO-8859-1?q?caf=E9_-_3_changes?="},
+		},
+		"unknown": {
+			charSet: "not-a-charset",
+			err:     true,
+		},
+	}
+
+	f, err := os.CreateTemp("", "items*.json")
+	chkErr(t, err)
+
+	defer os.Remove(f.Name())
+
+	for name, tc := range tt {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			cfg := config{Sender: "a@b.c", Recipients: []string{"d@e.f"}, CharSet: tc.charSet}
+
+			input, err := buildEmailInput("café – 3 changes", "<p>snow ☃</p>", "café", f.Name(), &cfg)
+			if tc.err != (err != nil) {
+				t.Fatalf("buildEmailInput() failed. Expected error: %v, got: %v", tc.err, err)
+			}
+
+			if err != nil {
+				return
+			}
+
+			raw := string(input.RawMessage.Data)
+			for _, e := range tc.expected {
+				if !strings.Contains(raw, strings.Replace(e, "items.json", filepath.Base(f.Name()), 1)) {
+					t.Errorf("buildEmailInput() failed. Expected message to contain %q\nGot:\n%s", e, raw)
+				}
+			}
+		})
+	}
+}
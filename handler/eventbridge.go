@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+)
+
+const (
+	eventBatchSize  = 10         // entries accepted by one PutEvents call
+	maxEventsSize   = 256 * 1024 // bytes accepted by one PutEvents call
+	eventDetailType = "Config Resource Change"
+	eventVersion    = "1"
+)
+
+// EventBridgeNotifier ... publishes an event per changed resource to an
+// EventBridge bus, in batches
+type EventBridgeNotifier struct {
+	Client eventbridgeiface.EventBridgeAPI
+	Bus    string
+	Source string
+}
+
+// changeEvent ... the detail of a change event. Documented in the README,
+// so fields are only ever added
+type changeEvent struct {
+	Version     string           `json:"version"`
+	Resource    eventResource    `json:"resource"`
+	Change      string           `json:"change"`
+	Status      string           `json:"status"`
+	CaptureTime string           `json:"capture_time,omitempty"`
+	Severity    severity         `json:"severity"`
+	Risks       []riskFinding    `json:"risks"`
+	Changes     []propertyChange `json:"changes"`
+	Truncated   bool             `json:"truncated,omitempty"`
+	Actors      []actor          `json:"actors"`
+	ReportURL   string           `json:"report_url,omitempty"`
+}
+
+// eventResource ... the identity of the changed resource
+type eventResource struct {
+	Type    string                 `json:"type"`
+	ID      string                 `json:"id"`
+	Name    string                 `json:"name,omitempty"`
+	Arn     string                 `json:"arn,omitempty"`
+	Account string                 `json:"account"`
+	Region  string                 `json:"region"`
+	Owner   string                 `json:"owner,omitempty"`
+	Tags    map[string]interface{} `json:"tags"`
+}
+
+// Notify ... implements Notifier for EventBridge
+func (n *EventBridgeNotifier) Notify(r *report) error {
+	entries, err := changeEventEntries(r, n.Bus, n.Source)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+
+	for _, batch := range eventBatches(entries) {
+		out, err := n.Client.PutEvents(&eventbridge.PutEventsInput{Entries: batch})
+		if err != nil {
+			return err
+		}
+
+		for k, e := range out.Entries {
+			if e.ErrorCode != nil && k < len(batch) {
+				failed = append(failed, fmt.Sprintf("%s: %s: %s", strings.Join(aws.StringValueSlice(batch[k].Resources), ","),
+					aws.StringValue(e.ErrorCode), aws.StringValue(e.ErrorMessage)))
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("eventbridge rejected %d of %d events: %s", len(failed), len(entries), strings.Join(failed, "; "))
+	}
+
+	log.Printf("Published %d events to EventBridge bus %s\n", len(entries), n.Bus)
+
+	return nil
+}
+
+// newChangeEvent ... the change event of an item
+func newChangeEvent(r *report, i map[string]interface{}) *changeEvent {
+	tags, _ := i["Tags"].(map[string]interface{})
+	if tags == nil {
+		tags = make(map[string]interface{})
+	}
+
+	changes := itemChanges(i)
+	if changes == nil {
+		changes = []propertyChange{}
+	}
+
+	risks := itemRisks(i)
+	if risks == nil {
+		risks = []riskFinding{}
+	}
+
+	actors := itemActors(i)
+	if actors == nil {
+		actors = []actor{}
+	}
+
+	return &changeEvent{
+		Version: eventVersion,
+		Resource: eventResource{
+			Type:    stringValue(i, "ResourceType"),
+			ID:      stringValue(i, "ResourceId"),
+			Name:    stringValue(i, "ResourceName"),
+			Arn:     stringValue(i, "Arn"),
+			Account: stringValue(i, "AccountId"),
+			Region:  stringValue(i, "AwsRegion"),
+			Owner:   stringValue(i, ownerKey),
+			Tags:    tags,
+		},
+		Change:      changeKind(i),
+		Status:      stringValue(i, "ConfigurationItemStatus"),
+		CaptureTime: stringValue(i, captureTimeKey),
+		Severity:    itemSeverity(i),
+		Risks:       risks,
+		Changes:     changes,
+		Actors:      actors,
+		ReportURL:   r.ArchiveURL,
+	}
+}
+
+// eventTrims ... the ways an event too large for EventBridge is cut down, in
+// order: the changed values, the changed paths, then the tags, risks and actors
+var eventTrims = []func(e *changeEvent){
+	func(e *changeEvent) {
+		for k := range e.Changes {
+			e.Changes[k].Previous, e.Changes[k].Current = nil, nil
+		}
+	},
+	func(e *changeEvent) {
+		e.Changes = []propertyChange{}
+	},
+	func(e *changeEvent) {
+		e.Resource.Tags = make(map[string]interface{})
+		e.Risks, e.Actors = []riskFinding{}, []actor{}
+	},
+}
+
+// changeEventEntries ... the PutEvents entries of the report, one per item.
+// Events too large for EventBridge are trimmed and marked truncated, and
+// skipped with a logged error if they are still too large
+func changeEventEntries(r *report, bus, source string) ([]*eventbridge.PutEventsRequestEntry, error) {
+	var entries []*eventbridge.PutEventsRequestEntry
+
+	for _, i := range r.Items {
+		e := newChangeEvent(r, i)
+
+		entry := &eventbridge.PutEventsRequestEntry{
+			EventBusName: aws.String(bus),
+			Source:       aws.String(source),
+			DetailType:   aws.String(eventDetailType),
+		}
+
+		if e.Resource.Arn != "" {
+			entry.Resources = aws.StringSlice([]string{e.Resource.Arn})
+		}
+
+		if t, err := time.Parse(time.RFC3339, e.CaptureTime); err == nil {
+			entry.Time = aws.Time(t)
+		}
+
+		fits, err := fitChangeEvent(e, entry)
+		if err != nil {
+			return nil, err
+		}
+
+		if !fits {
+			log.Printf("error publishing the change event of %s (%s): too large for EventBridge, skipping it\n",
+				e.Resource.ID, e.Resource.Type)
+
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// fitChangeEvent ... sets the entry's detail to the event, trimmed until it
+// fits within the EventBridge size limit, returning false if it never fits
+func fitChangeEvent(e *changeEvent, entry *eventbridge.PutEventsRequestEntry) (bool, error) {
+	for n := 0; ; n++ {
+		b, err := json.Marshal(e)
+		if err != nil {
+			return false, err
+		}
+
+		entry.Detail = aws.String(string(b))
+
+		if eventSize(entry) <= maxEventsSize {
+			return true, nil
+		}
+
+		if n == len(eventTrims) {
+			return false, nil
+		}
+
+		eventTrims[n](e)
+		e.Truncated = true
+	}
+}
+
+// eventBatches ... splits the entries into PutEvents calls within the
+// entry count and request size limits
+func eventBatches(entries []*eventbridge.PutEventsRequestEntry) [][]*eventbridge.PutEventsRequestEntry {
+	var (
+		batches [][]*eventbridge.PutEventsRequestEntry
+		batch   []*eventbridge.PutEventsRequestEntry
+		size    int
+	)
+
+	for _, e := range entries {
+		s := eventSize(e)
+
+		if len(batch) == eventBatchSize || len(batch) > 0 && size+s > maxEventsSize {
+			batches = append(batches, batch)
+			batch, size = nil, 0
+		}
+
+		batch = append(batch, e)
+		size += s
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// eventSize ... the size of an entry as EventBridge calculates it
+func eventSize(e *eventbridge.PutEventsRequestEntry) int {
+	size := len(aws.StringValue(e.Source)) + len(aws.StringValue(e.DetailType)) + len(aws.StringValue(e.Detail))
+
+	if e.Time != nil {
+		size += 14
+	}
+
+	for _, r := range e.Resources {
+		size += len(aws.StringValue(r))
+	}
+
+	return size
+}
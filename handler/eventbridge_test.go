@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/eventbridge/eventbridgeiface"
+)
+
+type mockEventBridgeClient struct {
+	eventbridgeiface.EventBridgeAPI
+	Batches [][]*eventbridge.PutEventsRequestEntry
+	Failed  int
+}
+
+func (m *mockEventBridgeClient) PutEvents(in *eventbridge.PutEventsInput) (*eventbridge.PutEventsOutput, error) {
+	m.Batches = append(m.Batches, in.Entries)
+
+	out := &eventbridge.PutEventsOutput{}
+	for k := range in.Entries {
+		if k < m.Failed {
+			out.Entries = append(out.Entries, &eventbridge.PutEventsResultEntry{ErrorCode: aws.String("InternalFailure")})
+			continue
+		}
+
+		out.Entries = append(out.Entries, &eventbridge.PutEventsResultEntry{EventId: aws.String(fmt.Sprint(k))})
+	}
+
+	return out, nil
+}
+
+// test functions //
+func TestChangeEventEntries(t *testing.T) {
+	r := securityHubTestReport(t)
+	r.Items[0][actorsKey] = []actor{{EventName: "ModifyVolume", PrincipalArn: "arn:aws:iam::123456789012:user/alice"}}
+	r.Items[0][ownerKey] = "ops"
+
+	entries, err := changeEventEntries(r, "changes", "grace.config-differ")
+	chkErr(t, err)
+
+	if len(entries) != 2 {
+		t.Fatalf("changeEventEntries() failed. Expected 2 events, got: %d", len(entries))
+	}
+
+	e := entries[0]
+	if aws.StringValue(e.EventBusName) != "changes" || aws.StringValue(e.DetailType) != eventDetailType ||
+		aws.StringValue(e.Resources[0]) != "arn:aws-us-gov:ec2:us-gov-west-1:123456789012:volume/vol-1" ||
+		e.Time == nil || e.Time.Hour() != 3 {
+		t.Errorf("changeEventEntries() failed. Unexpected entry: %v", e)
+	}
+
+	var detail changeEvent
+	chkErr(t, json.Unmarshal([]byte(aws.StringValue(e.Detail)), &detail))
+
+	if detail.Resource.Type != "AWS::EC2::Volume" || detail.Resource.Account != "123456789012" || detail.Resource.Owner != "ops" ||
+		detail.Change != "modified" || detail.Severity != severityHigh || len(detail.Risks) != 1 ||
+		len(detail.Changes) != 1 || detail.Changes[0].Path != "Configuration.encrypted" || detail.Changes[0].Current != false ||
+		len(detail.Actors) != 1 || detail.Actors[0].EventName != "ModifyVolume" || detail.ReportURL != r.ArchiveURL {
+		t.Errorf("changeEventEntries() failed. Unexpected detail: %s", aws.StringValue(e.Detail))
+	}
+
+	if d := aws.StringValue(entries[1].Detail); entries[1].Resources != nil || !strings.Contains(d, `"actors":[]`) ||
+		!strings.Contains(d, `"tags":{}`) {
+		t.Errorf("changeEventEntries() failed. Expected empty lists for an unattributed resource without an ARN, got: %s", d)
+	}
+
+	volume := `{"ResourceType": "AWS::EC2::Volume", "ResourceId": "vol-1", "Configuration": {"encrypted": %s}}`
+	large := changedTestItem(t, fmt.Sprintf(volume, "true"), fmt.Sprintf(volume, `"`+strings.Repeat("x", maxEventsSize)+`"`))
+	r.Items[0]["Configuration"], r.Items[0]["diffs"] = large["Configuration"], large["diffs"]
+
+	entries, err = changeEventEntries(r, "changes", "grace.config-differ")
+	chkErr(t, err)
+
+	chkErr(t, json.Unmarshal([]byte(aws.StringValue(entries[0].Detail)), &detail))
+
+	if !detail.Truncated || len(detail.Changes) != 1 || detail.Changes[0].Current != nil || eventSize(entries[0]) > maxEventsSize {
+		t.Errorf("changeEventEntries() failed. Expected the values of an oversized event dropped, got: %+v", detail)
+	}
+
+	// oversized tags are dropped, and an event too large without them is skipped
+	r.Items[0]["Tags"] = map[string]interface{}{"Notes": strings.Repeat("x", maxEventsSize)}
+	r.Items[1]["ResourceId"] = strings.Repeat("x", maxEventsSize)
+
+	entries, err = changeEventEntries(r, "changes", "grace.config-differ")
+	chkErr(t, err)
+
+	var trimmed changeEvent
+	chkErr(t, json.Unmarshal([]byte(aws.StringValue(entries[0].Detail)), &trimmed))
+
+	if len(entries) != 1 || !trimmed.Truncated || len(trimmed.Resource.Tags) != 0 || len(trimmed.Changes) != 0 ||
+		eventSize(entries[0]) > maxEventsSize {
+		t.Errorf("changeEventEntries() failed. Expected the tags dropped and the untrimmable event skipped, got: %+v", trimmed)
+	}
+}
+
+func TestEventBridgeNotify(t *testing.T) {
+	r := &report{}
+	for n := 0; n < 23; n++ {
+		r.Items = append(r.Items, map[string]interface{}{"ResourceType": "AWS::SQS::Queue", "ResourceId": fmt.Sprintf("q%d", n)})
+	}
+
+	m := &mockEventBridgeClient{}
+	n := &EventBridgeNotifier{Client: m, Bus: "default", Source: "grace.config-differ"}
+	chkErr(t, n.Notify(r))
+
+	if len(m.Batches) != 3 || len(m.Batches[0]) != eventBatchSize || len(m.Batches[2]) != 3 {
+		t.Errorf("Notify() failed. Expected batches of 10, 10 and 3 events, got %d batches", len(m.Batches))
+	}
+
+	m = &mockEventBridgeClient{Failed: 1}
+	n.Client = m
+
+	if err := n.Notify(r); err == nil || !strings.Contains(err.Error(), "rejected 3 of 23 events") {
+		t.Errorf("Notify() failed. Expected the rejected events, got: %v", err)
+	}
+
+	large := &eventbridge.PutEventsRequestEntry{Detail: aws.String(strings.Repeat("x", maxEventsSize/2+1))}
+	if b := eventBatches([]*eventbridge.PutEventsRequestEntry{large, large, large}); len(b) != 3 {
+		t.Errorf("eventBatches() failed. Expected batches within the request size limit, got %d batches", len(b))
+	}
+}
@@ -528,22 +528,9 @@ func getPreviousSnapshot(
 	// Get time from three hours before change...since snapshots are taken every
 	// three hours, this will ensure we are looking in the correct folder by date
 	prevTime := t.Add(time.Hour * time.Duration(-snapshotFrequency))
-	year, month, day := prevTime.Date()
-	account := aws.StringValue(items[0].AccountId)
-	prefix := strings.Join([]string{
-		"awsconfig",
-		"AWSLogs",
-		account,
-		"Config",
-		region,
-		strconv.Itoa(year),
-		strconv.Itoa(int(month)),
-		strconv.Itoa(day),
-		"ConfigSnapshot",
-	}, "/")
 	input := &s3.ListObjectsInput{
 		Bucket: aws.String(bucket),
-		Prefix: aws.String(prefix),
+		Prefix: aws.String(snapshotPrefix(aws.StringValue(items[0].AccountId), region, prevTime)),
 	}
 
 	results, err := svc.ListObjects(input)
@@ -561,25 +548,86 @@ func getPreviousSnapshot(
 	return nil, "", errors.New("snapshot not found")
 }
 
+// snapshotPrefix ... the S3 prefix of the config snapshots delivered on the day of t
+func snapshotPrefix(account, region string, t time.Time) string {
+	year, month, day := t.Date()
+
+	return strings.Join([]string{
+		"awsconfig",
+		"AWSLogs",
+		account,
+		"Config",
+		region,
+		strconv.Itoa(year),
+		strconv.Itoa(int(month)),
+		strconv.Itoa(day),
+		"ConfigSnapshot",
+	}, "/")
+}
+
+// configRegions ... the regions delivering configuration history or
+// snapshots of the account to the bucket
+func configRegions(svc s3iface.S3API, bucket, account string) ([]string, error) {
+	prefix := strings.Join([]string{"awsconfig", "AWSLogs", account, "Config", ""}, "/")
+
+	results, err := svc.ListObjects(&s3.ListObjectsInput{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []string
+
+	for _, p := range results.CommonPrefixes {
+		regions = append(regions, strings.Trim(strings.TrimPrefix(aws.StringValue(p.Prefix), prefix), "/"))
+	}
+
+	return regions, nil
+}
+
 func getSnapshot(svc s3iface.S3API, bucket string, o *s3.Object) (*s3.Object, string, error) {
+	s, err := getObject(svc, bucket, aws.StringValue(o.Key))
+	return o, s, err
+}
+
+// readSnapshotItems ... reads a snapshot and parses its configuration items
+func readSnapshotItems(svc s3iface.S3API, bucket string, o *s3.Object) ([]map[string]interface{}, error) {
+	_, s, err := getSnapshot(svc, bucket, o)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := unmarshalSnapshot([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+
+	return parseItemsToMap(snapshot.ConfigurationItems)
+}
+
+// getObject ... reads the contents of an S3 object into a string
+func getObject(svc s3iface.S3API, bucket, key string) (string, error) {
 	input := &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    o.Key,
+		Key:    aws.String(key),
 	}
 
 	result, err := svc.GetObject(input)
 	if err != nil {
-		return o, "", err
+		return "", err
 	}
 
 	defer result.Body.Close()
 
 	b := bytes.Buffer{}
 	if _, err := io.Copy(&b, result.Body); err != nil {
-		return o, "", err
+		return "", err
 	}
 
-	return o, b.String(), nil
+	return b.String(), nil
 }
 
 func getSess() (config, *session.Session, error) {
@@ -591,6 +639,11 @@ func getSess() (config, *session.Session, error) {
 		return cfg, nil, err
 	}
 
+	if err = checkSuppressionMode(cfg.SuppressionMode); err != nil {
+		log.Fatalf("error parsing env config: %v", err)
+		return cfg, nil, err
+	}
+
 	sess, err := session.NewSession(
 		&aws.Config{
 			Region:     aws.String(cfg.DefaultRegion),
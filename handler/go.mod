@@ -6,8 +6,10 @@ require (
 	github.com/aws/aws-lambda-go v1.34.1
 	github.com/aws/aws-sdk-go v1.44.126
 	github.com/caarlos0/env v3.5.0+incompatible
+	github.com/expr-lang/expr v1.16.9
 	github.com/google/go-cmp v0.5.9
 	github.com/nsf/jsondiff v0.0.0-20210926074059-1e845ec5d249
+	golang.org/x/text v0.14.0
 	gopkg.in/gomail.v2 v2.0.0-20160411212932-81ebce5c23df
 )
 
@@ -1,133 +1,360 @@
 package main
 
 import (
+	"bytes"
+	"embed"
 	"encoding/json"
-	"fmt"
+	"html"
+	"html/template"
+	"log"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/nsf/jsondiff"
 )
 
 const (
-	blankRow    = "<tr><td class=\"blank\" colspan=4>&nbsp;</td></tr>\n"
-	blankCol    = "<td class=\"blank\">&nbsp;</td>"
-	headerRow   = "<tr><td class=\"blank\">&nbsp;</td><th>Property</th><th>Previous</th><th>Current</th></tr>\n"
 	indent      = "&nbsp;&nbsp;"
 	shortFldLen = 40  // Reasonable field to compare side-by-side
 	longFldLen  = 400 // Resonable field to compare as diff
 )
 
+// Private use characters marking jsondiff tags and indentation so the diff
+// text can be escaped before the markup is restored
+const (
+	markAdded   = "\uE000"
+	markRemoved = "\uE001"
+	markChanged = "\uE002"
+	markSkipped = "\uE003"
+	markEnd     = "\uE004"
+	markIndent  = "\t"
+)
+
+//go:embed templates/*.tmpl
+var templateFS embed.FS
+
+// reportTemplates ... the embedded templates, used to render reports
+// without a custom template. Custom templates are parsed into a new set by
+// loadTemplateOverride, so this set is never modified
+var reportTemplates = defaultTemplates()
+
+var (
+	jsonKeyRe      = regexp.MustCompile(`&#34;(\w+)&#34;:`)
+	diffMarkupRepl = strings.NewReplacer(
+		markAdded, `<span style="background-color: #8bff7f">`,
+		markRemoved, `<span style="background-color: #fd7f7f">`,
+		markChanged, `<span style="background-color: #fcff7f">`,
+		markSkipped, `<span style="color: rgba(0, 0, 0, 0.3)">`,
+		markEnd, `</span>`,
+		markIndent, indent,
+		"\n", "<br />\n",
+	)
+)
+
+// htmlReport ... data passed to the "report" template
+type htmlReport struct {
+	Time        time.Time
+	Window      int
+	Snapshot    string
+	ArchiveURL  string
+	Summary     executiveSummary
+	Digest      *digest
+	Maintenance *maintenanceRun
+	Inventory   *inventory
+	Items       []htmlItem
+	Deployments []htmlDeployment
+}
+
+// htmlDeployment ... data passed to the "deployment" template for the changes
+// of one CloudFormation stack or Terraform workspace
+type htmlDeployment struct {
+	Title     string
+	OutOfBand bool
+	Items     []htmlItem
+}
+
+// htmlItem ... data passed to the "item" template for each configuration item
+type htmlItem struct {
+	Label         string
+	Type          string
+	New           bool
+	JSON          template.HTML
+	Diffs         *htmlGroup
+	Actors        []actor
+	Severity      string
+	Risks         []riskFinding
+	Owner         string
+	OwnerSource   string
+	Steps         int
+	Suppressed    []suppressedChange
+	Maintenance   string
+	Members       []clusterMember
+	Terraform     *terraformMatch
+	Authorization *changeAuthorization
+	ConsoleURL    string
+	TimelineURL   string
+	Item          map[string]interface{}
+}
+
+// htmlGroup ... a set of property rows and nested groups for one level of diffs
+type htmlGroup struct {
+	Name   string
+	Rows   []htmlRow
+	Groups []*htmlGroup
+}
+
+// htmlRow ... a single changed property
+type htmlRow struct {
+	Property string
+	Previous string
+	Current  string
+	Diff     template.HTML
+	Hunks    []hunk
+	Omitted  int
+	FullURL  string
+}
+
+// htmlOptions ... limits applied when rendering long diffs
+type htmlOptions struct {
+	MaxDiffLines     int    // lines of each long diff shown before truncating, 0 for no limit
+	FullURL          string // link to the untruncated report
+	ClusterThreshold int    // identical changes to this many resources render once, 0 to disable
+}
+
 // parseItemsToHTML ... generic parsing of configservice.ConfigurationItems into html
 func parseItemsToHTML(items []map[string]interface{}) (string, error) {
-	str := ""
-
-	for _, i := range items {
-		if i["ResourceName"] == nil || i["ResourceName"] == "" {
-			str = fmt.Sprintf("%s%s<tr><td class=\"resource\" colspan=2>%s</td><td class=\"resource\" colspan=2>%s</td></tr>\n",
-				str, blankRow, i["ResourceId"], i["ResourceType"])
-		} else {
-			str = fmt.Sprintf("%s%s<tr><td class=\"resource\" colspan=2>%s</td><td class=\"resource\" colspan=2>%s</td></tr>\n",
-				str, blankRow, i["ResourceName"], i["ResourceType"])
-		}
+	view, err := htmlItems(items, htmlOptions{})
+	if err != nil {
+		return "", err
+	}
 
-		if val, ok := i["diffs"]; ok {
-			// There was a snapshot of this item
-			s, err := diffsToHTML(val.(map[string]interface{}), i, "")
-			if err != nil {
-				return s, err
-			}
+	return executeTemplate(reportTemplates, "items", view)
+}
 
-			str += s
-		} else {
-			// There was no snapshot of this item, so assume it is new
-			endRow := "</td></tr>\n"
-			str = str[:len(str)-len(endRow)] + " (New Item)" + endRow
-			slice, err := json.MarshalIndent(i, "", indent)
-			if err != nil {
-				return "", err
-			}
-			s := string(slice)
-			re := regexp.MustCompile("\"([\\w]+)\":")
-			s = re.ReplaceAllStringFunc(s, addStrong)
-			s = strings.Replace(s, "\n", "<br />\n", -1)
-			str += "<tr><td>&nbsp</td><td colspan=3>" + s + endRow
+// reportToHTML ... renders the complete HTML report body
+func reportToHTML(r *report) (string, error) {
+	opts := htmlOptions{
+		MaxDiffLines:     r.MaxDiffLines,
+		FullURL:          r.ArchiveURL,
+		ClusterThreshold: r.ClusterThreshold,
+	}
+
+	view, err := htmlItems(r.Items, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var deployments []htmlDeployment
+
+	for _, g := range groupByDeployment(r.Items) {
+		items, err := htmlItems(g.Items, opts)
+		if err != nil {
+			return "", err
 		}
+
+		deployments = append(deployments, htmlDeployment{Title: g.Title(), OutOfBand: g.Tool == "", Items: items})
 	}
 
-	return str, nil
+	return executeTemplate(r.templates(), "report", htmlReport{
+		Time:        r.Time,
+		Window:      window,
+		Snapshot:    r.snapshotName(),
+		ArchiveURL:  r.ArchiveURL,
+		Summary:     r.summary(),
+		Digest:      r.Digest,
+		Maintenance: r.Maintenance,
+		Inventory:   r.Inventory,
+		Items:       view,
+		Deployments: deployments,
+	})
 }
 
-func addStrong(s string) string {
-	return fmt.Sprintf("<strong>%s</strong>", s)
+// defaultTemplates ... parses the templates embedded in the binary
+func defaultTemplates() *template.Template {
+	return template.Must(template.ParseFS(templateFS, "templates/*.tmpl"))
 }
 
-func diffsToHTML(diffs, item map[string]interface{}, group string) (string, error) {
-	var str string
+// templates ... the templates rendering the report, the embedded defaults
+// unless a custom template was loaded for it
+func (r *report) templates() *template.Template {
+	if r.Templates == nil {
+		return reportTemplates
+	}
+
+	return r.Templates
+}
 
-	if group == "" {
-		str = blankRow + headerRow
-	} else {
-		str = fmt.Sprintf("<tr>%s<th class=\"group\" colspan=\"3\">%s</th></tr>\n", blankCol, group)
+func executeTemplate(t *template.Template, name string, data interface{}) (string, error) {
+	var b bytes.Buffer
+
+	if err := t.ExecuteTemplate(&b, name, data); err != nil {
+		return "", err
 	}
 
-	// Process all top level attributes
-	for key, value := range diffs {
-		switch t := value.(type) {
-		case map[string]interface{}:
-		default:
-			s, err := trDiff(key, t, item[key])
+	return b.String(), nil
+}
+
+func htmlItems(items []map[string]interface{}, opts htmlOptions) ([]htmlItem, error) {
+	clusters := clusterItems(items, opts.ClusterThreshold)
+	view := make([]htmlItem, 0, len(clusters))
+
+	for _, c := range clusters {
+		if len(c) == 1 {
+			v, err := htmlItemOf(c[0], opts)
 			if err != nil {
-				return "", err
+				return nil, err
 			}
 
-			str += s
+			view = append(view, v)
+
+			continue
 		}
+
+		// a change shared by many resources is rendered once, listing them
+		v, err := htmlItemOf(clusterRepresentative(c), opts)
+		if err != nil {
+			return nil, err
+		}
+
+		v.Members = clusterMembers(c)
+		view = append(view, v)
 	}
 
-	// Process recursive attributes
-	for key, value := range diffs {
-		switch t := value.(type) {
-		case map[string]interface{}:
-			if val, ok := t["diffs"]; ok {
-				s, err := diffsToHTML(val.(map[string]interface{}), item[key].(map[string]interface{}), key)
-				if err != nil {
-					return "", err
-				}
+	return view, nil
+}
 
-				str += s
+func htmlItemOf(i map[string]interface{}, opts htmlOptions) (htmlItem, error) {
+	v := htmlItem{
+		Label:         resourceLabel(i),
+		Type:          stringValue(i, "ResourceType"),
+		Actors:        itemActors(i),
+		Severity:      stringValue(i, severityKey),
+		Risks:         itemRisks(i),
+		Owner:         stringValue(i, ownerKey),
+		OwnerSource:   stringValue(i, ownerSourceKey),
+		Steps:         digestSteps(i),
+		Suppressed:    itemSuppressed(i),
+		Maintenance:   stringValue(i, maintenanceKey),
+		Terraform:     itemTerraform(i),
+		Authorization: itemAuthorization(i),
+		ConsoleURL:    stringValue(i, consoleURLKey),
+		TimelineURL:   stringValue(i, timelineURLKey),
+		Item:          i,
+	}
+
+	if val, ok := i["diffs"]; ok {
+		// There was a snapshot of this item
+		g, err := diffsToGroup(val.(map[string]interface{}), i, "", opts)
+		if err != nil {
+			return v, err
+		}
+
+		v.Diffs = g
+	} else {
+		// There was no snapshot of this item, so assume it is new
+		s, err := newItemHTML(i)
+		if err != nil {
+			return v, err
+		}
+
+		v.New = true
+		v.JSON = s
+	}
+
+	return v, nil
+}
+
+func newItemHTML(i map[string]interface{}) (template.HTML, error) {
+	slice, err := json.MarshalIndent(i, "", markIndent)
+	if err != nil {
+		return "", err
+	}
+
+	s := jsonKeyRe.ReplaceAllString(html.EscapeString(string(slice)), "<strong>&#34;$1&#34;:</strong>")
+
+	// #nosec G203 -- the JSON text has been escaped above
+	return template.HTML(diffMarkupRepl.Replace(s)), nil
+}
+
+func diffsToGroup(diffs, item map[string]interface{}, group string, opts htmlOptions) (*htmlGroup, error) {
+	g := &htmlGroup{Name: group}
+	keys := make([]string, 0, len(diffs))
+
+	for key := range diffs {
+		keys = append(keys, key)
+	}
 
-				break
-			} else {
-				s, err := trDiff(key, t, item[key])
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if t, ok := diffs[key].(map[string]interface{}); ok {
+			if val, ok := t["diffs"]; ok {
+				sub, _ := item[key].(map[string]interface{})
+
+				s, err := diffsToGroup(val.(map[string]interface{}), sub, key, opts)
 				if err != nil {
-					return "", err
+					return nil, err
 				}
 
-				str += s
+				g.Groups = append(g.Groups, s)
+
+				continue
 			}
-		default:
 		}
+
+		r, err := trDiff(key, diffs[key], item[key], opts)
+		if err != nil {
+			return nil, err
+		}
+
+		g.Rows = append(g.Rows, r)
 	}
 
-	return str, nil
+	return g, nil
 }
 
-func trDiff(k string, old, newer interface{}) (s string, err error) {
+func trDiff(k string, old, newer interface{}, opts htmlOptions) (r htmlRow, err error) {
 	a, b, err := myMarshal(old, newer)
 	if err != nil {
-		return "", err
+		return r, err
 	}
 
+	r.Property = k
+
 	if len(a) <= shortFldLen && len(b) <= shortFldLen {
-		s = fmt.Sprintf("<tr>%s<th>%s</th><td>%s</td><td>%s</td></tr>\n", blankCol, k, a, b)
+		r.Previous, r.Current = string(a), string(b)
 	} else if len(a) <= longFldLen && len(b) <= longFldLen {
-		s = fmt.Sprintf("<tr>%s<th>%s</th><td colspan=2>%s</td></tr>\n", blankCol, k, ppDiff(a, b))
+		r.Diff = ppDiff(a, b)
 	} else {
-		s = fmt.Sprintf("<tr>%s<th>%s</th><td colspan=2 align=\"center\"><em>long output suppressed</em></td></tr>\n", blankCol, k)
+		r.Hunks, err = jsonHunks(old, newer)
+		if err != nil {
+			return r, err
+		}
+
+		r.Hunks, r.Omitted = truncateHunks(r.Hunks, opts.MaxDiffLines)
+		r.FullURL = opts.FullURL
+	}
+
+	return r, nil
+}
+
+// jsonHunks ... line diff of the pretty-printed JSON of two values with
+// unchanged regions collapsed
+func jsonHunks(old, newer interface{}) ([]hunk, error) {
+	a, err := prettyJSON(old)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := prettyJSON(newer)
+	if err != nil {
+		return nil, err
 	}
 
-	return s, nil
+	return diffHunks(lineDiff(splitLines(a), splitLines(b)), diffContext), nil
 }
 
 func myMarshal(old, newer interface{}) (a, b []byte, err error) {
@@ -148,11 +375,43 @@ func myMarshal(old, newer interface{}) (a, b []byte, err error) {
 	return a, b, nil
 }
 
-func ppDiff(a, b []byte) string {
+func ppDiff(a, b []byte) template.HTML {
 	opts := jsondiff.DefaultHTMLOptions()
-	opts.Indent = indent
+	opts.Added = jsondiff.Tag{Begin: markAdded, End: markEnd}
+	opts.Removed = jsondiff.Tag{Begin: markRemoved, End: markEnd}
+	opts.Changed = jsondiff.Tag{Begin: markChanged, End: markEnd}
+	opts.Skipped = jsondiff.Tag{Begin: markSkipped, End: markEnd}
+	opts.Indent = markIndent
 	_, s := jsondiff.Compare(a, b, &opts)
-	s = strings.Replace(s, "\n", "<br />\n", -1)
 
-	return s
+	// #nosec G203 -- the diff text is escaped before the markup is restored
+	return template.HTML(diffMarkupRepl.Replace(html.EscapeString(s)))
+}
+
+// loadTemplateOverride ... the embedded templates, with a custom template
+// from S3 parsed over a new copy of them so it can redefine any of the named
+// templates. The embedded templates are returned with any error
+func loadTemplateOverride(cfg *config, svc s3iface.S3API) (*template.Template, error) {
+	if cfg.TemplateKey == "" {
+		return reportTemplates, nil
+	}
+
+	bucket := cfg.TemplateBucket
+	if bucket == "" {
+		bucket = cfg.S3Bucket
+	}
+
+	s, err := getObject(svc, bucket, cfg.TemplateKey)
+	if err != nil {
+		return reportTemplates, err
+	}
+
+	t, err := defaultTemplates().Parse(s)
+	if err != nil {
+		return reportTemplates, err
+	}
+
+	log.Printf("using report template s3://%s/%s\n", bucket, cfg.TemplateKey)
+
+	return t, nil
 }
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"fmt"
+	"io"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -34,9 +37,9 @@ func TestParseItemsToHTMLCase2(t *testing.T) {
 	html := `<tr><td class="blank" colspan=4>&nbsp;</td></tr>
 <tr><td class="resource" colspan=2>testName1</td><td class="resource" colspan=2>testType1 (New Item)</td></tr>
 <tr><td>&nbsp</td><td colspan=3>{<br />
-&nbsp;&nbsp;<strong>"ResourceId":</strong> "testID1",<br />
-&nbsp;&nbsp;<strong>"ResourceName":</strong> "testName1",<br />
-&nbsp;&nbsp;<strong>"ResourceType":</strong> "testType1"<br />
+&nbsp;&nbsp;<strong>&#34;ResourceId&#34;:</strong> &#34;testID1&#34;,<br />
+&nbsp;&nbsp;<strong>&#34;ResourceName&#34;:</strong> &#34;testName1&#34;,<br />
+&nbsp;&nbsp;<strong>&#34;ResourceType&#34;:</strong> &#34;testType1&#34;<br />
 }</td></tr>
 `
 
@@ -56,7 +59,7 @@ func TestParseItemsToHTMLCase2(t *testing.T) {
 <tr><td class="resource" colspan=2>testName1</td><td class="resource" colspan=2>testType1</td></tr>
 <tr><td class="blank" colspan=4>&nbsp;</td></tr>
 <tr><td class="blank">&nbsp;</td><th>Property</th><th>Previous</th><th>Current</th></tr>
-<tr><td class="blank">&nbsp;</td><th>ResourceName</th><td>"oldName1"</td><td>"testName1"</td></tr>
+<tr><td class="blank">&nbsp;</td><th>ResourceName</th><td>&#34;oldName1&#34;</td><td>&#34;testName1&#34;</td></tr>
 `
 
 	str, err = parseItemsToHTML(myMap)
@@ -87,6 +90,73 @@ func TestParseItemsToHTMLCase2(t *testing.T) {
 	}
 }
 
+func TestParseItemsToHTMLEscaping(t *testing.T) {
+	script := "<script>alert(1)</script>"
+	resource := `{"ResourceId": "testID1", "ResourceName": %q, "ResourceType": "testType1",
+		"Tags": {"Name": %[1]q}, "Configuration": {"policy": %q}}`
+	tMap := changedTestItem(t, fmt.Sprintf(resource, "old", "a > b"),
+		fmt.Sprintf(resource, script, "a < b && "+strings.Repeat("c", shortFldLen)))
+
+	for name, items := range map[string][]map[string]interface{}{
+		"diffs":    {tMap},
+		"new item": {{"ResourceId": script, "ResourceType": "testType1", "Tags": map[string]interface{}{"Name": script}}},
+	} {
+		str, err := parseItemsToHTML(items)
+		if err != nil {
+			t.Errorf("%s: did not expect error: %v", name, err)
+		}
+
+		for _, s := range []string{"<script>", "a < b", "a > b"} {
+			if strings.Contains(str, s) {
+				t.Errorf("%s: expected %q to be escaped. Got:\n%s", name, s, str)
+			}
+		}
+	}
+}
+
+func TestLoadTemplateOverride(t *testing.T) {
+	m := &mockS3{
+		Object: s3.GetObjectOutput{
+			Body: io.NopCloser(strings.NewReader(`{{define "item"}}<p>{{.Label}}</p>{{end}}`)),
+		},
+	}
+	cfg := config{S3Bucket: "test", TemplateKey: "report.tmpl"}
+
+	templates, err := loadTemplateOverride(&cfg, m)
+	if err != nil {
+		t.Fatalf("loadTemplateOverride() failed. Unexpected error: %v", err)
+	}
+
+	view, err := htmlItems([]map[string]interface{}{{"ResourceId": "<id>"}}, htmlOptions{})
+	chkErr(t, err)
+
+	str, err := executeTemplate(templates, "items", view)
+	chkErr(t, err)
+
+	if str != "<p>&lt;id&gt;</p>" {
+		t.Errorf("loadTemplateOverride() failed. Expected custom item template. Got: %s", str)
+	}
+
+	// reports without the custom template, such as a later report, keep the defaults
+	h, err := reportToHTML(&report{Items: []map[string]interface{}{{"ResourceId": "<id>"}}})
+	chkErr(t, err)
+
+	if strings.Contains(h, "<p>&lt;id&gt;</p>") {
+		t.Errorf("loadTemplateOverride() failed. Expected the embedded templates unchanged, got: %s", h)
+	}
+
+	h, err = reportToHTML(&report{Items: []map[string]interface{}{{"ResourceId": "<id>"}}, Templates: templates})
+	chkErr(t, err)
+
+	if !strings.Contains(h, "<p>&lt;id&gt;</p>") {
+		t.Errorf("reportToHTML() failed. Expected the report's custom template, got: %s", h)
+	}
+
+	if _, err := loadTemplateOverride(&config{S3Bucket: "test", TemplateKey: "missing.tmpl"}, &mockS3{}); err == nil {
+		t.Errorf("loadTemplateOverride() failed. Expected error for a missing template")
+	}
+}
+
 // TestIssue26 ... SupplementaryConfiguration.unsupportedResources is not being
 // unmarshalled into JSON:
 //
@@ -125,3 +195,62 @@ func TestIssue26(t *testing.T) {
 		t.Errorf("Issue26 failed: expected: \n%s\ngot: \n%s\n", expected, str)
 	}
 }
+
+func TestTrDiffLong(t *testing.T) {
+	statement := func(sid, effect string) map[string]interface{} {
+		return map[string]interface{}{
+			"Sid":       sid,
+			"Effect":    effect,
+			"Principal": "*",
+			"Action":    []interface{}{"s3:GetObject", "s3:PutObject"},
+			"Resource":  "arn:aws:s3:::bucket-name/<prefix>/*",
+		}
+	}
+	old := map[string]interface{}{"Statement": []interface{}{
+		statement("One", "Allow"), statement("Two", "Allow"), statement("Three", "Allow"),
+	}}
+	newer := map[string]interface{}{"Statement": []interface{}{
+		statement("One", "Allow"), statement("Two", "Deny"), statement("Three", "Allow"),
+	}}
+	tt := map[string]struct {
+		opts     htmlOptions
+		expected []string
+		omitted  bool
+	}{
+		"full diff": {
+			expected: []string{`<div class="del">-      &#34;Effect&#34;: &#34;Allow&#34;,</div>`,
+				`<div class="ins">&#43;      &#34;Effect&#34;: &#34;Deny&#34;,</div>`, "&lt;prefix&gt;", "@@ -"},
+		},
+		"truncated with link": {
+			opts:     htmlOptions{MaxDiffLines: 2, FullURL: "https://example.com/report.html"},
+			expected: []string{`more lines not shown`, `<a href="https://example.com/report.html">`},
+			omitted:  true,
+		},
+		"truncated without link": {
+			opts:     htmlOptions{MaxDiffLines: 2},
+			expected: []string{`See the plain text report for the full diff`},
+			omitted:  true,
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			r, err := trDiff("Policy", old, newer, tc.opts)
+			chkErr(t, err)
+
+			if len(r.Hunks) == 0 || (r.Omitted > 0) != tc.omitted {
+				t.Fatalf("trDiff() failed. Unexpected row: %+v", r)
+			}
+
+			h, err := executeTemplate(reportTemplates, "row", r)
+			chkErr(t, err)
+
+			for _, s := range tc.expected {
+				if !strings.Contains(h, s) {
+					t.Errorf("trDiff() failed. Expected %q in:\n%s", s, h)
+				}
+			}
+		})
+	}
+}
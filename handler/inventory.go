@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	inventoryPrefix = "inventory"
+	inventoryLatest = "latest.json"
+	// age of the oldest snapshot an inventory is built from
+	inventoryLookback = 7 * 24 * time.Hour
+)
+
+// inventoryRecord ... the key attributes of one resource in the inventory
+type inventoryRecord struct {
+	ResourceType string            `json:"resource_type"`
+	ResourceID   string            `json:"resource_id"`
+	Name         string            `json:"name"`
+	AccountID    string            `json:"account_id"`
+	Region       string            `json:"region"`
+	Arn          string            `json:"arn"`
+	Tags         map[string]string `json:"tags"`
+	Owner        string            `json:"owner"`
+	Created      *time.Time        `json:"created,omitempty"`
+}
+
+// inventory ... a complete component inventory (CM-8) and the resources added
+// and removed since the previous inventory
+type inventory struct {
+	Time      time.Time         `json:"time"`
+	Snapshot  string            `json:"snapshot"`
+	Previous  *time.Time        `json:"previous,omitempty"`
+	Resources []inventoryRecord `json:"resources"`
+	Added     []inventoryRecord `json:"added"`
+	Removed   []inventoryRecord `json:"removed"`
+	Files     map[string]string `json:"-"`
+}
+
+// inventoryGroup ... the resources of one type
+type inventoryGroup struct {
+	ResourceType string
+	Resources    []inventoryRecord
+}
+
+// key ... identifies the record's resource across inventories
+func (r *inventoryRecord) key() string {
+	return r.ResourceType + "|" + r.ResourceID
+}
+
+// label ... the name of the resource, or its ID if unnamed
+func (r *inventoryRecord) label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	return r.ResourceID
+}
+
+// Groups ... the resources grouped by type, in type order
+func (inv *inventory) Groups() []inventoryGroup {
+	var groups []inventoryGroup
+
+	for _, r := range inv.Resources {
+		if len(groups) == 0 || groups[len(groups)-1].ResourceType != r.ResourceType {
+			groups = append(groups, inventoryGroup{ResourceType: r.ResourceType})
+		}
+
+		g := &groups[len(groups)-1]
+		g.Resources = append(g.Resources, r)
+	}
+
+	return groups
+}
+
+// buildInventory ... an inventory record for every resource in the snapshot,
+// with owners resolved as for changes, and for every discovered resource the
+// snapshot does not include. Records are sorted by type, then name
+func buildInventory(items []map[string]interface{}, discovered []*configservice.ResourceIdentifier,
+	cfg *config, account string) []inventoryRecord {
+	resolveOwners(items, items, cfg)
+
+	records := make([]inventoryRecord, 0, len(items))
+	seen := make(map[string]bool, len(items))
+
+	for _, i := range items {
+		r := inventoryRecord{
+			ResourceType: stringValue(i, "ResourceType"),
+			ResourceID:   stringValue(i, "ResourceId"),
+			Name:         stringValue(i, "ResourceName"),
+			AccountID:    stringValue(i, "AccountId"),
+			Region:       stringValue(i, "AwsRegion"),
+			Arn:          stringValue(i, "Arn"),
+			Owner:        stringValue(i, ownerKey),
+		}
+
+		if t, err := time.Parse(time.RFC3339, stringValue(i, "ResourceCreationTime")); err == nil {
+			r.Created = &t
+		}
+
+		tags, _ := i["Tags"].(map[string]interface{})
+		for k, v := range tags {
+			if r.Tags == nil {
+				r.Tags = make(map[string]string, len(tags))
+			}
+
+			r.Tags[k] = fmt.Sprint(v)
+		}
+
+		seen[r.key()] = true
+		records = append(records, r)
+	}
+
+	for _, d := range discovered {
+		r := inventoryRecord{
+			ResourceType: aws.StringValue(d.ResourceType),
+			ResourceID:   aws.StringValue(d.ResourceId),
+			Name:         aws.StringValue(d.ResourceName),
+			AccountID:    account,
+			Region:       cfg.DefaultRegion,
+			Owner:        cfg.DefaultOwner,
+		}
+
+		if d.ResourceDeletionTime != nil || seen[r.key()] {
+			continue
+		}
+
+		seen[r.key()] = true
+		records = append(records, r)
+	}
+
+	sortInventory(records)
+
+	return records
+}
+
+func sortInventory(records []inventoryRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := &records[i], &records[j]
+		if a.ResourceType != b.ResourceType {
+			return a.ResourceType < b.ResourceType
+		}
+
+		if a.label() != b.label() {
+			return a.label() < b.label()
+		}
+
+		return a.ResourceID < b.ResourceID
+	})
+}
+
+// inventoryDelta ... the resources added and removed since the previous inventory
+func inventoryDelta(previous, current []inventoryRecord) (added, removed []inventoryRecord) {
+	before := make(map[string]bool, len(previous))
+	for n := range previous {
+		before[previous[n].key()] = true
+	}
+
+	now := make(map[string]bool, len(current))
+
+	for n := range current {
+		now[current[n].key()] = true
+
+		if !before[current[n].key()] {
+			added = append(added, current[n])
+		}
+	}
+
+	for n := range previous {
+		if !now[previous[n].key()] {
+			removed = append(removed, previous[n])
+		}
+	}
+
+	return added, removed
+}
+
+// inventoryToCSV ... one row per resource with its tags as key=value pairs
+func inventoryToCSV(inv *inventory) ([]byte, error) {
+	var b bytes.Buffer
+
+	w := csv.NewWriter(&b)
+	rows := [][]string{{"resource_type", "resource_id", "name", "account_id", "region", "arn", "owner", "created", "tags"}}
+
+	for _, r := range inv.Resources {
+		created := ""
+		if r.Created != nil {
+			created = r.Created.UTC().Format(time.RFC3339)
+		}
+
+		rows = append(rows, []string{r.ResourceType, r.ResourceID, r.Name, r.AccountID, r.Region, r.Arn, r.Owner, created, tagList(r.Tags)})
+	}
+
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// tagList ... tags as sorted key=value pairs separated by semicolons
+func tagList(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ";")
+}
+
+// inventoryToText ... the plain text heading, counts by type and delta of an inventory
+func inventoryToText(inv *inventory) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "Component Inventory at %v\n%d resources", inv.Time, len(inv.Resources))
+
+	if inv.Previous != nil {
+		fmt.Fprintf(&sb, ", %d added and %d removed since %v", len(inv.Added), len(inv.Removed), *inv.Previous)
+	}
+
+	sb.WriteString("\n")
+
+	for _, name := range []string{"html", "csv", "json"} {
+		if u, ok := inv.Files[name]; ok {
+			fmt.Fprintf(&sb, "%s: %s\n", strings.ToUpper(name), u)
+		}
+	}
+
+	for _, section := range []struct {
+		name    string
+		records []inventoryRecord
+	}{
+		{"Added", inv.Added},
+		{"Removed", inv.Removed},
+	} {
+		if len(section.records) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&sb, "\n%s\n", section.name)
+
+		for _, r := range section.records {
+			fmt.Fprintf(&sb, "%s%s (%s) %s\n", textIndent, r.label(), r.ResourceType, r.Owner)
+		}
+	}
+
+	fmt.Fprintf(&sb, "\nBy resource type\n")
+
+	for _, g := range inv.Groups() {
+		fmt.Fprintf(&sb, "%s%s: %d\n", textIndent, g.ResourceType, len(g.Resources))
+	}
+
+	return sb.String()
+}
+
+// inventorySnapshot ... the latest configuration snapshot delivered within
+// the lookback, nil if there is none. Daily snapshots are not delivered
+// exactly a day apart, so the previous day's may be the latest
+func inventorySnapshot(svc s3iface.S3API, bucket, account, region string, now time.Time) (*s3.Object, error) {
+	snapshots, err := listSnapshots(svc, bucket, account, region, now.Add(-inventoryLookback), now)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+
+	return snapshots[len(snapshots)-1], nil
+}
+
+// regionSnapshots ... the items of the latest snapshot of every region
+// delivering to the bucket, and the snapshots they were read from. Regions
+// without a snapshot, or whose snapshot cannot be read, are logged and skipped
+func regionSnapshots(svc s3iface.S3API, bucket, account string, now time.Time) ([]map[string]interface{}, []*s3.Object, error) {
+	regions, err := configRegions(svc, bucket, account)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		items     []map[string]interface{}
+		snapshots []*s3.Object
+	)
+
+	for _, region := range regions {
+		o, err := inventorySnapshot(svc, bucket, account, region, now)
+		if err != nil || o == nil {
+			log.Printf("no snapshot of %s delivered since %v: %v\n", region, now.Add(-inventoryLookback), err)
+			continue
+		}
+
+		snapshot, err := readSnapshotItems(svc, bucket, o)
+		if err != nil {
+			log.Printf("error reading the snapshot of %s, skipping its resources: %v\n", region, err)
+			continue
+		}
+
+		items = append(items, snapshot...)
+		snapshots = append(snapshots, o)
+	}
+
+	return items, snapshots, nil
+}
+
+// previousInventory ... the last inventory written, nil if there is none
+func previousInventory(svc s3iface.S3API, cfg *config) (*inventory, error) {
+	s, err := getObject(svc, cfg.ArchiveBucket, archiveKey(cfg, path.Join(inventoryPrefix, inventoryLatest)))
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	var inv inventory
+	if err := json.Unmarshal([]byte(s), &inv); err != nil {
+		return nil, fmt.Errorf("error parsing previous inventory: %v", err)
+	}
+
+	return &inv, nil
+}
+
+// writeInventory ... exports the inventory as HTML, CSV and JSON to a date
+// partitioned archive prefix and replaces the latest inventory, setting the
+// links to each file
+func writeInventory(svc s3iface.S3API, cfg *config, inv *inventory, templates *template.Template) error {
+	j, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	c, err := inventoryToCSV(inv)
+	if err != nil {
+		return err
+	}
+
+	h, err := executeTemplate(templates, "inventory", inv)
+	if err != nil {
+		return err
+	}
+
+	dir := path.Join(inventoryPrefix, archiveDir(inv.Time))
+	inv.Files = make(map[string]string)
+
+	for _, f := range []archiveFile{
+		{Name: "inventory.html", ContentType: "text/html; charset=utf-8", Body: []byte(h)},
+		{Name: "inventory.csv", ContentType: "text/csv; charset=utf-8", Body: c},
+		{Name: "inventory.json", ContentType: "application/json", Body: j},
+	} {
+		rel := path.Join(dir, f.Name)
+		if err := putArchiveObject(svc, cfg, rel, f.ContentType, f.Body); err != nil {
+			return err
+		}
+
+		inv.Files[strings.TrimPrefix(path.Ext(f.Name), ".")] = archiveURL(cfg, rel)
+	}
+
+	if err := putArchiveObject(svc, cfg, path.Join(inventoryPrefix, inventoryLatest), "application/json", j); err != nil {
+		return err
+	}
+
+	log.Printf("Inventory of %d resources written to s3://%s/%s\n", len(inv.Resources), cfg.ArchiveBucket, archiveKey(cfg, dir))
+
+	return nil
+}
+
+// inventoryReport ... builds the inventory from the latest snapshot of each
+// region and the discovered resources, exports it to the archive and sends its delta to the
+// configured notifiers
+func inventoryReport() error {
+	cfg, sess, err := getSess()
+	if err != nil {
+		return err
+	}
+
+	if cfg.ArchiveBucket == "" {
+		return errors.New("inventory requires archive_bucket")
+	}
+
+	id, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if err != nil {
+		return err
+	}
+
+	account := aws.StringValue(id.Account)
+	s3Svc := s3.New(sess)
+	now := time.Now().UTC()
+
+	items, snapshots, err := regionSnapshots(s3Svc, cfg.S3Bucket, account, now)
+	if err != nil {
+		return err
+	}
+
+	if len(snapshots) == 0 {
+		log.Printf("no snapshot delivered since %v, listing discovered resources only\n", now.Add(-inventoryLookback))
+	}
+
+	c := CfgSvc{Client: configservice.New(sess)}
+
+	discovered, err := c.GetDiscoveredResources()
+	if err != nil {
+		return err
+	}
+
+	inv := &inventory{Time: now, Resources: buildInventory(items, discovered, &cfg, account)}
+	for _, o := range snapshots {
+		if inv.Snapshot != "" {
+			inv.Snapshot += ", "
+		}
+
+		inv.Snapshot += path.Base(aws.StringValue(o.Key))
+	}
+
+	previous, err := previousInventory(s3Svc, &cfg)
+	if err != nil {
+		log.Printf("error reading the previous inventory, not listing additions and removals: %v\n", err)
+	} else if previous != nil {
+		inv.Previous = &previous.Time
+		inv.Added, inv.Removed = inventoryDelta(previous.Resources, inv.Resources)
+	}
+
+	templates, err := loadTemplateOverride(&cfg, s3Svc)
+	if err != nil {
+		log.Printf("error loading report template, using default: %v\n", err)
+	}
+
+	if err := writeInventory(s3Svc, &cfg, inv, templates); err != nil {
+		return err
+	}
+
+	notifiers, err := newNotifiers(&cfg, sess)
+	if err != nil {
+		return fmt.Errorf("error configuring notifiers: %v", err)
+	}
+
+	r := &report{Time: now, ArchiveURL: inv.Files["html"], Inventory: inv, Templates: templates}
+
+	return notify(notifiers, r)
+}
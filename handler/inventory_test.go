@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/configservice"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// helper functions //
+func inventoryTestItems(t *testing.T) []map[string]interface{} {
+	return []map[string]interface{}{
+		digestTestItem(t, `{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs", "ResourceName": "logs",
+			"AccountId": "123", "AwsRegion": "us-east-1", "Arn": "arn:aws:s3:::logs",
+			"ResourceCreationTime": "2020-01-02T03:04:05Z", "Tags": {"Owner": "ops", "Env": "prod"}}`),
+		digestTestItem(t, `{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-2", "AccountId": "123",
+			"AwsRegion": "us-east-1", "Tags": {}}`),
+		digestTestItem(t, `{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-1", "ResourceName": "bastion",
+			"AccountId": "123", "AwsRegion": "us-east-1"}`),
+	}
+}
+
+// test functions //
+func TestBuildInventory(t *testing.T) {
+	cfg := &config{OwnerTags: []string{"Owner"}, DefaultOwner: "unowned", DefaultRegion: "us-east-1"}
+	discovered := []*configservice.ResourceIdentifier{
+		{ResourceType: aws.String("AWS::EC2::Instance"), ResourceId: aws.String("i-1")},
+		{ResourceType: aws.String("AWS::SQS::Queue"), ResourceId: aws.String("q"), ResourceName: aws.String("jobs")},
+		{ResourceType: aws.String("AWS::SQS::Queue"), ResourceId: aws.String("gone"), ResourceDeletionTime: aws.Time(time.Now())},
+	}
+
+	records := buildInventory(inventoryTestItems(t), discovered, cfg, "123")
+
+	var keys []string
+	for _, r := range records {
+		keys = append(keys, r.key())
+	}
+
+	expected := "AWS::EC2::Instance|i-1,AWS::EC2::Instance|i-2,AWS::S3::Bucket|logs,AWS::SQS::Queue|q"
+	if strings.Join(keys, ",") != expected {
+		t.Fatalf("buildInventory() failed. Expected %s, got: %v", expected, keys)
+	}
+
+	bucket := records[2]
+	if bucket.Owner != "ops" || bucket.Tags["Env"] != "prod" || bucket.Created == nil || bucket.Created.Year() != 2020 ||
+		bucket.Arn != "arn:aws:s3:::logs" {
+		t.Errorf("buildInventory() failed. Unexpected bucket record: %+v", bucket)
+	}
+
+	if q := records[3]; q.Name != "jobs" || q.AccountID != "123" || q.Region != "us-east-1" || q.Owner != "unowned" {
+		t.Errorf("buildInventory() failed. Unexpected discovered record: %+v", q)
+	}
+
+	inv := &inventory{Resources: records}
+	if g := inv.Groups(); len(g) != 3 || g[0].ResourceType != "AWS::EC2::Instance" || len(g[0].Resources) != 2 {
+		t.Errorf("Groups() failed. Expected 3 groups by type, got: %+v", g)
+	}
+}
+
+func TestInventoryDelta(t *testing.T) {
+	previous := []inventoryRecord{{ResourceType: "AWS::S3::Bucket", ResourceID: "a"}, {ResourceType: "AWS::S3::Bucket", ResourceID: "b"}}
+	current := []inventoryRecord{{ResourceType: "AWS::S3::Bucket", ResourceID: "b"}, {ResourceType: "AWS::S3::Bucket", ResourceID: "c"}}
+
+	added, removed := inventoryDelta(previous, current)
+	if len(added) != 1 || added[0].ResourceID != "c" || len(removed) != 1 || removed[0].ResourceID != "a" {
+		t.Errorf("inventoryDelta() failed. Expected c added and a removed, got: %+v, %+v", added, removed)
+	}
+}
+
+func TestWriteInventory(t *testing.T) {
+	cfg := &config{ArchiveBucket: "archive", ArchivePrefix: "reports", ArchiveURL: "https://archive", OwnerTags: []string{"Owner"}}
+	m := &mockS3{Puts: map[string][]byte{}}
+	now := time.Date(2020, 3, 8, 3, 0, 0, 0, time.UTC)
+
+	previous, err := previousInventory(m, cfg)
+	chkErr(t, err)
+
+	if previous != nil {
+		t.Fatalf("previousInventory() failed. Expected none before the first inventory, got: %+v", previous)
+	}
+
+	first := &inventory{Time: now, Resources: buildInventory(inventoryTestItems(t)[:1], nil, cfg, "123")}
+	chkErr(t, writeInventory(m, cfg, first, reportTemplates))
+
+	for _, key := range []string{
+		"reports/inventory/2020/03/08/" + now.Format(archiveTimeFormat) + "/inventory.html",
+		"reports/inventory/2020/03/08/" + now.Format(archiveTimeFormat) + "/inventory.csv",
+		"reports/inventory/2020/03/08/" + now.Format(archiveTimeFormat) + "/inventory.json",
+		"reports/inventory/latest.json",
+	} {
+		if _, ok := m.Puts[key]; !ok {
+			t.Errorf("writeInventory() failed. Expected %s to be written", key)
+		}
+	}
+
+	if !strings.HasPrefix(first.Files["csv"], "https://archive/inventory/2020/03/08/") {
+		t.Errorf("writeInventory() failed. Unexpected links: %v", first.Files)
+	}
+
+	latest := string(m.Puts["reports/inventory/latest.json"])
+	if !strings.Contains(latest, `"resource_id": "logs"`) {
+		t.Errorf("writeInventory() failed. Expected the latest inventory as JSON, got: %s", latest)
+	}
+
+	for k, v := range m.Puts {
+		if strings.HasSuffix(k, ".csv") && !strings.Contains(string(v), "AWS::S3::Bucket,logs,logs,123,us-east-1,arn:aws:s3:::logs,ops,2020-01-02T03:04:05Z,Env=prod;Owner=ops") {
+			t.Errorf("inventoryToCSV() failed. Unexpected CSV:\n%s", v)
+		}
+
+		if strings.HasSuffix(k, ".html") && !strings.Contains(string(v), "<h2>AWS::S3::Bucket (1)</h2>") {
+			t.Errorf("writeInventory() failed. Expected the resources grouped by type in:\n%s", v)
+		}
+	}
+
+	previous, err = previousInventory(m, cfg)
+	chkErr(t, err)
+
+	second := &inventory{Time: now.Add(24 * time.Hour), Resources: buildInventory(inventoryTestItems(t)[1:], nil, cfg, "123")}
+	second.Previous = &previous.Time
+	second.Added, second.Removed = inventoryDelta(previous.Resources, second.Resources)
+	chkErr(t, writeInventory(m, cfg, second, reportTemplates))
+
+	r := &report{Time: second.Time, ArchiveURL: second.Files["html"], Inventory: second}
+	if s := reportSubject(r); !strings.HasPrefix(s, "Inventory: 2 resources (2 added, 1 removed)") {
+		t.Errorf("reportSubject() failed. Unexpected subject: %s", s)
+	}
+
+	h, err := reportToHTML(r)
+	chkErr(t, err)
+
+	if !strings.Contains(h, "<h2>Removed</h2>") || !strings.Contains(h, "<td>logs</td>") || strings.Contains(h, "Summary") {
+		t.Errorf("reportToHTML() failed. Expected the inventory delta in:\n%s", h)
+	}
+
+	text, err := reportToText(r)
+	chkErr(t, err)
+
+	if !strings.Contains(text, "2 added and 1 removed") || !strings.Contains(text, "AWS::EC2::Instance: 2") {
+		t.Errorf("reportToText() failed. Expected the inventory delta in:\n%s", text)
+	}
+
+	j, err := reportToJSON(r)
+	chkErr(t, err)
+
+	var decoded inventory
+	if err := json.Unmarshal(j, &decoded); err != nil || len(decoded.Removed) != 1 || decoded.Previous == nil {
+		t.Errorf("reportToJSON() failed. Expected the inventory, got: %s", j)
+	}
+}
+
+func TestInventorySnapshot(t *testing.T) {
+	now := time.Date(2020, 3, 8, 3, 0, 0, 0, time.UTC)
+	m := &mockS3{Objects: s3.ListObjectsOutput{Contents: []*s3.Object{
+		{Key: aws.String("older.json.gz"), LastModified: aws.Time(now.Add(-50 * time.Hour))},
+		{Key: aws.String("late.json.gz"), LastModified: aws.Time(now.Add(-25 * time.Hour))},
+	}}}
+
+	o, err := inventorySnapshot(m, "bucket", "123", "us-east-1", now)
+	chkErr(t, err)
+
+	if aws.StringValue(o.Key) != "late.json.gz" {
+		t.Errorf("inventorySnapshot() failed. Expected the latest snapshot older than a day, got: %v", o)
+	}
+
+	o, err = inventorySnapshot(&mockS3{}, "bucket", "123", "us-east-1", now)
+	chkErr(t, err)
+
+	if o != nil {
+		t.Errorf("inventorySnapshot() failed. Expected no snapshot, got: %v", o)
+	}
+}
+
+func TestRegionSnapshots(t *testing.T) {
+	now := time.Date(2020, 3, 8, 3, 0, 0, 0, time.UTC)
+	m := &mockS3{Modified: now.Add(-time.Hour), Puts: map[string][]byte{
+		snapshotPrefix("123", "us-east-1", now) + "/east.json": []byte(`{"configurationItems": [
+			{"resourceType": "AWS::S3::Bucket", "resourceId": "logs", "awsRegion": "us-east-1"}]}`),
+		snapshotPrefix("123", "eu-west-3", now) + "/paris.json": []byte(`{"configurationItems": [
+			{"resourceType": "AWS::EC2::Instance", "resourceId": "i-paris", "awsRegion": "eu-west-3"}]}`),
+	}}
+
+	items, snapshots, err := regionSnapshots(m, "bucket", "123", now)
+	chkErr(t, err)
+
+	if len(items) != 2 || len(snapshots) != 2 || stringValue(items[0], "AwsRegion") != "eu-west-3" ||
+		stringValue(items[1], "AwsRegion") != "us-east-1" {
+		t.Errorf("regionSnapshots() failed. Expected the resources of both regions, got: %v from %v", items, snapshots)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+const (
+	consoleURLKey  = "ConsoleURL"
+	timelineURLKey = "TimelineURL"
+	consoleHost    = "console.aws.amazon.com"
+	govConsoleHost = "console.amazonaws-us-gov.com"
+	globalRegion   = "global"
+)
+
+// consoleLinks ... console URL formats by resource type. {host}, {region}, {id},
+// {name} and {arn} are replaced with the item's values
+var consoleLinks = map[string]string{
+	"AWS::EC2::Instance":                        "https://{host}/ec2/home?region={region}#InstanceDetails:instanceId={id}",
+	"AWS::EC2::SecurityGroup":                   "https://{host}/ec2/home?region={region}#SecurityGroup:groupId={id}",
+	"AWS::EC2::Volume":                          "https://{host}/ec2/home?region={region}#VolumeDetails:volumeId={id}",
+	"AWS::EC2::NetworkInterface":                "https://{host}/ec2/home?region={region}#NetworkInterface:networkInterfaceId={id}",
+	"AWS::EC2::EIP":                             "https://{host}/ec2/home?region={region}#ElasticIpDetails:AllocationId={id}",
+	"AWS::EC2::VPC":                             "https://{host}/vpc/home?region={region}#VpcDetails:VpcId={id}",
+	"AWS::EC2::Subnet":                          "https://{host}/vpc/home?region={region}#SubnetDetails:subnetId={id}",
+	"AWS::EC2::RouteTable":                      "https://{host}/vpc/home?region={region}#RouteTableDetails:RouteTableId={id}",
+	"AWS::EC2::InternetGateway":                 "https://{host}/vpc/home?region={region}#InternetGateway:internetGatewayId={id}",
+	"AWS::EC2::NatGateway":                      "https://{host}/vpc/home?region={region}#NatGatewayDetails:natGatewayId={id}",
+	"AWS::EC2::NetworkAcl":                      "https://{host}/vpc/home?region={region}#NetworkAclDetails:networkAclId={id}",
+	"AWS::EC2::VPCEndpoint":                     "https://{host}/vpc/home?region={region}#EndpointDetails:vpcEndpointId={id}",
+	"AWS::ElasticLoadBalancingV2::LoadBalancer": "https://{host}/ec2/home?region={region}#LoadBalancer:loadBalancerArn={id}",
+	"AWS::IAM::User":                            "https://{host}/iam/home#/users/{name}",
+	"AWS::IAM::Role":                            "https://{host}/iam/home#/roles/{name}",
+	"AWS::IAM::Group":                           "https://{host}/iam/home#/groups/{name}",
+	"AWS::IAM::Policy":                          "https://{host}/iam/home#/policies/{arn}",
+	"AWS::S3::Bucket":                           "https://{host}/s3/buckets/{name}?region={region}",
+	"AWS::Lambda::Function":                     "https://{host}/lambda/home?region={region}#/functions/{name}",
+	"AWS::RDS::DBInstance":                      "https://{host}/rds/home?region={region}#database:id={name};is-cluster=false",
+	"AWS::RDS::DBCluster":                       "https://{host}/rds/home?region={region}#database:id={name};is-cluster=true",
+	"AWS::KMS::Key":                             "https://{host}/kms/home?region={region}#/kms/keys/{id}",
+	"AWS::CloudTrail::Trail":                    "https://{host}/cloudtrail/home?region={region}#/trails/{arn}",
+	"AWS::DynamoDB::Table":                      "https://{host}/dynamodbv2/home?region={region}#table?name={name}",
+	"AWS::SNS::Topic":                           "https://{host}/sns/v3/home?region={region}#/topic/{arn}",
+	"AWS::CloudFormation::Stack":                "https://{host}/cloudformation/home?region={region}#/stacks/stackinfo?stackId={id}",
+}
+
+// addConsoleLinks ... adds console and AWS Config timeline URLs to each item
+// so they are rendered in the HTML report and included in the JSON outputs
+func addConsoleLinks(items []map[string]interface{}, defaultRegion string) {
+	for _, i := range items {
+		if u := consoleURL(i, defaultRegion); u != "" {
+			i[consoleURLKey] = u
+		}
+
+		if u := timelineURL(i, defaultRegion); u != "" {
+			i[timelineURLKey] = u
+		}
+	}
+}
+
+// consoleURL ... link to the resource in its service console, or an empty
+// string for unsupported resource types
+func consoleURL(item map[string]interface{}, defaultRegion string) string {
+	format, ok := consoleLinks[stringValue(item, "ResourceType")]
+	if !ok {
+		return ""
+	}
+
+	region := itemRegion(item, defaultRegion)
+	id := stringValue(item, "ResourceId")
+	name := firstNonEmpty(stringValue(item, "ResourceName"), id)
+	arn := stringValue(item, "Arn")
+
+	if (strings.Contains(format, "{id}") && id == "") || (strings.Contains(format, "{arn}") && arn == "") {
+		return ""
+	}
+
+	return strings.NewReplacer(
+		"{host}", consoleHostFor(region),
+		"{region}", url.QueryEscape(region),
+		"{id}", fragmentEscape(id),
+		"{name}", fragmentEscape(name),
+		"{arn}", fragmentEscape(arn),
+	).Replace(format)
+}
+
+// fragmentEscape ... escapes a value for the console's client side routes,
+// leaving the colons and slashes of ARNs intact
+func fragmentEscape(s string) string {
+	return (&url.URL{Fragment: s}).EscapedFragment()
+}
+
+// timelineURL ... link to the AWS Config resource timeline, available for every recorded type
+func timelineURL(item map[string]interface{}, defaultRegion string) string {
+	typ := stringValue(item, "ResourceType")
+	id := stringValue(item, "ResourceId")
+
+	if typ == "" || id == "" {
+		return ""
+	}
+
+	region := itemRegion(item, defaultRegion)
+
+	return "https://" + consoleHostFor(region) + "/config/home?region=" + url.QueryEscape(region) +
+		"#/timeline/" + fragmentEscape(typ) + "/" + url.PathEscape(id) + "/configuration"
+}
+
+// itemRegion ... the item's region, using the default region for global resources
+func itemRegion(item map[string]interface{}, defaultRegion string) string {
+	region := stringValue(item, "AwsRegion")
+	if region == "" || region == globalRegion {
+		return defaultRegion
+	}
+
+	return region
+}
+
+func consoleHostFor(region string) string {
+	if strings.HasPrefix(region, "us-gov-") {
+		return govConsoleHost
+	}
+
+	return consoleHost
+}
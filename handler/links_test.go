@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestConsoleURL(t *testing.T) {
+	tt := map[string]struct {
+		item     map[string]interface{}
+		console  string
+		timeline string
+	}{
+		"security group": {
+			item: map[string]interface{}{
+				"ResourceType": "AWS::EC2::SecurityGroup",
+				"ResourceId":   "sg-0123456789abcdef0",
+				"AwsRegion":    "us-west-2",
+			},
+			console: "https://console.aws.amazon.com/ec2/home?region=us-west-2#SecurityGroup:groupId=sg-0123456789abcdef0",
+			timeline: "https://console.aws.amazon.com/config/home?region=us-west-2" +
+				"#/timeline/AWS::EC2::SecurityGroup/sg-0123456789abcdef0/configuration",
+		},
+		"global iam role": {
+			item: map[string]interface{}{
+				"ResourceType": "AWS::IAM::Role",
+				"ResourceId":   "AROAEXAMPLE",
+				"ResourceName": "deploy",
+				"AwsRegion":    "global",
+			},
+			console: "https://console.aws.amazon.com/iam/home#/roles/deploy",
+			timeline: "https://console.aws.amazon.com/config/home?region=us-east-1" +
+				"#/timeline/AWS::IAM::Role/AROAEXAMPLE/configuration",
+		},
+		"iam policy arn": {
+			item: map[string]interface{}{
+				"ResourceType": "AWS::IAM::Policy",
+				"ResourceId":   "ANPAEXAMPLE",
+				"Arn":          "arn:aws:iam::123456789012:policy/team/read only",
+				"AwsRegion":    "global",
+			},
+			console: "https://console.aws.amazon.com/iam/home#/policies/arn:aws:iam::123456789012:policy/team/read%20only",
+			timeline: "https://console.aws.amazon.com/config/home?region=us-east-1" +
+				"#/timeline/AWS::IAM::Policy/ANPAEXAMPLE/configuration",
+		},
+		"govcloud bucket": {
+			item: map[string]interface{}{
+				"ResourceType": "AWS::S3::Bucket",
+				"ResourceId":   "bucket-name",
+				"ResourceName": "bucket-name",
+				"AwsRegion":    "us-gov-west-1",
+			},
+			console: "https://console.amazonaws-us-gov.com/s3/buckets/bucket-name?region=us-gov-west-1",
+			timeline: "https://console.amazonaws-us-gov.com/config/home?region=us-gov-west-1" +
+				"#/timeline/AWS::S3::Bucket/bucket-name/configuration",
+		},
+		"timeline fallback": {
+			item: map[string]interface{}{
+				"ResourceType": "AWS::SSM::ManagedInstanceInventory",
+				"ResourceId":   "mi-0123",
+				"AwsRegion":    "us-east-2",
+			},
+			timeline: "https://console.aws.amazon.com/config/home?region=us-east-2" +
+				"#/timeline/AWS::SSM::ManagedInstanceInventory/mi-0123/configuration",
+		},
+		"missing arn": {
+			item: map[string]interface{}{
+				"ResourceType": "AWS::SNS::Topic",
+				"ResourceId":   "arn:aws:sns:us-east-1:123456789012:topic",
+				"AwsRegion":    "us-east-1",
+			},
+			timeline: "https://console.aws.amazon.com/config/home?region=us-east-1" +
+				"#/timeline/AWS::SNS::Topic/arn:aws:sns:us-east-1:123456789012:topic/configuration",
+		},
+		"no resource id": {
+			item: map[string]interface{}{"ResourceType": "AWS::EC2::Instance"},
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			if u := consoleURL(tc.item, "us-east-1"); u != tc.console {
+				t.Errorf("consoleURL() failed. Expected: %s\nGot: %s", tc.console, u)
+			}
+
+			if u := timelineURL(tc.item, "us-east-1"); u != tc.timeline {
+				t.Errorf("timelineURL() failed. Expected: %s\nGot: %s", tc.timeline, u)
+			}
+		})
+	}
+}
+
+func TestAddConsoleLinks(t *testing.T) {
+	items := []map[string]interface{}{changedTestItem(t,
+		`{"ResourceType": "AWS::Lambda::Function", "ResourceId": "fn", "ResourceName": "old", "AwsRegion": "us-east-1"}`,
+		`{"ResourceType": "AWS::Lambda::Function", "ResourceId": "fn", "ResourceName": "fn", "AwsRegion": "us-east-1"}`)}
+
+	addConsoleLinks(items, "us-east-1")
+
+	b, err := json.Marshal(items)
+	chkErr(t, err)
+
+	for _, k := range []string{consoleURLKey, timelineURLKey} {
+		if !strings.Contains(string(b), `"`+k+`":"https://`) {
+			t.Errorf("addConsoleLinks() failed. Expected %s in JSON: %s", k, b)
+		}
+	}
+
+	h, err := parseItemsToHTML(items)
+	chkErr(t, err)
+
+	for _, s := range []string{
+		`<a href="https://console.aws.amazon.com/lambda/home?region=us-east-1#/functions/fn">fn</a>`,
+		`(<a href="https://console.aws.amazon.com/config/home?region=us-east-1#/timeline/AWS::Lambda::Function/fn/configuration">timeline</a>)`,
+	} {
+		if !strings.Contains(h, s) {
+			t.Errorf("parseItemsToHTML() failed. Expected %s in:\n%s", s, h)
+		}
+	}
+}
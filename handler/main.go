@@ -2,17 +2,20 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
+	"os"
 	"reflect"
 	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/cloudtrail"
 	"github.com/aws/aws-sdk-go/service/configservice"
 	"github.com/aws/aws-sdk-go/service/configservice/configserviceiface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
-	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
 const (
@@ -22,13 +25,56 @@ const (
 
 // config ... struct for holding environment variables
 type config struct {
-	DefaultRegion  string   `env:"AWS_DEFAULT_REGION" envDefault:"us-east-1"`
-	Sender         string   `env:"sender,required"`
-	Recipients     []string `env:"recipients,required" envSeparator:","`
-	CharSet        string   `env:"char_set" envDefault:"UTF-8"`
-	S3Bucket       string   `env:"s3_bucket,required"`
-	ParameterStore string   `env:"ssm_parameter_store,required"`
-	KmsKeyArn      string   `env:"kms_key_arn,required"`
+	DefaultRegion            string        `env:"AWS_DEFAULT_REGION" envDefault:"us-east-1"`
+	Sender                   string        `env:"sender"`
+	Recipients               []string      `env:"recipients" envSeparator:","`
+	CharSet                  string        `env:"char_set" envDefault:"UTF-8"`
+	S3Bucket                 string        `env:"s3_bucket,required"`
+	ParameterStore           string        `env:"ssm_parameter_store,required"`
+	KmsKeyArn                string        `env:"kms_key_arn,required"`
+	Notifiers                []string      `env:"notifiers" envSeparator:"," envDefault:"ses"`
+	SNSTopicArn              string        `env:"sns_topic_arn"`
+	SlackWebhookURL          string        `env:"slack_webhook_url"`
+	TeamsWebhookURL          string        `env:"teams_webhook_url"`
+	WebhookURL               string        `env:"webhook_url"`
+	SecurityHubMode          string        `env:"securityhub_mode" envDefault:"changes"`
+	SecurityHubProductArn    string        `env:"securityhub_product_arn"`
+	EventBusName             string        `env:"event_bus_name" envDefault:"default"`
+	EventSource              string        `env:"event_source" envDefault:"grace.config-differ"`
+	TemplateBucket           string        `env:"template_bucket"`
+	TemplateKey              string        `env:"template_key"`
+	ArchiveBucket            string        `env:"archive_bucket"`
+	ArchivePrefix            string        `env:"archive_prefix" envDefault:"config-differ"`
+	ArchiveURL               string        `env:"archive_url"`
+	MaxEmailSize             int           `env:"max_email_size" envDefault:"10485760"`
+	PresignExpiry            time.Duration `env:"presign_expiry" envDefault:"1h"`
+	MaxDiffLines             int           `env:"max_diff_lines" envDefault:"200"`
+	ClusterThreshold         int           `env:"cluster_threshold" envDefault:"3"`
+	CloudTrailAttribution    bool          `env:"cloudtrail_attribution" envDefault:"true"`
+	CloudTrailMaxLookups     int           `env:"cloudtrail_max_lookups" envDefault:"200"`
+	RiskRulesBucket          string        `env:"risk_rules_bucket"`
+	RiskRulesKey             string        `env:"risk_rules_key"`
+	MinSeverity              string        `env:"min_severity" envDefault:"info"`
+	RoutingRulesBucket       string        `env:"routing_rules_bucket"`
+	RoutingRulesKey          string        `env:"routing_rules_key"`
+	OwnerTags                []string      `env:"owner_tags" envSeparator:"," envDefault:"Owner,Team,Contact"`
+	DefaultOwner             string        `env:"default_owner"`
+	CCOwners                 bool          `env:"cc_owners"`
+	OwnerEmailDomains        []string      `env:"owner_email_domains" envSeparator:","`
+	WorkspaceTags            []string      `env:"workspace_tags" envSeparator:"," envDefault:"Workspace"`
+	TerraformStates          []string      `env:"terraform_states" envSeparator:","`
+	ApprovedChangesBucket    string        `env:"approved_changes_bucket"`
+	ApprovedChangesKey       string        `env:"approved_changes_key"`
+	ChangeAuthorityURL       string        `env:"change_authority_url"`
+	RedactPatterns           []string      `env:"redact_patterns" envSeparator:"," envDefault:"*password*,*secret*,*token*,*credential*,*privatekey*,Environment.Variables.*,UserData"`
+	RedactionSalt            string        `env:"redaction_salt"`
+	AllowlistBucket          string        `env:"allowlist_bucket"`
+	AllowlistKey             string        `env:"allowlist_key"`
+	SuppressionsBucket       string        `env:"suppressions_bucket"`
+	SuppressionsKey          string        `env:"suppressions_key"`
+	SuppressionMode          string        `env:"suppression_mode" envDefault:"drop"`
+	MaintenanceWindowsBucket string        `env:"maintenance_windows_bucket"`
+	MaintenanceWindowsKey    string        `env:"maintenance_windows_key"`
 }
 
 // CfgSvc ... provides interface to AWS Config Service
@@ -119,9 +165,39 @@ func diffItems(
 		return nil, nil, err
 	}
 
+	// both sides are hashed with the same salt so redacted changes still show
+	r := newRedactor(cfg)
+	r.redactItems(snapshotMap)
+	r.redactItems(itemsMap)
+
+	diffs := diffAgainstSnapshot(itemsMap, snapshotMap)
+
+	store, err := loadSuppressions(cfg, svc)
+	if err != nil {
+		log.Printf("error loading suppressions, not suppressing: %v\n", err)
+	}
+
+	if store != nil {
+		rules, err := loadRiskRules(cfg, svc)
+		if err != nil {
+			log.Printf("error loading risk rules, using default: %v\n", err)
+		}
+
+		diffs = applySuppressions(diffs, store, cfg.SuppressionMode, rules, time.Now())
+	}
+
+	resolveOwners(diffs, append(itemsMap, snapshotMap...), cfg)
+	resolveDeployments(diffs, append(itemsMap, snapshotMap...), cfg)
+
+	return diffs, ssObject, nil
+}
+
+// diffAgainstSnapshot ... the items that changed since the snapshot, with
+// their "diffs"
+func diffAgainstSnapshot(items, snapshotMap []map[string]interface{}) []map[string]interface{} {
 	var diffs []map[string]interface{}
 
-	for _, v := range itemsMap {
+	for _, v := range items {
 		snapshot := getSnapshotOfItem(v, snapshotMap)
 		if snapshot != nil {
 			v["diffs"] = makeDiffs(removeNulls(snapshot), removeNulls(v))
@@ -131,33 +207,86 @@ func diffItems(
 		}
 	}
 
-	return diffs, ssObject, nil
+	return diffs
 }
 
+// makeDiffs ... the previous values of the keys that differ between the
+// items, nesting the changed properties of Configuration and
+// SupplementaryConfiguration under "diffs"
 func makeDiffs(old, newer map[string]interface{}) map[string]interface{} {
 	diffs := make(map[string]interface{})
 
 	for key, value := range newer {
-		if !reflect.DeepEqual(old[key], value) {
-			if key == "Configuration" || key == "SupplementaryConfiguration" {
-				jold, _ := json.Marshal(old[key])
-				jnew, _ := json.Marshal(value)
-
-				if !matchJSON(string(jold), string(jnew)) {
-					diffs[key] = make(map[string]interface{})
-					diffs[key].(map[string]interface{})["diffs"] = makeDiffs(
-						old[key].(map[string]interface{}),
-						removeNulls(value.(map[string]interface{})))
-				}
-			} else {
-				diffs[key] = old[key]
+		if reflect.DeepEqual(old[key], value) {
+			continue
+		}
+
+		oldMap, okOld := old[key].(map[string]interface{})
+		newMap, okNew := value.(map[string]interface{})
+
+		if isConfigurationKey(key) && okOld && okNew {
+			jold, _ := json.Marshal(oldMap)
+			jnew, _ := json.Marshal(newMap)
+
+			if !matchJSON(string(jold), string(jnew)) {
+				diffs[key] = map[string]interface{}{"diffs": propertyDiffs(oldMap, removeNulls(newMap))}
 			}
+		} else {
+			diffs[key] = old[key]
+		}
+	}
+
+	// every property of a configuration that is no longer recorded was removed
+	for key, value := range old {
+		if oldMap, ok := value.(map[string]interface{}); ok && isConfigurationKey(key) && newer[key] == nil && !isEmptyValue(value) {
+			diffs[key] = map[string]interface{}{"diffs": propertyDiffs(oldMap, map[string]interface{}{})}
+		}
+	}
+
+	return diffs
+}
+
+// propertyDiffs ... the previous values of the configuration properties that
+// differ, including properties missing from newer, such as those removeNulls
+// dropped, which changed to null
+func propertyDiffs(old, newer map[string]interface{}) map[string]interface{} {
+	diffs := make(map[string]interface{})
+
+	for key, value := range newer {
+		if !reflect.DeepEqual(old[key], value) {
+			diffs[key] = old[key]
+		}
+	}
+
+	for key, value := range old {
+		if _, ok := newer[key]; !ok && !isEmptyValue(value) {
+			diffs[key] = value
 		}
 	}
 
 	return diffs
 }
 
+// isConfigurationKey ... whether the item key holds properties diffed individually
+func isConfigurationKey(key string) bool {
+	return key == "Configuration" || key == "SupplementaryConfiguration"
+}
+
+// isEmptyValue ... whether a value is null or an empty object or array, which
+// are the same as a missing property
+func isEmptyValue(v interface{}) bool {
+	switch w := v.(type) {
+	case nil:
+		return true
+	case map[string]interface{}:
+		return len(w) == 0
+	case []interface{}:
+		return len(w) == 0
+	}
+
+	return false
+}
+
 // diffsExist ... Returns false if there we no configuration changes
 func diffsExist(i interface{}) (ret bool) {
 	if i != nil {
@@ -206,6 +335,44 @@ func alreadyChecked(t time.Time, cfg *config, sess client.ConfigProvider) bool {
 	return false
 }
 
+// configureNotifiers ... the routes for each change and unexpected resource
+// when routing rules are configured or owners are emailed, otherwise the
+// notifiers receiving every change. Owners receive only their changes
+func configureNotifiers(
+	items []map[string]interface{},
+	unexpected []unexpectedResource,
+	cfg *config,
+	sess client.ConfigProvider,
+	svc s3iface.S3API) ([]Notifier, []route, error) {
+	set, err := loadRouteRules(cfg, svc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owners, err := ownerRoutes(items, cfg, sess)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if set != nil {
+		routes, err := newRoutes(items, unexpected, set, cfg, sess)
+		return nil, append(routes, owners...), err
+	}
+
+	notifiers, err := newNotifiers(cfg, sess)
+	if err != nil || len(owners) == 0 {
+		return notifiers, nil, err
+	}
+
+	// the configured notifiers still receive every change
+	routes := make([]route, 0, len(notifiers)+len(owners))
+	for _, n := range notifiers {
+		routes = append(routes, route{Notifier: n, Items: items, Unexpected: unexpected})
+	}
+
+	return nil, append(routes, owners...), nil
+}
+
 func configItemChangeReport() {
 	cfg, sess, err := getSess()
 	if err != nil {
@@ -227,34 +394,197 @@ func configItemChangeReport() {
 		return
 	}
 
+	minSeverity, err := parseSeverity(cfg.MinSeverity)
+	if err != nil {
+		log.Fatalf("error parsing min_severity: %v\n", err)
+		return
+	}
+
+	s3Svc := s3.New(sess)
+
 	items, err := c.GetItems(lastExecution)
 	if err != nil {
 		return
 	}
 
+	var (
+		itemsMap []map[string]interface{}
+		ssObject *s3.Object
+	)
+
 	if len(items) > 0 {
-		itemsMap, ssObject, err := diffItems(items, lastExecution, s3.New(sess), &cfg)
+		itemsMap, ssObject, err = diffItems(items, lastExecution, s3Svc, &cfg)
 		if err != nil {
 			log.Fatalf("error getting diff of items: %v", err)
 			return
 		}
 
-		if diffsExist(itemsMap) {
-			_, err = sendEmail(itemsMap, lastExecution, ssObject, ses.New(sess), &cfg)
-			if err != nil {
-				log.Fatalf("error sending email: %v\n", err)
-				return
-			}
-		} else {
-			log.Printf("no configuration changes since last snapshot")
-			return
-		}
+		addConsoleLinks(itemsMap, cfg.DefaultRegion)
 	} else {
 		log.Printf("no configuration changes during time frame (%v +/- %v min)\n", lastExecution, window)
+	}
+
+	// resources outside the allowlist are reported whether they changed or not
+	unexpected, err := unexpectedResources(&cfg, s3Svc, &c, sts.New(sess), itemsMap, time.Now())
+	if err != nil {
+		log.Printf("error checking the allowlist, not checking resources: %v\n", err)
+	}
+
+	if len(itemsMap) > 0 {
+		itemsMap = enrichChanges(itemsMap, &cfg, sess, s3Svc, lastExecution)
+	}
+
+	if !diffsExist(itemsMap) && len(unexpected) == 0 {
+		log.Printf("no configuration changes since last snapshot")
+		return
+	}
+
+	r := &report{
+		Items:            itemsMap,
+		Time:             lastExecution,
+		Snapshot:         ssObject,
+		MaxDiffLines:     cfg.MaxDiffLines,
+		ClusterThreshold: cfg.ClusterThreshold,
+		Unexpected:       unexpected,
+	}
+
+	if err := sendReport(r, &cfg, sess, s3Svc, true, minSeverity); err != nil {
+		log.Fatalf("%v\n", err)
 		return
 	}
 }
 
+// enrichChanges ... correlates the changes with Terraform state, approved
+// changes and CloudTrail, and holds back those inside a maintenance window
+func enrichChanges(
+	itemsMap []map[string]interface{},
+	cfg *config,
+	sess client.ConfigProvider,
+	s3Svc s3iface.S3API,
+	lastExecution time.Time) []map[string]interface{} {
+	states, err := loadTerraformStates(cfg, s3Svc)
+	if err != nil {
+		log.Printf("error loading terraform state, not correlating changes: %v\n", err)
+	}
+
+	correlateTerraform(itemsMap, states, newRedactor(cfg))
+
+	authorities, err := newChangeAuthorities(cfg, s3Svc)
+	if err != nil {
+		log.Printf("error configuring change authorities, not authorizing changes: %v\n", err)
+	}
+
+	if err := authorizeChanges(itemsMap, authorities, lastExecution); err != nil {
+		log.Printf("error reading approved changes, not authorizing changes: %v\n", err)
+	}
+
+	if cfg.CloudTrailAttribution {
+		ct := CloudTrailSvc{Client: cloudtrail.New(sess), Interval: lookupInterval, MaxLookups: cfg.CloudTrailMaxLookups}
+		if err := ct.AttributeChanges(itemsMap); err != nil {
+			log.Printf("error attributing changes with CloudTrail: %v\n", err)
+		}
+	}
+
+	windows, err := loadMaintenanceWindows(cfg, s3Svc)
+	if err != nil {
+		log.Printf("error loading maintenance windows, ignoring them: %v\n", err)
+	}
+
+	var held []heldSet
+
+	itemsMap, held = applyMaintenanceWindows(itemsMap, windows, lastExecution)
+	if err := holdChanges(s3Svc, cfg, held, lastExecution); err != nil {
+		log.Printf("error holding changes, reporting them now: %v\n", err)
+
+		for _, h := range held {
+			itemsMap = append(itemsMap, h.Items...)
+		}
+	}
+
+	return itemsMap
+}
+
+// sendReport ... scores the changes in the report, archives it when archive is
+// set and notifies unless every change is below minSeverity
+func sendReport(
+	r *report,
+	cfg *config,
+	sess client.ConfigProvider,
+	svc s3iface.S3API,
+	archive bool,
+	minSeverity severity) error {
+	rules, err := loadRiskRules(cfg, svc)
+	if err != nil {
+		log.Printf("error loading risk rules, using default: %v\n", err)
+	}
+
+	scoreItems(r.Items, rules)
+
+	if r.Templates, err = loadTemplateOverride(cfg, svc); err != nil {
+		log.Printf("error loading report template, using default: %v\n", err)
+	}
+
+	notifiers, routes, err := configureNotifiers(r.Items, r.Unexpected, cfg, sess, svc)
+	if err != nil {
+		return fmt.Errorf("error configuring notifiers: %v", err)
+	}
+
+	if archive {
+		if err := archiveReport(r, svc, cfg); err != nil {
+			log.Printf("error archiving report: %v\n", err)
+		}
+	}
+
+	highest := maxSeverity(r.Items)
+	if len(r.Unexpected) > 0 && highest < severityHigh {
+		highest = severityHigh
+	}
+
+	if highest < minSeverity {
+		log.Printf("highest severity %s is below min_severity %s, not notifying\n", highest, minSeverity)
+		return nil
+	}
+
+	if routes != nil {
+		err = notifyRoutes(routes, r)
+	} else {
+		err = notify(notifiers, r)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error sending notifications: %v", err)
+	}
+
+	return nil
+}
+
+// handleEvent ... runs a digest, the component inventory or the report of
+// held changes when invoked by an EventBridge schedule, otherwise the change
+// report for the latest Config history delivery
+func handleEvent(e digestEvent) error {
+	if e.Inventory {
+		return inventoryReport()
+	}
+
+	if e.HeldChanges {
+		return heldChangesReport()
+	}
+
+	if e.Digest != "" {
+		return digestReport(e)
+	}
+
+	configItemChangeReport()
+
+	return nil
+}
+
 func main() {
-	lambda.Start(configItemChangeReport)
+	// the suppress subcommand manages the suppression store and the rules
+	// subcommand tests risk rules, from a shell
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
+	lambda.Start(handleEvent)
 }
@@ -1,18 +1,23 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
 	"runtime"
+	"sort"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	// "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/configservice"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
@@ -96,22 +101,124 @@ func parseTestMap(t *testing.T, f string) []map[string]interface{} {
 	return myMap
 }
 
+// changedTestItem ... the current item diffed against the previous one by
+// diffAgainstSnapshot, as the handler diffs items against the snapshot. Both
+// are JSON configuration items and need the same ResourceType and ResourceId
+func changedTestItem(t *testing.T, previous, current string) map[string]interface{} {
+	var p, c map[string]interface{}
+
+	chkErr(t, json.Unmarshal([]byte(previous), &p))
+	chkErr(t, json.Unmarshal([]byte(current), &c))
+
+	diffAgainstSnapshot([]map[string]interface{}{c}, []map[string]interface{}{p})
+
+	return c
+}
+
+// configTestItem ... a resource whose Configuration changed from previous to
+// current, both JSON objects, diffed by changedTestItem
+func configTestItem(t *testing.T, resourceType, previous, current string) map[string]interface{} {
+	item := `{"ResourceType": %q, "ResourceId": "resource", "ResourceName": "resource", "Configuration": %s}`
+
+	return changedTestItem(t, fmt.Sprintf(item, resourceType, previous), fmt.Sprintf(item, resourceType, current))
+}
+
 // AWS Service Mocks //
 type mockS3 struct {
 	s3iface.S3API
 	Resp    string
 	Objects s3.ListObjectsOutput
 	Object  s3.GetObjectOutput
+	Puts    map[string][]byte
+	// Modified ... the LastModified of the objects listed from Puts
+	Modified time.Time
 }
 
 func (m *mockS3) ListObjects(in *s3.ListObjectsInput) (*s3.ListObjectsOutput, error) {
-	return &m.Objects, nil
+	if len(m.Objects.Contents) > 0 || m.Puts == nil {
+		return &m.Objects, nil
+	}
+
+	// list what has been put when no listing is given
+	var keys []string
+
+	prefixes := make(map[string]interface{})
+
+	for k := range m.Puts {
+		if !strings.HasPrefix(k, aws.StringValue(in.Prefix)) {
+			continue
+		}
+
+		rest := strings.TrimPrefix(k, aws.StringValue(in.Prefix))
+		if d := aws.StringValue(in.Delimiter); d != "" && strings.Contains(rest, d) {
+			prefixes[aws.StringValue(in.Prefix)+rest[:strings.Index(rest, d)+len(d)]] = true
+			continue
+		}
+
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	out := &s3.ListObjectsOutput{}
+	for _, k := range keys {
+		o := &s3.Object{Key: aws.String(k)}
+		if !m.Modified.IsZero() {
+			o.LastModified = aws.Time(m.Modified)
+		}
+
+		out.Contents = append(out.Contents, o)
+	}
+
+	for _, p := range sortedKeys(prefixes) {
+		out.CommonPrefixes = append(out.CommonPrefixes, &s3.CommonPrefix{Prefix: aws.String(p)})
+	}
+
+	return out, nil
 }
 
 func (m *mockS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if b, ok := m.Puts[aws.StringValue(in.Key)]; ok {
+		return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b))}, nil
+	}
+
+	if m.Object.Body == nil {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+
 	return &m.Object, nil
 }
 
+func (m *mockS3) GetObjectRequest(in *s3.GetObjectInput) (*request.Request, *s3.GetObjectOutput) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	}))
+
+	return s3.New(sess).GetObjectRequest(in)
+}
+
+func (m *mockS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(m.Puts, aws.StringValue(in.Key))
+
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *mockS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if m.Puts == nil {
+		m.Puts = make(map[string][]byte)
+	}
+
+	b, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	m.Puts[aws.StringValue(in.Key)] = b
+
+	return &s3.PutObjectOutput{}, nil
+}
+
 // test functions //
 func TestParseItemsToMap(t *testing.T) {
 	items := parseTestItems(t, "testdata/test1_items.json")
@@ -266,6 +373,42 @@ func TestDiffItems(t *testing.T) {
 	}
 }
 
+func TestDiffAgainstSnapshotRemoved(t *testing.T) {
+	item := changedTestItem(t,
+		`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs", "ResourceCreationTime": "2020-01-02T03:04:05Z",
+			"Configuration": {"name": "logs", "versioning": "Enabled", "tags": []},
+			"SupplementaryConfiguration": {"ServerSideEncryptionConfiguration": {"rules": [{"sseAlgorithm": "AES256"}]},
+				"LoggingConfiguration": {}}}`,
+		`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs",
+			"Configuration": {"name": "logs", "versioning": null},
+			"SupplementaryConfiguration": {}}`)
+
+	var paths []string
+
+	for _, c := range itemChanges(item) {
+		if c.Current != nil || c.Previous == nil {
+			t.Errorf("itemChanges() failed. Expected %s removed, got: %v -> %v", c.Path, c.Previous, c.Current)
+		}
+
+		paths = append(paths, c.Path)
+	}
+
+	// null, empty and unrecorded metadata are not changes
+	expected := "Configuration.versioning,SupplementaryConfiguration.ServerSideEncryptionConfiguration"
+	if strings.Join(paths, ",") != expected {
+		t.Errorf("diffAgainstSnapshot() failed. Expected %s, got: %v", expected, paths)
+	}
+
+	item = changedTestItem(t,
+		`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs",
+			"SupplementaryConfiguration": {"PublicAccessBlockConfiguration": {"blockPublicAcls": true}}}`,
+		`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs"}`)
+
+	if c := itemChanges(item); len(c) != 1 || c[0].Path != "SupplementaryConfiguration.PublicAccessBlockConfiguration" {
+		t.Errorf("diffAgainstSnapshot() failed. Expected the removed supplementary configuration, got: %v", c)
+	}
+}
+
 func TestDiffItemsPolicyOrder(t *testing.T) {
 	items := parseTestItems(t, "testdata/test4_items.json")
 	lastExecution := time.Date(2019, 10, 17, 22, 5, 0, 0, time.UTC)
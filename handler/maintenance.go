@@ -0,0 +1,501 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// What happens to changes captured inside a maintenance window
+const (
+	maintenanceHold = "hold"
+	maintenanceTag  = "tag"
+)
+
+const (
+	maintenanceKey     = "MaintenanceWindow"
+	heldPrefix         = "held"
+	maxWindowDuration  = 7 * 24 * time.Hour
+	captureTimeKey     = "ConfigurationItemCaptureTime"
+	maintenanceNameExp = `^[A-Za-z0-9_.-]+$`
+	heldLockSuffix     = "-held-changes"
+	heldLockTTL        = 15 * time.Minute // the function timeout, after which a claim was abandoned
+)
+
+var maintenanceName = regexp.MustCompile(maintenanceNameExp)
+
+// maintenanceWindow ... a recurring cron schedule with a duration, or an
+// explicit range, scoped to accounts and tag values (globs). Changes captured
+// inside the window are held for a report after it closes, or tagged and
+// demoted one severity level
+type maintenanceWindow struct {
+	Name     string            `json:"name"`
+	Schedule string            `json:"schedule"`
+	Duration string            `json:"duration"`
+	Start    *time.Time        `json:"start"`
+	End      *time.Time        `json:"end"`
+	Accounts []string          `json:"accounts"`
+	Tags     map[string]string `json:"tags"`
+	Action   string            `json:"action"`
+
+	schedule *cronSchedule
+	duration time.Duration
+}
+
+// maintenanceWindowSet ... the JSON document holding the maintenance windows
+type maintenanceWindowSet struct {
+	Windows []maintenanceWindow `json:"windows"`
+}
+
+// maintenanceRun ... a single occurrence of a maintenance window
+type maintenanceRun struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// heldSet ... changes held during one occurrence of a window
+type heldSet struct {
+	maintenanceRun
+	Items []map[string]interface{}
+}
+
+// compile ... validates the window and parses its schedule
+func (w *maintenanceWindow) compile() (err error) {
+	if !maintenanceName.MatchString(w.Name) {
+		return fmt.Errorf("maintenance window %q: name must match %s", w.Name, maintenanceNameExp)
+	}
+
+	switch w.Action {
+	case "":
+		w.Action = maintenanceTag
+	case maintenanceHold, maintenanceTag:
+	default:
+		return fmt.Errorf("maintenance window %s: unknown action %q", w.Name, w.Action)
+	}
+
+	var patterns []string
+
+	patterns = append(patterns, w.Accounts...)
+
+	for _, v := range w.Tags {
+		patterns = append(patterns, v)
+	}
+
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("maintenance window %s: invalid pattern %q: %v", w.Name, p, err)
+		}
+	}
+
+	switch {
+	case w.Schedule != "":
+		if w.schedule, err = parseCron(w.Schedule); err != nil {
+			return fmt.Errorf("maintenance window %s: %v", w.Name, err)
+		}
+
+		if w.duration, err = time.ParseDuration(w.Duration); err != nil || w.duration <= 0 || w.duration > maxWindowDuration {
+			return fmt.Errorf("maintenance window %s: duration must be between 1m and %v", w.Name, maxWindowDuration)
+		}
+	case w.Start != nil && w.End != nil:
+		if !w.End.After(*w.Start) {
+			return fmt.Errorf("maintenance window %s: end must be after start", w.Name)
+		}
+	default:
+		return fmt.Errorf("maintenance window %s: requires a schedule and duration, or a start and end", w.Name)
+	}
+
+	return nil
+}
+
+// occurrence ... the occurrence of the window containing t, if any
+func (w *maintenanceWindow) occurrence(t time.Time) (maintenanceRun, bool) {
+	t = t.UTC()
+
+	if w.schedule == nil {
+		if !t.Before(*w.Start) && t.Before(*w.End) {
+			return maintenanceRun{Name: w.Name, Start: w.Start.UTC(), End: w.End.UTC()}, true
+		}
+
+		return maintenanceRun{}, false
+	}
+
+	// the most recent start within one duration of t
+	for m := t.Truncate(time.Minute); t.Sub(m) < w.duration; m = m.Add(-time.Minute) {
+		if w.schedule.matches(m) {
+			return maintenanceRun{Name: w.Name, Start: m, End: m.Add(w.duration)}, true
+		}
+	}
+
+	return maintenanceRun{}, false
+}
+
+// matches ... whether the item is in the window's scope
+func (w *maintenanceWindow) matches(item map[string]interface{}) bool {
+	if !matchAny(w.Accounts, stringValue(item, "AccountId")) {
+		return false
+	}
+
+	tags, _ := item["Tags"].(map[string]interface{})
+
+	for k, pattern := range w.Tags {
+		v, _ := tags[k].(string)
+		if ok, _ := path.Match(pattern, v); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseMaintenanceWindows ... parses and validates a maintenance window set
+func parseMaintenanceWindows(b []byte) ([]maintenanceWindow, error) {
+	var set maintenanceWindowSet
+
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	for n := range set.Windows {
+		if err := set.Windows[n].compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	return set.Windows, nil
+}
+
+// loadMaintenanceWindows ... reads the maintenance windows from S3, returning
+// none when maintenance windows are not configured
+func loadMaintenanceWindows(cfg *config, svc s3iface.S3API) ([]maintenanceWindow, error) {
+	if cfg.MaintenanceWindowsKey == "" {
+		return nil, nil
+	}
+
+	bucket := cfg.MaintenanceWindowsBucket
+	if bucket == "" {
+		bucket = cfg.S3Bucket
+	}
+
+	s, err := getObject(svc, bucket, cfg.MaintenanceWindowsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	windows, err := parseMaintenanceWindows([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("using maintenance windows s3://%s/%s\n", bucket, cfg.MaintenanceWindowsKey)
+
+	return windows, nil
+}
+
+// captureTime ... when the item was captured, or the fallback when unknown
+func captureTime(item map[string]interface{}, fallback time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339, stringValue(item, captureTimeKey)); err == nil {
+		return t
+	}
+
+	return fallback
+}
+
+// applyMaintenanceWindows ... tags the items captured inside a tagging window
+// and removes the items captured inside a holding window, returning the items
+// to report now and the held items grouped by window occurrence. The first
+// window containing an item applies
+func applyMaintenanceWindows(
+	items []map[string]interface{},
+	windows []maintenanceWindow,
+	fallback time.Time) ([]map[string]interface{}, []heldSet) {
+	if len(windows) == 0 {
+		return items, nil
+	}
+
+	kept := make([]map[string]interface{}, 0, len(items))
+	held := make(map[maintenanceRun]int)
+
+	var sets []heldSet
+
+	for _, i := range items {
+		run, action, ok := windowOf(i, windows, captureTime(i, fallback))
+
+		switch {
+		case !ok:
+			kept = append(kept, i)
+		case action == maintenanceHold:
+			n, ok := held[run]
+			if !ok {
+				n = len(sets)
+				held[run] = n
+				sets = append(sets, heldSet{maintenanceRun: run})
+			}
+
+			sets[n].Items = append(sets[n].Items, i)
+		default:
+			i[maintenanceKey] = run.Name
+			kept = append(kept, i)
+		}
+	}
+
+	return kept, sets
+}
+
+func windowOf(item map[string]interface{}, windows []maintenanceWindow, t time.Time) (maintenanceRun, string, bool) {
+	for n := range windows {
+		w := &windows[n]
+		if !w.matches(item) {
+			continue
+		}
+
+		if run, ok := w.occurrence(t); ok {
+			return run, w.Action, true
+		}
+	}
+
+	return maintenanceRun{}, "", false
+}
+
+// heldKey ... the S3 key of a set of changes held from one execution. Keys sort
+// by window end, so due sets are found without reading them
+func heldKey(cfg *config, run maintenanceRun, execution time.Time) string {
+	return path.Join(cfg.ArchivePrefix, heldPrefix, run.End.UTC().Format(archiveTimeFormat), run.Name,
+		run.Start.UTC().Format(archiveTimeFormat), execution.UTC().Format(archiveTimeFormat)+".json")
+}
+
+// holdChanges ... stores the held changes until their windows close
+func holdChanges(svc s3iface.S3API, cfg *config, sets []heldSet, execution time.Time) error {
+	for _, s := range sets {
+		b, err := json.Marshal(s.Items)
+		if err != nil {
+			return err
+		}
+
+		key := heldKey(cfg, s.maintenanceRun, execution)
+		if err := putObject(svc, cfg.S3Bucket, key, "application/json", cfg.KmsKeyArn, b); err != nil {
+			return err
+		}
+
+		log.Printf("held %d changes until maintenance window %s closes at %v\n", len(s.Items), s.Name, s.End)
+	}
+
+	return nil
+}
+
+// dueHeldChanges ... the keys of the changes held during each window
+// occurrence that has closed by now
+func dueHeldChanges(svc s3iface.S3API, cfg *config, now time.Time) (map[maintenanceRun][]string, error) {
+	prefix := path.Join(cfg.ArchivePrefix, heldPrefix) + "/"
+	due := make(map[maintenanceRun][]string)
+	input := &s3.ListObjectsInput{Bucket: aws.String(cfg.S3Bucket), Prefix: aws.String(prefix)}
+
+	for {
+		results, err := svc.ListObjects(input)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range results.Contents {
+			key := aws.StringValue(o.Key)
+			parts := strings.Split(strings.TrimPrefix(key, prefix), "/")
+
+			if len(parts) != 4 {
+				continue
+			}
+
+			end, err := time.Parse(archiveTimeFormat, parts[0])
+			if err != nil || end.After(now) {
+				continue
+			}
+
+			start, err := time.Parse(archiveTimeFormat, parts[2])
+			if err != nil {
+				continue
+			}
+
+			run := maintenanceRun{Name: parts[1], Start: start, End: end}
+			due[run] = append(due[run], key)
+		}
+
+		if !aws.BoolValue(results.IsTruncated) || len(results.Contents) == 0 {
+			break
+		}
+
+		input.Marker = results.Contents[len(results.Contents)-1].Key
+	}
+
+	return due, nil
+}
+
+// readHeldChanges ... the held changes of one window occurrence, consolidated
+// into the net change of each resource
+func readHeldChanges(svc s3iface.S3API, bucket string, keys []string) ([]map[string]interface{}, error) {
+	// execution times in the key names sort oldest first
+	sort.Strings(keys)
+
+	sets := make([][]map[string]interface{}, 0, len(keys))
+
+	for _, key := range keys {
+		s, err := getObject(svc, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+
+		var items []map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &items); err != nil {
+			return nil, fmt.Errorf("error parsing held changes %s: %v", key, err)
+		}
+
+		sets = append(sets, items)
+	}
+
+	return consolidateChanges(sets), nil
+}
+
+// heldChangesReport ... reports the changes held during the maintenance
+// windows that have closed, when invoked by the EventBridge schedule. Only one
+// invocation reports at a time, so held changes are not sent twice
+func heldChangesReport() error {
+	cfg, sess, err := getSess()
+	if err != nil {
+		return err
+	}
+
+	minSeverity, err := parseSeverity(cfg.MinSeverity)
+	if err != nil {
+		return fmt.Errorf("error parsing min_severity: %v", err)
+	}
+
+	ssmSvc := ssm.New(sess)
+	now := time.Now()
+
+	claimed, err := claimHeldChanges(ssmSvc, &cfg, now)
+	if err != nil {
+		return fmt.Errorf("error claiming held changes: %v", err)
+	}
+
+	if !claimed {
+		log.Printf("held changes are being reported by another invocation\n")
+		return nil
+	}
+
+	defer releaseHeldChanges(ssmSvc, &cfg)
+
+	return reportHeldChanges(&cfg, sess, s3.New(sess), minSeverity, now)
+}
+
+// claimHeldChanges ... creates the parameter claiming the held changes for
+// this invocation, false when another invocation holds an unexpired claim.
+// Creating a parameter fails if it exists, so only one invocation succeeds
+func claimHeldChanges(svc ssmiface.SSMAPI, cfg *config, now time.Time) (bool, error) {
+	input := &ssm.PutParameterInput{
+		Description: aws.String("Config Diff held changes claim"),
+		Name:        aws.String(cfg.ParameterStore + heldLockSuffix),
+		Type:        aws.String(ssm.ParameterTypeString),
+		Value:       aws.String(now.UTC().Format(time.RFC3339)),
+	}
+
+	_, err := svc.PutParameter(input)
+	if err == nil {
+		return true, nil
+	}
+
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != ssm.ErrCodeParameterAlreadyExists {
+		return false, err
+	}
+
+	res, err := svc.GetParameter(&ssm.GetParameterInput{Name: input.Name})
+	if err != nil {
+		return false, err
+	}
+
+	if claimed, err := time.Parse(time.RFC3339, aws.StringValue(res.Parameter.Value)); err == nil && now.Sub(claimed) < heldLockTTL {
+		return false, nil
+	}
+
+	// the invocation holding the claim timed out without releasing it. Each
+	// write increments the version, so the claim is taken over only when no
+	// other invocation wrote it since it was read
+	input.Overwrite = aws.Bool(true)
+
+	out, err := svc.PutParameter(input)
+	if err != nil {
+		return false, err
+	}
+
+	return aws.Int64Value(out.Version) == aws.Int64Value(res.Parameter.Version)+1, nil
+}
+
+// releaseHeldChanges ... deletes the claim on the held changes
+func releaseHeldChanges(svc ssmiface.SSMAPI, cfg *config) {
+	if _, err := svc.DeleteParameter(&ssm.DeleteParameterInput{Name: aws.String(cfg.ParameterStore + heldLockSuffix)}); err != nil {
+		log.Printf("error releasing the claim on held changes: %v\n", err)
+	}
+}
+
+// reportHeldChanges ... sends one report for each maintenance window that has
+// closed, then removes its held changes
+func reportHeldChanges(cfg *config, sess client.ConfigProvider, svc s3iface.S3API, minSeverity severity, now time.Time) error {
+	due, err := dueHeldChanges(svc, cfg, now)
+	if err != nil {
+		return err
+	}
+
+	runs := make([]maintenanceRun, 0, len(due))
+	for run := range due {
+		runs = append(runs, run)
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].End.Before(runs[j].End)
+	})
+
+	for n := range runs {
+		run := &runs[n]
+
+		items, err := readHeldChanges(svc, cfg.S3Bucket, due[*run])
+		if err != nil {
+			return err
+		}
+
+		if len(items) > 0 {
+			r := &report{
+				Items:            items,
+				Time:             run.End,
+				MaxDiffLines:     cfg.MaxDiffLines,
+				ClusterThreshold: cfg.ClusterThreshold,
+				Maintenance:      run,
+			}
+
+			if err := sendReport(r, cfg, sess, svc, true, minSeverity); err != nil {
+				return err
+			}
+		}
+
+		for _, key := range due[*run] {
+			if _, err := svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(cfg.S3Bucket), Key: aws.String(key)}); err != nil {
+				return err
+			}
+		}
+
+		log.Printf("reported %d changes held during maintenance window %s\n", len(items), run.Name)
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+type mockSSM struct {
+	ssmiface.SSMAPI
+	Parameters map[string]string
+	Versions   map[string]int64
+	OnGet      func()
+}
+
+func (m *mockSSM) PutParameter(in *ssm.PutParameterInput) (*ssm.PutParameterOutput, error) {
+	name := aws.StringValue(in.Name)
+	if _, ok := m.Parameters[name]; ok && !aws.BoolValue(in.Overwrite) {
+		return nil, awserr.New(ssm.ErrCodeParameterAlreadyExists, "The parameter already exists.", nil)
+	}
+
+	if m.Versions == nil {
+		m.Versions = make(map[string]int64)
+	}
+
+	m.Parameters[name] = aws.StringValue(in.Value)
+	m.Versions[name]++
+
+	return &ssm.PutParameterOutput{Version: aws.Int64(m.Versions[name])}, nil
+}
+
+func (m *mockSSM) GetParameter(in *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	name := aws.StringValue(in.Name)
+	out := &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(m.Parameters[name]), Version: aws.Int64(m.Versions[name])}}
+
+	if m.OnGet != nil {
+		m.OnGet()
+	}
+
+	return out, nil
+}
+
+func (m *mockSSM) DeleteParameter(in *ssm.DeleteParameterInput) (*ssm.DeleteParameterOutput, error) {
+	delete(m.Parameters, aws.StringValue(in.Name))
+	delete(m.Versions, aws.StringValue(in.Name))
+
+	return &ssm.DeleteParameterOutput{}, nil
+}
+
+// helper functions //
+func maintenanceTestWindows(t *testing.T) []maintenanceWindow {
+	windows, err := parseMaintenanceWindows([]byte(`{"windows": [
+		{"name": "patching", "schedule": "0 2 * * SUN", "duration": "4h", "tags": {"Env": "prod*"}, "action": "hold"},
+		{"name": "migration", "start": "2020-03-01T00:00:00Z", "end": "2020-03-15T00:00:00Z", "accounts": ["1111*"]}
+	]}`))
+	chkErr(t, err)
+
+	return windows
+}
+
+// test functions //
+func TestApplyMaintenanceWindows(t *testing.T) {
+	windows := maintenanceTestWindows(t)
+	items := []map[string]interface{}{
+		// held: inside the Sunday window
+		{"ResourceId": "a", "AccountId": "222222222222", "Tags": map[string]interface{}{"Env": "production"},
+			captureTimeKey: "2020-03-08T05:59:00Z"},
+		// tagged: after the Sunday window, inside the migration
+		{"ResourceId": "b", "AccountId": "111111111111", "Tags": map[string]interface{}{"Env": "production"},
+			captureTimeKey: "2020-03-08T06:00:00Z"},
+		// reported: no window applies
+		{"ResourceId": "c", "AccountId": "222222222222", "Tags": map[string]interface{}{"Env": "dev"}},
+	}
+
+	kept, held := applyMaintenanceWindows(items, windows, time.Date(2020, 3, 8, 3, 0, 0, 0, time.UTC))
+
+	if len(kept) != 2 || stringValue(kept[0], maintenanceKey) != "migration" || stringValue(kept[1], maintenanceKey) != "" {
+		t.Errorf("applyMaintenanceWindows() failed. Unexpected kept items: %v", kept)
+	}
+
+	start := time.Date(2020, 3, 8, 2, 0, 0, 0, time.UTC)
+	if len(held) != 1 || held[0].Name != "patching" || !held[0].Start.Equal(start) || !held[0].End.Equal(start.Add(4*time.Hour)) ||
+		len(held[0].Items) != 1 {
+		t.Errorf("applyMaintenanceWindows() failed. Unexpected held items: %+v", held)
+	}
+
+	tagged := configTestItem(t, "AWS::RDS::DBInstance", `{"storageEncrypted": true}`, `{"storageEncrypted": false}`)
+	tagged[maintenanceKey] = "migration"
+
+	scoreItems([]map[string]interface{}{tagged}, defaultRiskRules())
+
+	if s := itemSeverity(tagged); s != severityMedium {
+		t.Errorf("scoreItems() failed. Expected maintenance to demote high to medium, got: %s", s)
+	}
+}
+
+func TestHeldChanges(t *testing.T) {
+	m := &mockS3{Puts: make(map[string][]byte)}
+	cfg := &config{S3Bucket: "bucket", ArchivePrefix: "prefix"}
+	run := maintenanceRun{
+		Name:  "patching",
+		Start: time.Date(2020, 3, 8, 2, 0, 0, 0, time.UTC),
+		End:   time.Date(2020, 3, 8, 6, 0, 0, 0, time.UTC),
+	}
+
+	for n, items := range [][]map[string]interface{}{
+		{changedTestItem(t, `{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "Configuration": {"delay": 0}}`,
+			`{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "Configuration": {"delay": 5}}`)},
+		{changedTestItem(t, `{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "Configuration": {"delay": 5}}`,
+			`{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "Configuration": {"delay": 9}}`)},
+	} {
+		chkErr(t, holdChanges(m, cfg, []heldSet{{maintenanceRun: run, Items: items}}, run.Start.Add(time.Duration(n+1)*time.Hour)))
+	}
+
+	due, err := dueHeldChanges(m, cfg, run.End.Add(-time.Minute))
+	chkErr(t, err)
+
+	if len(due) != 0 {
+		t.Errorf("dueHeldChanges() failed. Expected nothing due before the window closes, got: %v", due)
+	}
+
+	due, err = dueHeldChanges(m, cfg, run.End)
+	chkErr(t, err)
+
+	if len(due[run]) != 2 {
+		t.Fatalf("dueHeldChanges() failed. Expected 2 held sets for %v, got: %v", run, due)
+	}
+
+	items, err := readHeldChanges(m, cfg.S3Bucket, due[run])
+	chkErr(t, err)
+
+	changes := itemChanges(items[0])
+	if len(items) != 1 || len(changes) != 1 || changes[0].Previous != 0.0 || changes[0].Current != 9.0 {
+		t.Errorf("readHeldChanges() failed. Expected the net change, got: %v", changes)
+	}
+
+	text, err := reportToText(&report{Items: items, Time: run.End, Maintenance: &run})
+	chkErr(t, err)
+
+	if !strings.HasPrefix(text, "Configuration Changes During Maintenance Window patching") {
+		t.Errorf("reportToText() failed. Expected maintenance heading in:\n%s", text)
+	}
+}
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	tt := map[string]string{
+		"bad name":         `{"windows": [{"name": "a/b", "start": "2020-03-01T00:00:00Z", "end": "2020-03-02T00:00:00Z"}]}`,
+		"unknown action":   `{"windows": [{"name": "a", "action": "skip", "start": "2020-03-01T00:00:00Z", "end": "2020-03-02T00:00:00Z"}]}`,
+		"no duration":      `{"windows": [{"name": "a", "schedule": "0 2 * * *"}]}`,
+		"too long":         `{"windows": [{"name": "a", "schedule": "0 2 * * *", "duration": "200h"}]}`,
+		"end before start": `{"windows": [{"name": "a", "start": "2020-03-02T00:00:00Z", "end": "2020-03-01T00:00:00Z"}]}`,
+		"no schedule":      `{"windows": [{"name": "a"}]}`,
+		"invalid glob":     `{"windows": [{"name": "a", "accounts": ["["], "schedule": "0 2 * * *", "duration": "1h"}]}`,
+	}
+
+	for name, s := range tt {
+		s := s
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseMaintenanceWindows([]byte(s)); err == nil {
+				t.Errorf("parseMaintenanceWindows() failed. Expected error")
+			}
+		})
+	}
+}
+
+func TestClaimHeldChanges(t *testing.T) {
+	m := &mockSSM{Parameters: make(map[string]string)}
+	cfg := &config{ParameterStore: "last-execution"}
+	now := time.Date(2020, 3, 8, 6, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		now      time.Time
+		release  bool
+		expected bool
+	}{
+		{"first claim", now, false, true},
+		{"claimed by another invocation", now.Add(time.Minute), false, false},
+		{"abandoned claim", now.Add(heldLockTTL), true, true},
+		{"released claim", now.Add(heldLockTTL + time.Minute), false, true},
+	} {
+		claimed, err := claimHeldChanges(m, cfg, tc.now)
+		chkErr(t, err)
+
+		if claimed != tc.expected {
+			t.Errorf("claimHeldChanges() failed. Expected %v for the %s, got: %v", tc.expected, tc.name, claimed)
+		}
+
+		if tc.release {
+			releaseHeldChanges(m, cfg)
+		}
+	}
+
+	if _, ok := m.Parameters["last-execution"+heldLockSuffix]; !ok || len(m.Parameters) != 1 {
+		t.Errorf("claimHeldChanges() failed. Expected the claim parameter, got: %v", m.Parameters)
+	}
+
+	// another invocation takes over the abandoned claim between the read and the write
+	later := now.Add(3 * heldLockTTL)
+	m.OnGet = func() {
+		m.OnGet = nil
+		_, err := m.PutParameter(&ssm.PutParameterInput{Name: aws.String("last-execution" + heldLockSuffix),
+			Value: aws.String(later.Format(time.RFC3339)), Overwrite: aws.Bool(true)})
+		chkErr(t, err)
+	}
+
+	if claimed, err := claimHeldChanges(m, cfg, later); err != nil || claimed {
+		t.Errorf("claimHeldChanges() failed. Expected the claim taken over by another invocation to be kept, got: %v, %v", claimed, err)
+	}
+}
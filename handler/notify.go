@@ -0,0 +1,479 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/eventbridge"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+	"github.com/aws/aws-sdk-go/service/ses"
+	"github.com/aws/aws-sdk-go/service/ses/sesiface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+const (
+	httpTimeout    = 30 // seconds to wait for a webhook to respond
+	maxSNSMessage  = 256 * 1024
+	maxSlackBlocks = 50
+	maxSlackHeader = 150  // characters of a Slack header block
+	maxSlackText   = 3000 // characters of a Slack section block
+	maxTeamsFacts  = 100
+	truncatedMsg   = "... (truncated)"
+)
+
+// Notifier names accepted by the notifiers environment variable
+const (
+	notifierSES         = "ses"
+	notifierSNS         = "sns"
+	notifierSlack       = "slack"
+	notifierTeams       = "teams"
+	notifierWebhook     = "webhook"
+	notifierSecurityHub = "securityhub"
+	notifierEventBridge = "eventbridge"
+)
+
+// report ... a change set and the context needed to render it
+type report struct {
+	Items            []map[string]interface{}
+	Time             time.Time
+	Snapshot         *s3.Object
+	ArchiveURL       string
+	MaxDiffLines     int
+	ClusterThreshold int
+	Digest           *digest
+	Maintenance      *maintenanceRun
+	Inventory        *inventory
+	Unexpected       []unexpectedResource
+	// Templates ... the custom templates loaded for the report, nil for the embedded defaults
+	Templates *template.Template
+	// Route ... the route, from 1, sent this part of the report, 0 for the full report
+	Route int
+}
+
+// snapshotName ... the file name of the snapshot the change set was compared to
+func (r *report) snapshotName() string {
+	if r.Snapshot == nil {
+		return ""
+	}
+
+	return filepath.Base(aws.StringValue(r.Snapshot.Key))
+}
+
+// Notifier ... delivers a rendering of a change set to a sink
+type Notifier interface {
+	Notify(r *report) error
+}
+
+// SESNotifier ... sends the HTML report and JSON attachment as a raw SES email,
+// uploading oversized reports to S3
+type SESNotifier struct {
+	Client sesiface.SESAPI
+	S3     s3iface.S3API
+	Config *config
+}
+
+// Notify ... implements Notifier for SES
+func (n *SESNotifier) Notify(r *report) error {
+	_, err := sendEmail(r, n.Client, n.S3, n.Config)
+	return err
+}
+
+// SNSNotifier ... publishes a plain text summary to an SNS topic
+type SNSNotifier struct {
+	Client   snsiface.SNSAPI
+	TopicArn string
+}
+
+// Notify ... implements Notifier for SNS
+func (n *SNSNotifier) Notify(r *report) error {
+	lines := []string{reportSubject(r), ""}
+	if r.ArchiveURL != "" {
+		lines = append(lines, "Archive: "+r.ArchiveURL, "")
+	}
+
+	msg := truncate(strings.Join(append(lines, summaryLines(r.Items)...), "\n"), maxSNSMessage)
+
+	// SNS subjects are limited to 100 ASCII characters
+	subject := asciiSubject(r)
+	if len(subject) > 100 {
+		subject = subject[:100]
+	}
+
+	_, err := n.Client.Publish(&sns.PublishInput{
+		TopicArn: aws.String(n.TopicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(msg),
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Notification published to topic: %s\n", n.TopicArn)
+
+	return nil
+}
+
+// SlackNotifier ... posts Block Kit blocks to a Slack incoming webhook
+type SlackNotifier struct {
+	Client *http.Client
+	URL    string
+}
+
+// Notify ... implements Notifier for Slack
+func (n *SlackNotifier) Notify(r *report) error {
+	err := postJSON(n.Client, n.URL, slackMessage(r))
+	if err == nil {
+		log.Printf("Notification posted to Slack webhook\n")
+	}
+
+	return err
+}
+
+func slackMessage(r *report) map[string]interface{} {
+	blocks := []interface{}{
+		map[string]interface{}{
+			"type": "header",
+			"text": map[string]interface{}{"type": "plain_text", "text": truncate(reportSubject(r), maxSlackHeader)},
+		},
+	}
+
+	for _, i := range r.Items {
+		if len(blocks) == maxSlackBlocks-1 {
+			blocks = append(blocks, map[string]interface{}{
+				"type": "context",
+				"elements": []interface{}{
+					map[string]interface{}{
+						"type": "mrkdwn",
+						"text": fmt.Sprintf("%d more resources not shown", len(r.Items)-len(blocks)+1),
+					},
+				},
+			})
+
+			break
+		}
+
+		blocks = append(blocks, map[string]interface{}{
+			"type": "section",
+			"text": map[string]interface{}{
+				"type": "mrkdwn",
+				"text": truncate(fmt.Sprintf("%s*%s* (%s)\n%s", severityTag(i), slackEscape(resourceLabel(i)),
+					stringValue(i, "ResourceType"), slackEscape(strings.Join(changedPaths(i), ", "))), maxSlackText),
+			},
+		})
+	}
+
+	return map[string]interface{}{
+		"text":   reportSubject(r),
+		"blocks": blocks,
+	}
+}
+
+func slackEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// TeamsNotifier ... posts an adaptive card to a Microsoft Teams incoming webhook
+type TeamsNotifier struct {
+	Client *http.Client
+	URL    string
+}
+
+// Notify ... implements Notifier for Microsoft Teams
+func (n *TeamsNotifier) Notify(r *report) error {
+	err := postJSON(n.Client, n.URL, teamsMessage(r))
+	if err == nil {
+		log.Printf("Notification posted to Teams webhook\n")
+	}
+
+	return err
+}
+
+func teamsMessage(r *report) map[string]interface{} {
+	facts := make([]interface{}, 0)
+
+	for _, i := range r.Items {
+		if len(facts) == maxTeamsFacts {
+			facts = append(facts, map[string]interface{}{
+				"title": "...",
+				"value": fmt.Sprintf("%d more resources not shown", len(r.Items)-maxTeamsFacts),
+			})
+
+			break
+		}
+
+		facts = append(facts, map[string]interface{}{
+			"title": fmt.Sprintf("%s%s (%s)", severityTag(i), resourceLabel(i), stringValue(i, "ResourceType")),
+			"value": strings.Join(changedPaths(i), ", "),
+		})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []interface{}{
+			map[string]interface{}{
+				"type":   "TextBlock",
+				"size":   "Medium",
+				"weight": "Bolder",
+				"wrap":   true,
+				"text":   reportSubject(r),
+			},
+			map[string]interface{}{
+				"type":  "FactSet",
+				"facts": facts,
+			},
+		},
+	}
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content":     card,
+			},
+		},
+	}
+}
+
+// WebhookNotifier ... posts the change set as JSON to a generic HTTPS webhook
+type WebhookNotifier struct {
+	Client *http.Client
+	URL    string
+}
+
+// Notify ... implements Notifier for generic webhooks
+func (n *WebhookNotifier) Notify(r *report) error {
+	body := map[string]interface{}{
+		"subject": reportSubject(r),
+		"time":    r.Time,
+		"items":   r.Items,
+	}
+
+	if r.Snapshot != nil {
+		body["snapshot"] = aws.StringValue(r.Snapshot.Key)
+	}
+
+	if r.ArchiveURL != "" {
+		body["archive_url"] = r.ArchiveURL
+	}
+
+	err := postJSON(n.Client, n.URL, body)
+	if err == nil {
+		log.Printf("Notification posted to webhook on %s\n", webhookHost(n.URL))
+	}
+
+	return err
+}
+
+// postJSON ... posts v to a webhook. Errors name only the host of the URL,
+// which usually embeds a secret token
+func postJSON(c *http.Client, u string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.Post(u, "application/json", bytes.NewReader(b))
+	if err != nil {
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+
+		return fmt.Errorf("error posting to webhook on %s: %v", webhookHost(u), err)
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook returned unexpected status: %s", res.Status)
+	}
+
+	return nil
+}
+
+// summaryLines ... one line per resource listing the changed properties
+func summaryLines(items []map[string]interface{}) []string {
+	lines := make([]string, 0, len(items))
+
+	for _, i := range items {
+		line := fmt.Sprintf("%s%s (%s)", severityTag(i), resourceLabel(i), stringValue(i, "ResourceType"))
+		if paths := changedPaths(i); len(paths) > 0 {
+			line += ": " + strings.Join(paths, ", ")
+		} else {
+			line += ": new item"
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// severityTag ... prefix flagging items scored above info, e.g. "[HIGH] "
+func severityTag(item map[string]interface{}) string {
+	if sev := itemSeverity(item); sev > severityInfo {
+		return "[" + strings.ToUpper(sev.String()) + "] "
+	}
+
+	return ""
+}
+
+func changedPaths(item map[string]interface{}) []string {
+	changes := itemChanges(item)
+	paths := make([]string, 0, len(changes))
+
+	for _, c := range changes {
+		paths = append(paths, c.Path)
+	}
+
+	return paths
+}
+
+// newNotifiers ... creates the notifiers selected by the notifiers environment variable
+func newNotifiers(cfg *config, sess client.ConfigProvider) ([]Notifier, error) {
+	var notifiers []Notifier
+
+	for _, name := range cfg.Notifiers {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		n, err := newNotifier(name, cfg, sess)
+		if err != nil {
+			return nil, err
+		}
+
+		notifiers = append(notifiers, n)
+	}
+
+	if len(notifiers) == 0 {
+		return nil, errors.New("no notifiers configured")
+	}
+
+	return notifiers, nil
+}
+
+// newNotifier ... creates a single notifier by name from its configured target
+func newNotifier(name string, cfg *config, sess client.ConfigProvider) (Notifier, error) {
+	switch name {
+	case notifierSES:
+		if cfg.Sender == "" || len(cfg.Recipients) == 0 {
+			return nil, errors.New("ses notifier requires sender and recipients")
+		}
+
+		return &SESNotifier{Client: ses.New(sess), S3: s3.New(sess), Config: cfg}, nil
+	case notifierSNS:
+		if cfg.SNSTopicArn == "" {
+			return nil, errors.New("sns notifier requires sns_topic_arn")
+		}
+
+		return &SNSNotifier{Client: sns.New(sess), TopicArn: cfg.SNSTopicArn}, nil
+	case notifierSlack, notifierTeams, notifierWebhook:
+		return newWebhookNotifier(name, cfg)
+	case notifierSecurityHub:
+		if cfg.SecurityHubMode != securityHubChanges && cfg.SecurityHubMode != securityHubFindings {
+			return nil, fmt.Errorf("securityhub notifier requires securityhub_mode %s or %s", securityHubChanges, securityHubFindings)
+		}
+
+		return &SecurityHubNotifier{
+			Client:     securityhub.New(sess),
+			Mode:       cfg.SecurityHubMode,
+			ProductArn: cfg.SecurityHubProductArn,
+			Region:     cfg.DefaultRegion,
+		}, nil
+	case notifierEventBridge:
+		if cfg.EventBusName == "" || cfg.EventSource == "" {
+			return nil, errors.New("eventbridge notifier requires event_bus_name and event_source")
+		}
+
+		return &EventBridgeNotifier{Client: eventbridge.New(sess), Bus: cfg.EventBusName, Source: cfg.EventSource}, nil
+	}
+
+	return nil, fmt.Errorf("unknown notifier: %s", name)
+}
+
+// newWebhookNotifier ... creates a notifier posting to a configured https webhook
+func newWebhookNotifier(name string, cfg *config) (Notifier, error) {
+	httpClient := &http.Client{Timeout: time.Second * httpTimeout}
+
+	switch name {
+	case notifierSlack:
+		if err := checkWebhookURL(name+" notifier", "slack_webhook_url", cfg.SlackWebhookURL); err != nil {
+			return nil, err
+		}
+
+		return &SlackNotifier{Client: httpClient, URL: cfg.SlackWebhookURL}, nil
+	case notifierTeams:
+		if err := checkWebhookURL(name+" notifier", "teams_webhook_url", cfg.TeamsWebhookURL); err != nil {
+			return nil, err
+		}
+
+		return &TeamsNotifier{Client: httpClient, URL: cfg.TeamsWebhookURL}, nil
+	case notifierWebhook:
+		if err := checkWebhookURL(name+" notifier", "webhook_url", cfg.WebhookURL); err != nil {
+			return nil, err
+		}
+
+		return &WebhookNotifier{Client: httpClient, URL: cfg.WebhookURL}, nil
+	}
+
+	return nil, fmt.Errorf("unknown notifier: %s", name)
+}
+
+// webhookHost ... the host of a webhook URL, safe to log
+func webhookHost(u string) string {
+	if p, err := url.Parse(u); err == nil && p.Host != "" {
+		return p.Host
+	}
+
+	return "an invalid URL"
+}
+
+// checkWebhookURL ... whether a webhook URL is set and uses https, so reports
+// are never sent in the clear. The URL is left out of the error as it
+// usually embeds a secret token
+func checkWebhookURL(name, key, u string) error {
+	if u == "" {
+		return fmt.Errorf("%s requires %s", name, key)
+	}
+
+	if p, err := url.Parse(u); err != nil || p.Scheme != "https" || p.Host == "" {
+		return fmt.Errorf("%s requires an https %s", name, key)
+	}
+
+	return nil
+}
+
+// notify ... sends the report to every notifier, returning the first error
+// after all notifiers have been attempted
+func notify(notifiers []Notifier, r *report) (err error) {
+	for _, n := range notifiers {
+		if e := n.Notify(r); e != nil {
+			log.Printf("error sending notification (%T): %v\n", n, e)
+
+			if err == nil {
+				err = e
+			}
+		}
+	}
+
+	return err
+}
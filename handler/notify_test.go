@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// AWS Service Mocks //
+type mockSNSClient struct {
+	snsiface.SNSAPI
+	Input *sns.PublishInput
+}
+
+func (m *mockSNSClient) Publish(in *sns.PublishInput) (*sns.PublishOutput, error) {
+	m.Input = in
+	return &sns.PublishOutput{}, nil
+}
+
+// helper functions //
+func testReport(t *testing.T) *report {
+	return &report{
+		Items:    parseTestMap(t, testMapFile),
+		Time:     time.Date(2020, 1, 30, 13, 35, 19, 0, time.UTC),
+		Snapshot: &s3.Object{Key: aws.String("snapshot.json.gz")},
+	}
+}
+
+// test functions //
+func TestItemChanges(t *testing.T) {
+	item := changedTestItem(t,
+		`{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "ResourceName": "old", "Configuration": {"a": "1"}}`,
+		`{"ResourceType": "AWS::SQS::Queue", "ResourceId": "q-1", "ResourceName": "new", "Configuration": {"a": "2"}}`)
+	expected := []propertyChange{
+		{Path: "Configuration.a", Previous: "1", Current: "2"},
+		{Path: "ResourceName", Previous: "old", Current: "new"},
+	}
+
+	changes := itemChanges(item)
+	if len(changes) != len(expected) {
+		t.Fatalf("itemChanges() failed. Expected %d changes, got %d: %v", len(expected), len(changes), changes)
+	}
+
+	for i, c := range changes {
+		if c != expected[i] {
+			t.Errorf("itemChanges() failed. Expected: %v\nGot: %v\n", expected[i], c)
+		}
+	}
+}
+
+func TestSNSNotifier(t *testing.T) {
+	m := &mockSNSClient{}
+	n := &SNSNotifier{Client: m, TopicArn: "arn:aws:sns:us-east-1:123456789012:test"}
+
+	err := n.Notify(testReport(t))
+	if err != nil {
+		t.Fatalf("Notify() failed. Unexpected error: %v", err)
+	}
+
+	if aws.StringValue(m.Input.TopicArn) != n.TopicArn {
+		t.Errorf("Notify() failed. Expected topic %s, got %s", n.TopicArn, aws.StringValue(m.Input.TopicArn))
+	}
+
+	if !strings.Contains(aws.StringValue(m.Input.Message), "bucket-name (AWS::S3::Bucket)") {
+		t.Errorf("Notify() failed. Message missing resource: %s", aws.StringValue(m.Input.Message))
+	}
+
+	// oversized messages are cut on a rune boundary
+	r := testReport(t)
+	r.Items[0]["ResourceName"] = strings.Repeat("é", maxSNSMessage)
+	chkErr(t, n.Notify(r))
+
+	msg := aws.StringValue(m.Input.Message)
+	if len(msg) > maxSNSMessage || !utf8.ValidString(msg) || !strings.HasSuffix(msg, truncatedMsg) {
+		t.Errorf("Notify() failed. Expected a valid message of at most %d bytes, got %d bytes", maxSNSMessage, len(msg))
+	}
+}
+
+func TestWebhookNotifiers(t *testing.T) {
+	tt := map[string]struct {
+		notifier func(c *http.Client, url string) Notifier
+		expected string
+	}{
+		"slack": {
+			notifier: func(c *http.Client, url string) Notifier { return &SlackNotifier{Client: c, URL: url} },
+			expected: `"type":"header"`,
+		},
+		"teams": {
+			notifier: func(c *http.Client, url string) Notifier { return &TeamsNotifier{Client: c, URL: url} },
+			expected: `"contentType":"application/vnd.microsoft.card.adaptive"`,
+		},
+		"webhook": {
+			notifier: func(c *http.Client, url string) Notifier { return &WebhookNotifier{Client: c, URL: url} },
+			expected: `"snapshot":"snapshot.json.gz"`,
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			var body []byte
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ = io.ReadAll(r.Body)
+			}))
+			defer srv.Close()
+
+			err := tc.notifier(srv.Client(), srv.URL).Notify(testReport(t))
+			if err != nil {
+				t.Fatalf("Notify() failed. Unexpected error: %v", err)
+			}
+
+			if !json.Valid(body) {
+				t.Fatalf("Notify() failed. Invalid JSON posted: %s", body)
+			}
+
+			if !strings.Contains(string(body), tc.expected) {
+				t.Errorf("Notify() failed. Expected body to contain %s\nGot: %s", tc.expected, body)
+			}
+		})
+	}
+}
+
+func TestWebhookNotifierError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{Client: srv.Client(), URL: srv.URL}
+	if err := n.Notify(testReport(t)); err == nil {
+		t.Errorf("Notify() failed. Expected error for status 500")
+	}
+}
+
+func TestWebhookNotifierSecret(t *testing.T) {
+	var logs bytes.Buffer
+
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	n := &WebhookNotifier{Client: srv.Client(), URL: srv.URL + "/hooks/T0KEN"}
+	chkErr(t, n.Notify(testReport(t)))
+
+	// an unreachable webhook fails without the token in the error
+	n.URL = "https://127.0.0.1:0/hooks/T0KEN"
+	err := n.Notify(testReport(t))
+
+	if err == nil || strings.Contains(err.Error(), "T0KEN") || strings.Contains(logs.String(), "T0KEN") {
+		t.Errorf("Notify() failed. Expected the webhook token kept out of logs and errors, got: %v\n%s", err, logs.String())
+	}
+}
+
+func TestSlackMessageLimits(t *testing.T) {
+	r := testReport(t)
+	r.Items[0]["ResourceName"] = strings.Repeat("n", maxSlackText)
+
+	blocks := slackMessage(r)["blocks"].([]interface{})
+
+	for _, b := range blocks {
+		text, _ := b.(map[string]interface{})["text"].(map[string]interface{})
+		if s, _ := text["text"].(string); len(s) > maxSlackText {
+			t.Errorf("slackMessage() failed. Expected section text of at most %d characters, got %d", maxSlackText, len(s))
+		}
+	}
+
+	header := blocks[0].(map[string]interface{})["text"].(map[string]interface{})["text"].(string)
+	if len(header) > maxSlackHeader {
+		t.Errorf("slackMessage() failed. Expected a header of at most %d characters, got %d", maxSlackHeader, len(header))
+	}
+}
+
+func TestNewNotifiers(t *testing.T) {
+	tt := map[string]struct {
+		cfg      config
+		expected int
+		err      bool
+	}{
+		"default": {
+			cfg:      config{Notifiers: []string{"ses"}, Sender: "a@b.c", Recipients: []string{"d@e.f"}},
+			expected: 1,
+		},
+		"multiple": {
+			cfg: config{
+				Notifiers:       []string{"sns", "slack", "webhook"},
+				SNSTopicArn:     "arn",
+				SlackWebhookURL: "https://example.com/slack",
+				WebhookURL:      "https://example.com/hook",
+			},
+			expected: 3,
+		},
+		"missing url": {
+			cfg: config{Notifiers: []string{"teams"}},
+			err: true,
+		},
+		"http url": {
+			cfg: config{Notifiers: []string{"webhook"}, WebhookURL: "http://example.com/hook"},
+			err: true,
+		},
+		"relative url": {
+			cfg: config{Notifiers: []string{"slack"}, SlackWebhookURL: "example.com/slack"},
+			err: true,
+		},
+		"unknown": {
+			cfg: config{Notifiers: []string{"pager"}},
+			err: true,
+		},
+		"none": {
+			cfg: config{},
+			err: true,
+		},
+	}
+
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+
+	for name, tc := range tt {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			n, err := newNotifiers(&tc.cfg, sess)
+			if tc.err != (err != nil) {
+				t.Fatalf("newNotifiers() failed. Expected error: %v, got: %v", tc.err, err)
+			}
+
+			if len(n) != tc.expected {
+				t.Errorf("newNotifiers() failed. Expected %d notifiers, got %d", tc.expected, len(n))
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/ses"
+)
+
+const (
+	sesMaxMessageSize    = 10 * 1024 * 1024 // SES limit for raw messages, including attachments
+	gzipFile             = "/tmp/items.json.gz"
+	maxSummaryItems      = 500
+	defaultPresignExpiry = time.Hour
+	oversizedDir         = "oversized"
+)
+
+// htmlSummary ... data passed to the "summary" template used in place of the
+// full report when it is too large to email
+type htmlSummary struct {
+	Time     time.Time
+	Window   int
+	Snapshot string
+	FullURL  string
+	Items    []htmlSummaryItem
+	Omitted  int
+}
+
+// htmlSummaryItem ... a resource and the names of its changed properties
+type htmlSummaryItem struct {
+	Label   string
+	Type    string
+	Changes string
+}
+
+func maxEmailSize(cfg *config) int {
+	if cfg.MaxEmailSize <= 0 {
+		return sesMaxMessageSize
+	}
+
+	return cfg.MaxEmailSize
+}
+
+// fitEmail ... shrinks an oversized message in steps until it fits: gzip the
+// JSON attachment, replace the bodies with a summary linking to the full report
+// uploaded to S3, then drop the attachment altogether
+func fitEmail(r *report, htmlBody, textBody string, slice []byte, svc s3iface.S3API, cfg *config) (*ses.SendRawEmailInput, error) {
+	limit := maxEmailSize(cfg)
+	subject := reportSubject(r)
+
+	gz, err := gzipBytes(slice)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.WriteFile(gzipFile, gz, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := buildEmailInput(subject, htmlBody, textBody, gzipFile, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(input.RawMessage.Data) <= limit {
+		log.Printf("Email exceeded %d bytes, attachment compressed\n", limit)
+		return input, nil
+	}
+
+	fullURL, err := uploadFullReport(r, htmlBody, textBody, slice, svc, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	summaryHTML, summaryText, err := reportSummary(r, fullURL)
+	if err != nil {
+		return nil, err
+	}
+
+	input, err = buildEmailInput(subject, summaryHTML, summaryText, gzipFile, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(input.RawMessage.Data) <= limit {
+		log.Printf("Email exceeded %d bytes, sending summary with link to full report\n", limit)
+		return input, nil
+	}
+
+	log.Printf("Email exceeded %d bytes, sending summary without attachment\n", limit)
+
+	return buildEmailInput(subject, summaryHTML, summaryText, "", cfg)
+}
+
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// uploadFullReport ... returns a link to the full HTML report: the archived
+// copy served by archive_url when both are set, otherwise a presigned link to
+// the archived copy or, when archiving is disabled or the report is a route's
+// part, to the report uploaded under the oversized directory of the archive
+// prefix in s3_bucket. Presigned
+// links stop working when the function's session credentials expire, however
+// long presign_expiry is
+func uploadFullReport(r *report, htmlBody, textBody string, slice []byte, svc s3iface.S3API, cfg *config) (string, error) {
+	if cfg.ArchiveURL != "" && cfg.ArchiveBucket != "" && r.ArchiveURL != "" {
+		return r.ArchiveURL, nil
+	}
+
+	dir := reportDir(r)
+	bucket := cfg.ArchiveBucket
+
+	if bucket == "" || r.ArchiveURL == "" {
+		bucket = cfg.S3Bucket
+		dir = path.Join(oversizedDir, dir)
+
+		// each route's part of the report is uploaded separately
+		if r.Route > 0 {
+			dir = path.Join(dir, fmt.Sprintf("route-%d", r.Route))
+		}
+
+		for _, f := range []archiveFile{
+			{Name: "report.html", ContentType: "text/html; charset=utf-8", Body: []byte(htmlBody)},
+			{Name: "items.json", ContentType: "application/json", Body: slice},
+			{Name: "report.txt", ContentType: "text/plain; charset=utf-8", Body: []byte(textBody)},
+		} {
+			err := putObject(svc, bucket, archiveKey(cfg, path.Join(dir, f.Name)), f.ContentType, cfg.KmsKeyArn, f.Body)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	expiry := cfg.PresignExpiry
+	if expiry <= 0 {
+		expiry = defaultPresignExpiry
+	}
+
+	return presignURL(svc, bucket, archiveKey(cfg, path.Join(dir, "report.html")), expiry)
+}
+
+func presignURL(svc s3iface.S3API, bucket, key string, expiry time.Duration) (string, error) {
+	req, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+
+	return req.Presign(expiry)
+}
+
+// reportSummary ... renders HTML and text bodies listing the changed resources
+// with a link to the full report
+func reportSummary(r *report, fullURL string) (string, string, error) {
+	s := htmlSummary{
+		Time:     r.Time,
+		Window:   window,
+		Snapshot: r.snapshotName(),
+		FullURL:  fullURL,
+	}
+
+	lines := summaryLines(r.Items)
+
+	for n, i := range r.Items {
+		if n == maxSummaryItems {
+			s.Omitted = len(r.Items) - maxSummaryItems
+			lines = append(lines[:maxSummaryItems], fmt.Sprintf("... %d more resources", s.Omitted))
+
+			break
+		}
+
+		s.Items = append(s.Items, htmlSummaryItem{
+			Label:   resourceLabel(i),
+			Type:    stringValue(i, "ResourceType"),
+			Changes: strings.Join(changedPaths(i), ", "),
+		})
+	}
+
+	h, err := executeTemplate(r.templates(), "summary", s)
+	if err != nil {
+		return "", "", err
+	}
+
+	t := fmt.Sprintf("Configuration Changes at %v (+/- %v min)\nSnapshot: %s\n\n"+
+		"This report was too large to send by email. The full report is available at:\n%s\n\n%s\n",
+		r.Time, window, s.Snapshot, fullURL, strings.Join(lines, "\n"))
+
+	return h, t, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/rand"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestFitEmail(t *testing.T) {
+	random := make([]byte, 150*1024)
+
+	_, err := rand.Read(random)
+	chkErr(t, err)
+
+	large := strings.Repeat("<p>changed</p>\n", 10*1024)
+
+	tt := map[string]struct {
+		htmlBody    string
+		slice       []byte
+		expected    []string
+		notExpected []string
+		uploaded    bool
+		archiveURL  string
+	}{
+		"compress attachment": {
+			htmlBody:    "<p>changed</p>",
+			slice:       []byte(strings.Repeat("{}", 100*1024)),
+			expected:    []string{"items.json.gz", "<p>changed</p>"},
+			notExpected: []string{"too large"},
+		},
+		"summary": {
+			htmlBody:    large,
+			slice:       []byte(strings.Repeat("{}", 100*1024)),
+			expected:    []string{"items.json.gz", "too large", "X-Amz-Signature"},
+			notExpected: []string{"<p>changed</p>"},
+			uploaded:    true,
+		},
+		"archive url": {
+			htmlBody:    large,
+			slice:       []byte(strings.Repeat("{}", 100*1024)),
+			expected:    []string{"too large", "https://reports.example.gov/2020/01/30/20200130T133519Z/report.html"},
+			notExpected: []string{"X-Amz-Signature"},
+			archiveURL:  "https://reports.example.gov",
+		},
+		"no attachment": {
+			htmlBody:    large,
+			slice:       random,
+			expected:    []string{"too large"},
+			notExpected: []string{"items.json.gz"},
+			uploaded:    true,
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+
+		t.Run(name, func(t *testing.T) {
+			m := &mockS3{}
+			cfg := config{
+				Sender:        "a@b.c",
+				Recipients:    []string{"d@e.f"},
+				S3Bucket:      "logging",
+				ArchivePrefix: "reports",
+				MaxEmailSize:  100 * 1024,
+			}
+
+			r := testReport(t)
+			if tc.archiveURL != "" {
+				cfg.ArchiveBucket, cfg.ArchiveURL = "archive", tc.archiveURL
+				r.ArchiveURL = archiveURL(&cfg, path.Join(archiveDir(r.Time), "report.html"))
+			}
+
+			input, err := fitEmail(r, tc.htmlBody, "changed", tc.slice, m, &cfg)
+			if err != nil {
+				t.Fatalf("fitEmail() failed. Unexpected error: %v", err)
+			}
+
+			raw := string(input.RawMessage.Data)
+			if len(raw) > cfg.MaxEmailSize {
+				t.Errorf("fitEmail() failed. Message size %d exceeds %d", len(raw), cfg.MaxEmailSize)
+			}
+
+			for _, e := range tc.expected {
+				if !strings.Contains(raw, e) {
+					t.Errorf("fitEmail() failed. Expected message to contain %q", e)
+				}
+			}
+
+			for _, e := range tc.notExpected {
+				if strings.Contains(raw, e) {
+					t.Errorf("fitEmail() failed. Expected message not to contain %q", e)
+				}
+			}
+
+			_, ok := m.Puts["reports/oversized/2020/01/30/20200130T133519Z/report.html"]
+			if ok != tc.uploaded {
+				t.Errorf("fitEmail() failed. Expected full report uploaded: %v, got %v", tc.uploaded, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/mail"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+)
+
+const (
+	ownerKey       = "Owner"
+	ownerSourceKey = "OwnerSource"
+	ownerDefault   = "default"
+)
+
+// resolveOwners ... sets the owner of each item from its own owner tags, the
+// owner tags of the resources it is related to, or the default owner
+func resolveOwners(items, known []map[string]interface{}, cfg *config) {
+	index := make(map[string]map[string]interface{}, len(known))
+
+	// earlier items take precedence, so current items are preferred to snapshots
+	for _, k := range known {
+		if _, ok := index[resourceKey(k)]; !ok {
+			index[resourceKey(k)] = k
+		}
+	}
+
+	for _, i := range items {
+		owner, source := itemOwner(i, index, cfg.OwnerTags)
+		if owner == "" && cfg.DefaultOwner != "" {
+			owner, source = cfg.DefaultOwner, ownerDefault
+		}
+
+		if owner != "" {
+			i[ownerKey] = owner
+			i[ownerSourceKey] = source
+		}
+	}
+}
+
+func itemOwner(i map[string]interface{}, index map[string]map[string]interface{}, ownerTags []string) (string, string) {
+	if owner, tag := firstTag(i, ownerTags); owner != "" {
+		return owner, "tag:" + tag
+	}
+
+	relationships, _ := i["Relationships"].([]interface{})
+
+	for _, r := range relationships {
+		rel, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		related, ok := index[resourceKey(rel)]
+		if !ok {
+			continue
+		}
+
+		if owner, tag := firstTag(related, ownerTags); owner != "" {
+			return owner, "tag:" + tag + " of " + stringValue(rel, "ResourceType") + " " + resourceLabel(related)
+		}
+	}
+
+	return "", ""
+}
+
+// firstTag ... the value and name of the first of the named tags on an item,
+// matching tag names case insensitively
+func firstTag(i map[string]interface{}, names []string) (string, string) {
+	tags, _ := i["Tags"].(map[string]interface{})
+
+	for _, name := range names {
+		for k, v := range tags {
+			if s, ok := v.(string); ok && s != "" && strings.EqualFold(k, strings.TrimSpace(name)) {
+				return s, k
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// resourceKey ... identifies a resource by type and ID across items and relationships
+func resourceKey(i map[string]interface{}) string {
+	return stringValue(i, "ResourceType") + "|" + stringValue(i, "ResourceId")
+}
+
+// ownerItems ... the indexes of the items owned by each owner that is an
+// email address in one of the allowed domains, other than the excluded
+// addresses. Anyone who can tag a resource can set its owner, so owners
+// outside the domains are never sent changes
+func ownerItems(items []map[string]interface{}, domains, exclude []string) map[string][]int {
+	excluded := make(map[string]bool)
+
+	for _, e := range exclude {
+		excluded[strings.ToLower(strings.TrimSpace(e))] = true
+	}
+
+	owned := make(map[string][]int)
+
+	for n, i := range items {
+		owner := strings.TrimSpace(stringValue(i, ownerKey))
+
+		addr, err := mail.ParseAddress(owner)
+		if err != nil || addr.Address != owner || excluded[strings.ToLower(owner)] {
+			continue
+		}
+
+		owner = strings.ToLower(owner)
+		if len(domains) > 0 && matchAny(domains, owner[strings.LastIndex(owner, "@")+1:]) {
+			owned[owner] = append(owned[owner], n)
+		}
+	}
+
+	return owned
+}
+
+// ownerRoutes ... an SES route for each owner to be emailed, holding only the
+// changes to the resources they own
+func ownerRoutes(items []map[string]interface{}, cfg *config, sess client.ConfigProvider) ([]route, error) {
+	if !cfg.CCOwners {
+		return nil, nil
+	}
+
+	owned := ownerItems(items, cfg.OwnerEmailDomains, cfg.Recipients)
+
+	owners := make([]string, 0, len(owned))
+	for o := range owned {
+		owners = append(owners, o)
+	}
+
+	sort.Strings(owners)
+
+	var routes []route
+
+	for _, o := range owners {
+		c := *cfg
+		c.Recipients = []string{o}
+
+		n, err := newNotifier(notifierSES, &c, sess)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, route{Notifier: n, Items: selectItems(items, owned[o])})
+	}
+
+	return routes, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// helper functions //
+func ownerTestItem(resourceType, id string, tags map[string]interface{}, related ...string) map[string]interface{} {
+	var relationships []interface{}
+
+	for n := 0; n+1 < len(related); n += 2 {
+		relationships = append(relationships, map[string]interface{}{
+			"ResourceType":     related[n],
+			"ResourceId":       related[n+1],
+			"RelationshipName": "Is attached to Instance",
+		})
+	}
+
+	return map[string]interface{}{
+		"ResourceType":  resourceType,
+		"ResourceId":    id,
+		"Tags":          tags,
+		"Relationships": relationships,
+	}
+}
+
+// test functions //
+func TestResolveOwners(t *testing.T) {
+	instance := ownerTestItem("AWS::EC2::Instance", "i-1", map[string]interface{}{"team": "apps@example.com"})
+	snapshot := ownerTestItem("AWS::EC2::Instance", "i-1", map[string]interface{}{"Owner": "old@example.com"})
+	tt := map[string]struct {
+		item           map[string]interface{}
+		defaultOwner   string
+		expected       string
+		expectedSource string
+	}{
+		"own tag in preference order": {
+			item:           ownerTestItem("AWS::EC2::Volume", "vol-1", map[string]interface{}{"Contact": "c", "Owner": "o"}),
+			expected:       "o",
+			expectedSource: "tag:Owner",
+		},
+		"related resource": {
+			item:           ownerTestItem("AWS::EC2::NetworkInterface", "eni-1", nil, "AWS::EC2::Instance", "i-1"),
+			expected:       "apps@example.com",
+			expectedSource: "tag:team of AWS::EC2::Instance i-1",
+		},
+		"default owner": {
+			item:           ownerTestItem("AWS::EC2::Volume", "vol-2", nil, "AWS::EC2::Instance", "i-2"),
+			defaultOwner:   "ops@example.com",
+			expected:       "ops@example.com",
+			expectedSource: ownerDefault,
+		},
+		"no owner": {
+			item: ownerTestItem("AWS::EC2::Volume", "vol-3", map[string]interface{}{"Name": "data"}),
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			cfg := &config{OwnerTags: []string{"Owner", "Team", "Contact"}, DefaultOwner: tc.defaultOwner}
+			known := []map[string]interface{}{instance, tc.item, snapshot}
+
+			resolveOwners([]map[string]interface{}{tc.item}, known, cfg)
+
+			if owner := stringValue(tc.item, ownerKey); owner != tc.expected {
+				t.Errorf("resolveOwners() failed. Expected owner %q, got: %q", tc.expected, owner)
+			}
+
+			if source := stringValue(tc.item, ownerSourceKey); !strings.HasPrefix(source, tc.expectedSource) {
+				t.Errorf("resolveOwners() failed. Expected source %q, got: %q", tc.expectedSource, source)
+			}
+		})
+	}
+}
+
+func TestOwnerRoutes(t *testing.T) {
+	items := []map[string]interface{}{
+		{ownerKey: "b@example.com"},
+		{ownerKey: "platform team"},
+		{ownerKey: "A@example.com"},
+		{ownerKey: "b@example.com"},
+		{ownerKey: "ops@example.com"},
+		{ownerKey: "someone@attacker.example.net"},
+		{ownerKey: "Bob <b@example.com>, c@attacker.example.net"},
+		{ownerKey: "dba@data.example.gov"},
+		{},
+	}
+
+	expected := map[string][]int{"a@example.com": {2}, "b@example.com": {0, 3}, "dba@data.example.gov": {7}}
+	if got := ownerItems(items, []string{"example.com", "*.example.gov"}, []string{"OPS@example.com"}); !reflect.DeepEqual(got, expected) {
+		t.Errorf("ownerItems() failed. Expected: %v\nGot: %v", expected, got)
+	}
+
+	if got := ownerItems(items, nil, nil); len(got) != 0 {
+		t.Errorf("ownerItems() failed. Expected no owners without allowed domains, got: %v", got)
+	}
+
+	cfg := &config{Notifiers: []string{notifierSES}, Sender: "a@b.c", Recipients: []string{"ops@example.com"}, CCOwners: true, OwnerEmailDomains: []string{"example.com"}}
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+
+	notifiers, routes, err := configureNotifiers(items, nil, cfg, sess, &mockS3{})
+	chkErr(t, err)
+
+	if notifiers != nil || len(routes) != 3 {
+		t.Fatalf("configureNotifiers() failed. Expected the default route and a route per owner, got: %d routes", len(routes))
+	}
+
+	if len(routes[0].Items) != len(items) {
+		t.Errorf("configureNotifiers() failed. Expected the recipients to receive every change, got: %d", len(routes[0].Items))
+	}
+
+	for n, owner := range []string{"a@example.com", "b@example.com"} {
+		rt := routes[n+1]
+
+		ses, ok := rt.Notifier.(*SESNotifier)
+		if !ok || !reflect.DeepEqual(ses.Config.Recipients, []string{owner}) || len(rt.Items) != len(expected[owner]) {
+			t.Errorf("configureNotifiers() failed. Expected %s to receive only their changes, got: %+v", owner, rt)
+		}
+	}
+
+	f, err := os.CreateTemp("", "items*.json")
+	chkErr(t, err)
+
+	defer os.Remove(f.Name())
+
+	input, err := buildEmailInput("subject", "html", "text", f.Name(), &config{Sender: "a@b.c", Recipients: []string{"d@e.f"}})
+	chkErr(t, err)
+
+	if strings.Contains(string(input.RawMessage.Data), "Cc:") {
+		t.Errorf("buildEmailInput() failed. Expected no Cc header in:\n%s", input.RawMessage.Data)
+	}
+}
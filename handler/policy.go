@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// policyEnv ... the variables available to rule conditions and messages for
+// one property change: the resource's type, ID, name, account, region, tags,
+// item status and kind of change, and the change's path and old and new values
+type policyEnv struct {
+	ResourceType string                 `expr:"resource_type"`
+	ResourceID   string                 `expr:"resource_id"`
+	Name         string                 `expr:"name"`
+	Account      string                 `expr:"account"`
+	Region       string                 `expr:"region"`
+	Status       string                 `expr:"status"`
+	Change       string                 `expr:"change"`
+	Tags         map[string]interface{} `expr:"tags"`
+	Path         string                 `expr:"path"`
+	Old          interface{}            `expr:"old"`
+	New          interface{}            `expr:"new"`
+}
+
+// newPolicyEnv ... the policy variables of a property change of the item
+func newPolicyEnv(item map[string]interface{}, c propertyChange) policyEnv {
+	tags, _ := item["Tags"].(map[string]interface{})
+	if tags == nil {
+		tags = make(map[string]interface{})
+	}
+
+	return policyEnv{
+		ResourceType: stringValue(item, "ResourceType"),
+		ResourceID:   stringValue(item, "ResourceId"),
+		Name:         resourceLabel(item),
+		Account:      stringValue(item, "AccountId"),
+		Region:       stringValue(item, "AwsRegion"),
+		Status:       stringValue(item, "ConfigurationItemStatus"),
+		Change:       changeKind(item),
+		Tags:         tags,
+		Path:         c.Path,
+		Old:          c.Previous,
+		New:          c.Current,
+	}
+}
+
+// compilePolicy ... compiles a condition or message expression, checking
+// it only uses the policy variables and returns the expected kind
+func compilePolicy(source string, kind reflect.Kind) (*vm.Program, error) {
+	return expr.Compile(source, expr.Env(policyEnv{}), expr.AsKind(kind))
+}
+
+// evaluate ... whether the rule's condition holds for the change, true when
+// the rule has none
+func (r *riskRule) evaluate(item map[string]interface{}, c propertyChange) (bool, error) {
+	if r.condition == nil {
+		return true, nil
+	}
+
+	v, err := expr.Run(r.condition, newPolicyEnv(item, c))
+	if err != nil {
+		return false, fmt.Errorf("rule %s: %v", r.Name, err)
+	}
+
+	ok, _ := v.(bool)
+
+	return ok, nil
+}
+
+// reason ... the rule's message for the change, falling back to its reason
+// when it has no message or the message cannot be evaluated
+func (r *riskRule) reason(item map[string]interface{}, c propertyChange) string {
+	if r.message == nil {
+		return r.Reason
+	}
+
+	v, err := expr.Run(r.message, newPolicyEnv(item, c))
+	if s, ok := v.(string); err == nil && ok && s != "" {
+		return s
+	}
+
+	return r.Reason
+}
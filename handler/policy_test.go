@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyRules(t *testing.T) {
+	var out bytes.Buffer
+
+	chkErr(t, runRules([]string{"-rules", "testdata/policy_rules.json", "-snapshot", "testdata/policy_snapshot.json",
+		"testdata/policy_items.json"}, &out))
+
+	for _, s := range []string{
+		"critical  ssh-open-to-internet       Configuration.ipPermissions",
+		"SSH open to the internet on bastion (prod)",
+		"high      prod-versioning-suspended",
+		"versioning suspended on a production bucket",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("runRules() failed. Expected %q in:\n%s", s, out.String())
+		}
+	}
+
+	// a condition failing at runtime is reported and fails the test run
+	rules := filepath.Join(t.TempDir(), "rules.json")
+	chkErr(t, os.WriteFile(rules, []byte(`{"include_defaults": false, "rules": [
+		{"name": "bad", "condition": "new.fromPort > 0", "severity": "low", "reason": "bad"}]}`), 0o600))
+
+	out.Reset()
+
+	if err := runRules([]string{"-rules", rules, "-snapshot", "testdata/policy_snapshot.json", "testdata/policy_items.json"}, &out); err == nil ||
+		!strings.Contains(out.String(), "rule bad:") {
+		t.Errorf("runRules() failed. Expected the condition error to be reported, got: %v\n%s", err, out.String())
+	}
+}
+
+func TestPolicyCompile(t *testing.T) {
+	tt := map[string]struct {
+		rule  string
+		valid bool
+	}{
+		"condition":         {`{"rules": [{"name": "r", "condition": "tags.Env == 'prod' && old != new", "severity": "low"}]}`, true},
+		"message":           {`{"rules": [{"name": "r", "message": "name + ': ' + path", "severity": "low"}]}`, true},
+		"unknown variable":  {`{"rules": [{"name": "r", "condition": "owner == 'ops'", "severity": "low"}]}`, false},
+		"not a boolean":     {`{"rules": [{"name": "r", "condition": "path", "severity": "low"}]}`, false},
+		"message not text":  {`{"rules": [{"name": "r", "message": "path == ''", "severity": "low"}]}`, false},
+		"invalid condition": {`{"rules": [{"name": "r", "condition": "path ==", "severity": "low"}]}`, false},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseRiskRules([]byte(tc.rule), nil); (err == nil) != tc.valid {
+				t.Errorf("parseRiskRules() failed. Expected valid %v, got: %v", tc.valid, err)
+			}
+		})
+	}
+
+	rules, err := parseRiskRules([]byte(`{"rules": [{"name": "memory", "condition": "new > old * 2", "severity": "medium",
+		"reason": "memory increased", "message": "'memory of ' + name + ' raised to ' + string(new)"}]}`), nil)
+	chkErr(t, err)
+
+	item := configTestItem(t, "AWS::Lambda::Function", `{"memorySize": 128}`, `{"memorySize": 512}`)
+	scoreItems([]map[string]interface{}{item}, rules)
+
+	if r := itemRisks(item); len(r) != 1 || r[0].Reason != "memory of resource raised to 512" || itemSeverity(item) != severityMedium {
+		t.Errorf("scoreItems() failed. Expected the rule's message, got: %+v", r)
+	}
+}
+
+func TestPolicyConditionError(t *testing.T) {
+	rules, err := parseRiskRules([]byte(`{"include_defaults": false, "rules": [{"name": "port-opened",
+		"path": "^Configuration\\.rule$", "condition": "new.fromPort > 0", "severity": "high", "reason": "port opened"}]}`), nil)
+	chkErr(t, err)
+
+	item := func(id string) map[string]interface{} {
+		return changedTestItem(t,
+			`{"ResourceType": "AWS::EC2::SecurityGroup", "ResourceId": "`+id+`", "Configuration": {"rule": {"fromPort": 22}}}`,
+			`{"ResourceType": "AWS::EC2::SecurityGroup", "ResourceId": "`+id+`", "Configuration": {}}`)
+	}
+
+	var buf bytes.Buffer
+
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	items := []map[string]interface{}{item("sg-1"), item("sg-2")}
+	scoreItems(items, rules)
+
+	for _, i := range items {
+		if r := itemRisks(i); len(r) != 1 || r[0].Rule != "port-opened" || !strings.Contains(r[0].Reason, "could not be evaluated") ||
+			itemSeverity(i) != severityHigh {
+			t.Errorf("scoreItems() failed. Expected a change whose condition fails flagged at the rule's severity, got: %+v", r)
+		}
+	}
+
+	if n := strings.Count(buf.String(), "rule port-opened:"); n != 1 {
+		t.Errorf("scoreItems() failed. Expected the condition error logged once, got %d in: %s", n, buf.String())
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	redactedPrefix = "[redacted "
+	redactedHexLen = 16
+)
+
+// redactedRoots ... the top level properties holding resource configuration,
+// the only properties redacted so identity fields are never hashed
+var redactedRoots = []string{"Configuration", "SupplementaryConfiguration"}
+
+// redactor ... replaces the configuration values matching key name or path
+// patterns with a salted hash, so changes stay visible without their values
+type redactor struct {
+	patterns [][]string
+	salt     []byte
+}
+
+// newRedactor ... creates a redactor for the configured patterns, returning
+// nil when redaction is disabled. Without a configured salt a random one is
+// used, so hashes are only comparable within a single report
+func newRedactor(cfg *config) *redactor {
+	r := &redactor{salt: []byte(cfg.RedactionSalt)}
+
+	for _, p := range cfg.RedactPatterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" {
+			continue
+		}
+
+		if _, err := path.Match(p, ""); err != nil {
+			log.Printf("ignoring invalid redaction pattern %q: %v\n", p, err)
+			continue
+		}
+
+		r.patterns = append(r.patterns, strings.Split(p, "."))
+	}
+
+	if len(r.patterns) == 0 {
+		return nil
+	}
+
+	if len(r.salt) == 0 {
+		r.salt = make([]byte, 32)
+		if _, err := rand.Read(r.salt); err != nil {
+			log.Printf("error generating redaction salt: %v\n", err)
+		}
+	}
+
+	return r
+}
+
+// redactItems ... redacts the matching configuration values of each item in place
+func (r *redactor) redactItems(items []map[string]interface{}) {
+	if r == nil {
+		return
+	}
+
+	for _, i := range items {
+		for _, root := range redactedRoots {
+			if v, ok := i[root]; ok {
+				i[root] = r.redact(v, []string{strings.ToLower(root)})
+			}
+		}
+	}
+}
+
+func (r *redactor) redact(v interface{}, p []string) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	if r.matches(p) {
+		return r.hash(v)
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		name, value := nameValueKeys(t)
+
+		for k, child := range t {
+			// the value of a name/value pair, such as an ECS environment
+			// variable or a parameter, is matched by its name
+			if k == value && r.matches(append(p[:len(p):len(p)], strings.ToLower(stringValue(t, name)))) {
+				t[k] = r.hash(child)
+				continue
+			}
+
+			t[k] = r.redact(child, append(p[:len(p):len(p)], strings.ToLower(k)))
+		}
+	case []interface{}:
+		for n, child := range t {
+			t[n] = r.redact(child, append(p[:len(p):len(p)], strconv.Itoa(n)))
+		}
+	}
+
+	return v
+}
+
+// nameValueKeys ... the keys of a name/value pair object, empty otherwise
+func nameValueKeys(m map[string]interface{}) (string, string) {
+	for _, keys := range [][2]string{
+		{"name", "value"}, {"Name", "Value"}, {"key", "value"}, {"Key", "Value"},
+		{"ParameterName", "ParameterValue"}, {"parameterName", "parameterValue"},
+	} {
+		if _, ok := m[keys[0]].(string); ok {
+			if _, ok := m[keys[1]]; ok {
+				return keys[0], keys[1]
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// matches ... whether a lower case property path matches a pattern. Patterns
+// without dots match key names; dotted patterns match the end of the path
+func (r *redactor) matches(p []string) bool {
+	for _, pattern := range r.patterns {
+		if len(pattern) > len(p) {
+			continue
+		}
+
+		tail := p[len(p)-len(pattern):]
+		matched := true
+
+		for n, segment := range pattern {
+			if ok, _ := path.Match(segment, tail[n]); !ok {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redacts ... whether the value at a property path, such as
+// Configuration.environment.variables.API_KEY, is redacted
+func (r *redactor) redacts(propertyPath string) bool {
+	return r != nil && r.matches(strings.Split(strings.ToLower(propertyPath), "."))
+}
+
+// hash ... the salted hash replacing a redacted value
+func (r *redactor) hash(v interface{}) string {
+	mac := hmac.New(sha256.New, r.salt)
+	mac.Write([]byte(compactJSON(v)))
+
+	return redactedPrefix + hex.EncodeToString(mac.Sum(nil))[:redactedHexLen] + "]"
+}
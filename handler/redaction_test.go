@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactItems(t *testing.T) {
+	cfg := &config{
+		RedactPatterns: []string{"*password*", "Environment.Variables.*", "UserData", " "},
+		RedactionSalt:  "salt",
+	}
+	r := newRedactor(cfg)
+	item := func(secret string) map[string]interface{} {
+		return digestTestItem(t, `{"ResourceType": "AWS::Lambda::Function", "ResourceId": "fn-password-rotator",
+			"Configuration": {"environment": {"variables": {"API_KEY": "`+secret+`"}}, "masterUserPassword": null,
+				"userData": "#!/bin/sh", "containerDefinitions": [{"environment": [{"name": "DB_PASSWORD", "value": "`+secret+`"},
+				{"name": "REGION", "value": "us-east-1"}]}], "runtime": "go1.x"}}`)
+	}
+
+	old, current := item("one"), item("two")
+	r.redactItems([]map[string]interface{}{old, current})
+
+	cur := current["Configuration"].(map[string]interface{})
+	key := cur["environment"].(map[string]interface{})["variables"].(map[string]interface{})["API_KEY"].(string)
+	env := cur["containerDefinitions"].([]interface{})[0].(map[string]interface{})["environment"].([]interface{})
+
+	for _, v := range []interface{}{key, cur["userData"], env[0].(map[string]interface{})["value"]} {
+		if s, _ := v.(string); !strings.HasPrefix(s, redactedPrefix) {
+			t.Errorf("redactItems() failed. Expected a redacted value, got: %v", v)
+		}
+	}
+
+	if cur["runtime"] != "go1.x" || env[1].(map[string]interface{})["value"] != "us-east-1" || cur["masterUserPassword"] != nil ||
+		current["ResourceId"] != "fn-password-rotator" {
+		t.Errorf("redactItems() failed. Expected other values to be kept, got: %v", current)
+	}
+
+	diffs := makeDiffs(old, current)
+	changes := itemChanges(map[string]interface{}{"diffs": diffs, "Configuration": current["Configuration"]})
+
+	if len(changes) != 2 || compactJSON(changes[0].Previous) == compactJSON(changes[0].Current) ||
+		strings.Contains(compactJSON(changes), "two") {
+		t.Errorf("makeDiffs() failed. Expected the redacted changes to be visible without their values, got: %v", changes)
+	}
+
+	if newRedactor(&config{RedactPatterns: []string{""}}) != nil {
+		t.Errorf("newRedactor() failed. Expected redaction to be disabled without patterns")
+	}
+}
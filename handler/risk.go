@@ -0,0 +1,405 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/expr-lang/expr/vm"
+)
+
+const (
+	severityKey      = "Severity"
+	risksKey         = "Risks"
+	defaultRulesFile = "rules/risk_rules.json"
+)
+
+// severity ... how risky a change is, from info to critical
+type severity int
+
+const (
+	severityInfo severity = iota
+	severityLow
+	severityMedium
+	severityHigh
+	severityCritical
+)
+
+var severityNames = []string{"info", "low", "medium", "high", "critical"}
+
+//go:embed rules/risk_rules.json
+var rulesFS embed.FS
+
+// String ... implements fmt.Stringer
+func (s severity) String() string {
+	if s < severityInfo || int(s) >= len(severityNames) {
+		return severityNames[severityInfo]
+	}
+
+	return severityNames[s]
+}
+
+// MarshalText ... implements encoding.TextMarshaler
+func (s severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText ... implements encoding.TextUnmarshaler
+func (s *severity) UnmarshalText(b []byte) (err error) {
+	*s, err = parseSeverity(string(b))
+	return err
+}
+
+func parseSeverity(s string) (severity, error) {
+	for n, name := range severityNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return severity(n), nil
+		}
+	}
+
+	return severityInfo, fmt.Errorf("unknown severity: %q", s)
+}
+
+// riskRule ... assigns a severity and reason to property changes matching the
+// resource type glob, the path, previous and current value expressions and
+// the condition, and whose previous value does not match previous_not. Values
+// are matched against their compact JSON encoding; the condition and message
+// are expr expressions over the policy variables
+type riskRule struct {
+	Name         string   `json:"name"`
+	ResourceType string   `json:"resource_type"`
+	Path         string   `json:"path"`
+	Previous     string   `json:"previous"`
+	PreviousNot  string   `json:"previous_not"`
+	Current      string   `json:"current"`
+	Condition    string   `json:"condition"`
+	Severity     severity `json:"severity"`
+	Reason       string   `json:"reason"`
+	Message      string   `json:"message"`
+
+	path        *regexp.Regexp
+	previous    *regexp.Regexp
+	previousNot *regexp.Regexp
+	current     *regexp.Regexp
+	condition   *vm.Program
+	message     *vm.Program
+}
+
+// riskRuleSet ... the JSON document holding the rules
+type riskRuleSet struct {
+	IncludeDefaults *bool      `json:"include_defaults"`
+	Rules           []riskRule `json:"rules"`
+}
+
+// riskFinding ... a rule matched by a property change
+type riskFinding struct {
+	Path     string   `json:"path"`
+	Severity severity `json:"severity"`
+	Rule     string   `json:"rule"`
+	Reason   string   `json:"reason"`
+}
+
+// compile ... validates the rule and compiles its expressions
+func (r *riskRule) compile() (err error) {
+	if _, err = path.Match(r.ResourceType, ""); err != nil {
+		return fmt.Errorf("rule %s: invalid resource_type: %v", r.Name, err)
+	}
+
+	for _, e := range []struct {
+		expr string
+		re   **regexp.Regexp
+	}{
+		{r.Path, &r.path},
+		{r.Previous, &r.previous},
+		{r.PreviousNot, &r.previousNot},
+		{r.Current, &r.current},
+	} {
+		if e.expr == "" {
+			continue
+		}
+
+		if *e.re, err = regexp.Compile(e.expr); err != nil {
+			return fmt.Errorf("rule %s: %v", r.Name, err)
+		}
+	}
+
+	if r.Condition != "" {
+		if r.condition, err = compilePolicy(r.Condition, reflect.Bool); err != nil {
+			return fmt.Errorf("rule %s: invalid condition: %v", r.Name, err)
+		}
+	}
+
+	if r.Message != "" {
+		if r.message, err = compilePolicy(r.Message, reflect.String); err != nil {
+			return fmt.Errorf("rule %s: invalid message: %v", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// match ... whether the change matches every matcher of the rule, and the
+// error evaluating its condition when the other matchers match
+func (r *riskRule) match(item map[string]interface{}, c propertyChange) (bool, error) {
+	if r.ResourceType != "" {
+		if ok, _ := path.Match(r.ResourceType, stringValue(item, "ResourceType")); !ok {
+			return false, nil
+		}
+	}
+
+	if r.path != nil && !r.path.MatchString(c.Path) {
+		return false, nil
+	}
+
+	if r.previous != nil && !r.previous.MatchString(compactJSON(c.Previous)) {
+		return false, nil
+	}
+
+	// a property that already matched, such as a security group that was
+	// already open, is not flagged again for every other edit
+	if r.previousNot != nil && r.previousNot.MatchString(compactJSON(c.Previous)) {
+		return false, nil
+	}
+
+	if r.current != nil && !r.current.MatchString(compactJSON(c.Current)) {
+		return false, nil
+	}
+
+	return r.evaluate(item, c)
+}
+
+func compactJSON(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ""
+	}
+
+	return string(b)
+}
+
+// parseRiskRules ... parses and compiles a rule set, appending the embedded
+// defaults unless include_defaults is false
+func parseRiskRules(b []byte, defaults []riskRule) ([]riskRule, error) {
+	var set riskRuleSet
+
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	for n := range set.Rules {
+		if err := set.Rules[n].compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	if set.IncludeDefaults == nil || *set.IncludeDefaults {
+		set.Rules = append(set.Rules, defaults...)
+	}
+
+	return set.Rules, nil
+}
+
+// defaultRiskRules ... the rules embedded in the binary
+func defaultRiskRules() []riskRule {
+	b, err := rulesFS.ReadFile(defaultRulesFile)
+	if err != nil {
+		log.Fatalf("error reading default risk rules: %v", err)
+	}
+
+	rules, err := parseRiskRules(b, nil)
+	if err != nil {
+		log.Fatalf("error parsing default risk rules: %v", err)
+	}
+
+	return rules
+}
+
+// loadRiskRules ... the default rules, extended or replaced by a rule set in S3
+func loadRiskRules(cfg *config, svc s3iface.S3API) ([]riskRule, error) {
+	defaults := defaultRiskRules()
+	if cfg.RiskRulesKey == "" {
+		return defaults, nil
+	}
+
+	bucket := cfg.RiskRulesBucket
+	if bucket == "" {
+		bucket = cfg.S3Bucket
+	}
+
+	s, err := getObject(svc, bucket, cfg.RiskRulesKey)
+	if err != nil {
+		return defaults, err
+	}
+
+	rules, err := parseRiskRules([]byte(s), defaults)
+	if err != nil {
+		return defaults, err
+	}
+
+	log.Printf("using risk rules s3://%s/%s\n", bucket, cfg.RiskRulesKey)
+
+	return rules, nil
+}
+
+// scoreItems ... runs every property change through the rules, recording the
+// findings under "Risks" and the highest severity under "Severity", then
+// orders the items by severity, most severe first. A change whose condition
+// cannot be evaluated is a finding of the rule, as it may be the risk the
+// rule looks for, and the first error of each rule is logged
+func scoreItems(items []map[string]interface{}, rules []riskRule) {
+	failed := make(map[string]bool)
+
+	for _, i := range items {
+		findings, highest := ruleFindings(i, rules, failed)
+
+		sort.SliceStable(findings, func(a, b int) bool {
+			return findings[a].Severity > findings[b].Severity
+		})
+
+		findings, highest = adjustSeverity(i, findings, highest)
+
+		i[severityKey] = highest.String()
+
+		if len(findings) > 0 {
+			i[risksKey] = findings
+		}
+	}
+
+	sort.SliceStable(items, func(a, b int) bool {
+		return itemSeverity(items[a]) > itemSeverity(items[b])
+	})
+}
+
+// ruleFindings ... the findings of the rules matching the changes of an item
+// and the highest severity among them, noting in failed the rules whose
+// condition could not be evaluated
+func ruleFindings(i map[string]interface{}, rules []riskRule, failed map[string]bool) ([]riskFinding, severity) {
+	var findings []riskFinding
+
+	highest := severityInfo
+
+	for _, c := range itemChanges(i) {
+		for n := range rules {
+			r := &rules[n]
+			if r.Severity == severityInfo {
+				continue
+			}
+
+			ok, err := r.match(i, c)
+			if err != nil {
+				if !failed[r.Name] {
+					log.Printf("error evaluating risk rule condition, flagging the change: %v\n", err)
+				}
+
+				failed[r.Name] = true
+			} else if !ok {
+				continue
+			}
+
+			reason := r.Reason + " (condition could not be evaluated)"
+			if err == nil {
+				reason = r.reason(i, c)
+			}
+
+			findings = append(findings, riskFinding{Path: c.Path, Severity: r.Severity, Rule: r.Name, Reason: reason})
+
+			if r.Severity > highest {
+				highest = r.Severity
+			}
+		}
+	}
+
+	return findings, highest
+}
+
+// adjustSeverity ... the severity of the rules' findings adjusted for the
+// maintenance window, change authorization and allowlist of the item
+func adjustSeverity(i map[string]interface{}, findings []riskFinding, highest severity) ([]riskFinding, severity) {
+	// changes expected during a maintenance window are one level less severe
+	if _, ok := i[maintenanceKey]; ok && highest > severityInfo {
+		highest--
+	}
+
+	// changes without an approved change record are escalated one level
+	if isUnauthorized(i) && highest < severityCritical {
+		highest++
+	}
+
+	// resources outside the allowlist are high severity whatever changed
+	if u := itemUnexpected(i); u != nil {
+		findings = append([]riskFinding{{Path: u.Property, Severity: severityHigh, Rule: unexpectedRule,
+			Reason: "Unexpected resource: " + u.Reason}}, findings...)
+
+		if highest < severityHigh {
+			highest = severityHigh
+		}
+	}
+
+	return findings, highest
+}
+
+// changeSeverity ... the highest severity of the rules matching a property
+// change, counting a rule whose condition cannot be evaluated as matching
+func changeSeverity(item map[string]interface{}, c propertyChange, rules []riskRule) severity {
+	highest := severityInfo
+
+	for n := range rules {
+		r := &rules[n]
+		if r.Severity <= highest {
+			continue
+		}
+
+		if ok, err := r.match(item, c); ok || err != nil {
+			highest = r.Severity
+		}
+	}
+
+	return highest
+}
+
+// itemSeverity ... the severity assigned to an item by scoreItems
+func itemSeverity(i map[string]interface{}) severity {
+	s, _ := parseSeverity(stringValue(i, severityKey))
+	return s
+}
+
+// itemRisks ... the findings of an item, whether set by scoreItems or read back from JSON
+func itemRisks(i map[string]interface{}) []riskFinding {
+	var findings []riskFinding
+
+	switch v := i[risksKey].(type) {
+	case []riskFinding:
+		return v
+	case []interface{}:
+		decodeItemValue(v, &findings)
+	}
+
+	return findings
+}
+
+// maxSeverity ... the highest severity of a set of items
+func maxSeverity(items []map[string]interface{}) severity {
+	highest := severityInfo
+
+	for _, i := range items {
+		if s := itemSeverity(i); s > highest {
+			highest = s
+		}
+	}
+
+	return highest
+}
+
+// decodeItemValue ... converts a value unmarshaled into interface{} to a typed value
+func decodeItemValue(v interface{}, out interface{}) bool {
+	b, err := json.Marshal(v)
+
+	return err == nil && json.Unmarshal(b, out) == nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// test functions //
+func TestDefaultRiskRules(t *testing.T) {
+	tt := map[string]struct {
+		item     map[string]interface{}
+		expected severity
+		rule     string
+	}{
+		"ebs encryption disabled": {
+			item:     configTestItem(t, "AWS::EC2::Volume", `{"encrypted": true}`, `{"encrypted": false}`),
+			expected: severityHigh,
+			rule:     "encryption-disabled",
+		},
+		"iam star star": {
+			item: configTestItem(t, "AWS::IAM::Policy", `{"policyVersionList": [{"document": {"Statement": []}}]}`,
+				`{"policyVersionList": [{"document": {"Statement": [{"Effect": "Allow", "Action": "*", "Resource": "*"}]}}]}`),
+			expected: severityCritical,
+			rule:     "iam-admin-policy",
+		},
+		"security group opened": {
+			item: configTestItem(t, "AWS::EC2::SecurityGroup", `{"ipPermissions": [{"ipv4Ranges": [{"cidrIp": "10.0.0.0/8"}]}]}`,
+				`{"ipPermissions": [{"ipv4Ranges": [{"cidrIp": "0.0.0.0/0"}]}]}`),
+			expected: severityHigh,
+			rule:     "security-group-open-ingress",
+		},
+		"security group narrowed": {
+			item: configTestItem(t, "AWS::EC2::SecurityGroup", `{"ipPermissions": [{"ipv4Ranges": [{"cidrIp": "0.0.0.0/0"}]}]}`,
+				`{"ipPermissions": [{"ipv4Ranges": [{"cidrIp": "10.0.0.0/8"}]}]}`),
+			expected: severityInfo,
+		},
+		"unremarkable change": {
+			item:     configTestItem(t, "AWS::Lambda::Function", `{"memorySize": 128}`, `{"memorySize": 256}`),
+			expected: severityInfo,
+		},
+		"public access block removed": {
+			item: changedTestItem(t,
+				`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs",
+					"SupplementaryConfiguration": {"PublicAccessBlockConfiguration": {"blockPublicAcls": true}}}`,
+				`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs",
+					"SupplementaryConfiguration": {"PublicAccessBlockConfiguration": {"blockPublicAcls": false}}}`),
+			expected: severityHigh,
+			rule:     "s3-public-access-block-removed",
+		},
+		"public access block deleted": {
+			item: changedTestItem(t,
+				`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs",
+					"SupplementaryConfiguration": {"PublicAccessBlockConfiguration": {"blockPublicAcls": true}}}`,
+				`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs", "SupplementaryConfiguration": {}}`),
+			expected: severityHigh,
+			rule:     "s3-public-access-block-removed",
+		},
+		"bucket encryption deleted": {
+			item: changedTestItem(t,
+				`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs",
+					"SupplementaryConfiguration": {"ServerSideEncryptionConfiguration": {"rules": [{"sseAlgorithm": "AES256"}]}}}`,
+				`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "logs",
+					"SupplementaryConfiguration": {"ServerSideEncryptionConfiguration": null}}`),
+			expected: severityHigh,
+			rule:     "encryption-disabled",
+		},
+		"security group already open": {
+			item: changedTestItem(t,
+				`{"ResourceType": "AWS::EC2::SecurityGroup", "ResourceId": "sg-1", "Configuration": {"ipPermissions": [
+					{"fromPort": 443, "ipv4Ranges": [{"cidrIp": "0.0.0.0/0"}]}]}}`,
+				`{"ResourceType": "AWS::EC2::SecurityGroup", "ResourceId": "sg-1", "Configuration": {"ipPermissions": [
+					{"fromPort": 443, "ipv4Ranges": [{"cidrIp": "0.0.0.0/0"}]},
+					{"fromPort": 5432, "ipv4Ranges": [{"cidrIp": "10.0.0.0/8"}]}]}}`),
+			expected: severityInfo,
+		},
+		"bucket policy already public": {
+			item: changedTestItem(t,
+				`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "site", "SupplementaryConfiguration": {"BucketPolicy":
+					{"policyText": {"Statement": [{"Principal": "*", "Action": "s3:GetObject"}]}}}}`,
+				`{"ResourceType": "AWS::S3::Bucket", "ResourceId": "site", "SupplementaryConfiguration": {"BucketPolicy":
+					{"policyText": {"Statement": [{"Principal": "*", "Action": ["s3:GetObject", "s3:ListBucket"]}]}}}}`),
+			expected: severityMedium,
+			rule:     "s3-bucket-policy-changed",
+		},
+	}
+
+	rules := defaultRiskRules()
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			scoreItems([]map[string]interface{}{tc.item}, rules)
+
+			if s := itemSeverity(tc.item); s != tc.expected {
+				t.Errorf("scoreItems() failed. Expected severity %s, got: %s (%v)", tc.expected, s, tc.item[risksKey])
+			}
+
+			risks := itemRisks(tc.item)
+			if tc.rule == "" {
+				if len(risks) != 0 {
+					t.Errorf("scoreItems() failed. Expected no findings, got: %v", risks)
+				}
+
+				return
+			}
+
+			if len(risks) == 0 || risks[0].Rule != tc.rule || risks[0].Reason == "" {
+				t.Errorf("scoreItems() failed. Expected rule %s first, got: %v", tc.rule, risks)
+			}
+		})
+	}
+}
+
+func TestScoreItemsOrdering(t *testing.T) {
+	low := configTestItem(t, "AWS::Lambda::Function", `{"a": 1}`, `{"a": 2}`)
+	high := configTestItem(t, "AWS::RDS::DBInstance", `{"storageEncrypted": true}`, `{"storageEncrypted": false}`)
+	items := []map[string]interface{}{low, high}
+
+	scoreItems(items, defaultRiskRules())
+
+	if items[0]["ResourceType"] != "AWS::RDS::DBInstance" {
+		t.Errorf("scoreItems() failed. Expected the high severity item first, got: %v", items[0]["ResourceType"])
+	}
+
+	if s := maxSeverity(items); s != severityHigh {
+		t.Errorf("maxSeverity() failed. Expected high, got: %s", s)
+	}
+
+	text, err := itemsToText(items, 0)
+	chkErr(t, err)
+
+	if !strings.Contains(text, "[high] encryption disabled (Configuration.storageEncrypted)") {
+		t.Errorf("itemsToText() failed. Expected finding in:\n%s", text)
+	}
+
+	h, err := parseItemsToHTML(items)
+	chkErr(t, err)
+
+	if !strings.Contains(h, `class="resource sev-high"`) || !strings.Contains(h, `<th class="sev-high">high</th>`) {
+		t.Errorf("parseItemsToHTML() failed. Expected high severity highlighting in:\n%s", h)
+	}
+
+	if lines := summaryLines(items); !strings.HasPrefix(lines[0], "[HIGH] ") {
+		t.Errorf("summaryLines() failed. Expected severity tag, got: %v", lines)
+	}
+}
+
+func TestParseRiskRules(t *testing.T) {
+	defaults := defaultRiskRules()
+	tt := map[string]struct {
+		rules       string
+		expected    int
+		expectedErr bool
+	}{
+		"custom with defaults": {
+			rules:    `{"rules": [{"name": "tags", "path": "^Tags$", "severity": "low", "reason": "tags changed"}]}`,
+			expected: len(defaults) + 1,
+		},
+		"custom only": {
+			rules:    `{"include_defaults": false, "rules": [{"name": "tags", "path": "^Tags$", "severity": "low"}]}`,
+			expected: 1,
+		},
+		"unknown severity": {
+			rules:       `{"rules": [{"name": "bad", "severity": "severe"}]}`,
+			expectedErr: true,
+		},
+		"invalid expression": {
+			rules:       `{"rules": [{"name": "bad", "path": "(", "severity": "low"}]}`,
+			expectedErr: true,
+		},
+		"invalid glob": {
+			rules:       `{"rules": [{"name": "bad", "resource_type": "[", "severity": "low"}]}`,
+			expectedErr: true,
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			rules, err := parseRiskRules([]byte(tc.rules), defaults)
+			if (err != nil) != tc.expectedErr {
+				t.Fatalf("parseRiskRules() failed. Expected error: %v, got: %v", tc.expectedErr, err)
+			}
+
+			if !tc.expectedErr && len(rules) != tc.expected {
+				t.Errorf("parseRiskRules() failed. Expected %d rules, got: %d", tc.expected, len(rules))
+			}
+		})
+	}
+}
+
+func TestLoadRiskRules(t *testing.T) {
+	m := &mockS3{Puts: map[string][]byte{
+		"rules.json": []byte(`{"include_defaults": false, "rules": [{"name": "tags", "path": "^Tags$", "severity": "medium"}]}`),
+	}}
+
+	rules, err := loadRiskRules(&config{S3Bucket: "bucket", RiskRulesKey: "rules.json"}, m)
+	chkErr(t, err)
+
+	if len(rules) != 1 || rules[0].Severity != severityMedium {
+		t.Errorf("loadRiskRules() failed. Unexpected rules: %+v", rules)
+	}
+
+	rules, err = loadRiskRules(&config{S3Bucket: "bucket"}, m)
+	chkErr(t, err)
+
+	if len(rules) != len(defaultRiskRules()) {
+		t.Errorf("loadRiskRules() failed. Expected the default rules, got: %d", len(rules))
+	}
+}
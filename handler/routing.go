@@ -0,0 +1,419 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Which changes the default notifiers receive when routing rules are configured
+const (
+	routeDefaultAll       = "all"
+	routeDefaultUnmatched = "unmatched"
+	routeDefaultNone      = "none"
+)
+
+// routeRule ... sends changes matching every given condition to the rule's
+// recipients and sinks. Resource types, accounts, regions and tag values are globs
+type routeRule struct {
+	Name            string            `json:"name"`
+	ResourceTypes   []string          `json:"resource_types"`
+	Accounts        []string          `json:"accounts"`
+	Regions         []string          `json:"regions"`
+	Tags            map[string]string `json:"tags"`
+	MinSeverity     severity          `json:"min_severity"`
+	Recipients      []string          `json:"recipients"`
+	Notifiers       []string          `json:"notifiers"`
+	SNSTopicArn     string            `json:"sns_topic_arn"`
+	SlackWebhookURL string            `json:"slack_webhook_url"`
+	TeamsWebhookURL string            `json:"teams_webhook_url"`
+	WebhookURL      string            `json:"webhook_url"`
+	EventBusName    string            `json:"event_bus_name"`
+}
+
+// routeRuleSet ... the JSON document holding the routing rules
+type routeRuleSet struct {
+	Default string      `json:"default"`
+	Rules   []routeRule `json:"rules"`
+}
+
+// destination ... a single notification target: an email address for ses,
+// otherwise the topic ARN, webhook URL or event bus of the sink
+type destination struct {
+	Notifier string
+	Target   string
+}
+
+// route ... a notifier and the subset of changes and unexpected resources it receives
+type route struct {
+	Notifier   Notifier
+	Items      []map[string]interface{}
+	Unexpected []unexpectedResource
+}
+
+// parseRouteRules ... parses and validates a routing rule set
+func parseRouteRules(b []byte) (set routeRuleSet, err error) {
+	if err = json.Unmarshal(b, &set); err != nil {
+		return set, err
+	}
+
+	switch set.Default {
+	case "":
+		set.Default = routeDefaultUnmatched
+	case routeDefaultAll, routeDefaultUnmatched, routeDefaultNone:
+	default:
+		return set, fmt.Errorf("unknown routing default: %q", set.Default)
+	}
+
+	for _, r := range set.Rules {
+		var patterns []string
+
+		patterns = append(patterns, r.ResourceTypes...)
+		patterns = append(patterns, r.Accounts...)
+		patterns = append(patterns, r.Regions...)
+
+		for _, v := range r.Tags {
+			patterns = append(patterns, v)
+		}
+
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return set, fmt.Errorf("routing rule %s: invalid pattern %q: %v", r.Name, p, err)
+			}
+		}
+
+		if len(r.Recipients) == 0 && len(r.Notifiers) == 0 {
+			return set, fmt.Errorf("routing rule %s: requires recipients or notifiers", r.Name)
+		}
+	}
+
+	return set, nil
+}
+
+// loadRouteRules ... reads the routing rules from S3, returning nil when routing is not configured
+func loadRouteRules(cfg *config, svc s3iface.S3API) (*routeRuleSet, error) {
+	if cfg.RoutingRulesKey == "" {
+		return nil, nil
+	}
+
+	bucket := cfg.RoutingRulesBucket
+	if bucket == "" {
+		bucket = cfg.S3Bucket
+	}
+
+	s, err := getObject(svc, bucket, cfg.RoutingRulesKey)
+	if err != nil {
+		return nil, err
+	}
+
+	set, err := parseRouteRules([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("using routing rules s3://%s/%s\n", bucket, cfg.RoutingRulesKey)
+
+	return &set, nil
+}
+
+// matches ... whether the item satisfies every condition of the rule
+func (r *routeRule) matches(item map[string]interface{}) bool {
+	if !matchAny(r.ResourceTypes, stringValue(item, "ResourceType")) ||
+		!matchAny(r.Accounts, stringValue(item, "AccountId")) ||
+		!matchAny(r.Regions, stringValue(item, "AwsRegion")) {
+		return false
+	}
+
+	tags, _ := item["Tags"].(map[string]interface{})
+
+	for k, pattern := range r.Tags {
+		v, _ := tags[k].(string)
+		if ok, _ := path.Match(pattern, v); !ok {
+			return false
+		}
+	}
+
+	return itemSeverity(item) >= r.MinSeverity
+}
+
+// matchAny ... whether the value matches one of the patterns, or there are none
+func matchAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// destinations ... the targets of a rule, using the configured sink targets
+// for notifiers the rule does not override
+func (r *routeRule) destinations(cfg *config) []destination {
+	c := *cfg
+	c.Recipients = r.Recipients
+
+	for _, v := range []struct {
+		override string
+		target   *string
+	}{
+		{r.SNSTopicArn, &c.SNSTopicArn},
+		{r.SlackWebhookURL, &c.SlackWebhookURL},
+		{r.TeamsWebhookURL, &c.TeamsWebhookURL},
+		{r.WebhookURL, &c.WebhookURL},
+		{r.EventBusName, &c.EventBusName},
+	} {
+		if v.override != "" {
+			*v.target = v.override
+		}
+	}
+
+	notifiers := r.Notifiers
+	if len(notifiers) == 0 {
+		notifiers = []string{notifierSES}
+	}
+
+	c.Notifiers = notifiers
+
+	return configDestinations(&c)
+}
+
+// configDestinations ... the targets of the configured notifiers
+func configDestinations(cfg *config) []destination {
+	var dests []destination
+
+	for _, name := range cfg.Notifiers {
+		name = strings.ToLower(strings.TrimSpace(name))
+
+		switch name {
+		case notifierSES:
+			for _, addr := range cfg.Recipients {
+				dests = append(dests, destination{Notifier: name, Target: addr})
+			}
+		case notifierSNS:
+			dests = append(dests, destination{Notifier: name, Target: cfg.SNSTopicArn})
+		case notifierSlack:
+			dests = append(dests, destination{Notifier: name, Target: cfg.SlackWebhookURL})
+		case notifierTeams:
+			dests = append(dests, destination{Notifier: name, Target: cfg.TeamsWebhookURL})
+		case notifierWebhook:
+			dests = append(dests, destination{Notifier: name, Target: cfg.WebhookURL})
+		case notifierEventBridge:
+			dests = append(dests, destination{Notifier: name, Target: cfg.EventBusName})
+		case notifierSecurityHub:
+			// findings are imported into the account's own Security Hub
+			dests = append(dests, destination{Notifier: name})
+		case "":
+		default:
+			// rejected by newNotifier when the routes are created
+			dests = append(dests, destination{Notifier: name})
+		}
+	}
+
+	return dests
+}
+
+// routeItems ... the indexes of the items each destination receives, so every
+// recipient gets one report containing only their changes
+func routeItems(items []map[string]interface{}, set *routeRuleSet, cfg *config) map[destination][]int {
+	routed := make(map[destination][]int)
+	defaults := configDestinations(cfg)
+
+	for n, i := range items {
+		seen := make(map[destination]bool)
+		matched := false
+
+		add := func(dests []destination) {
+			for _, d := range dests {
+				if !seen[d] {
+					seen[d] = true
+					routed[d] = append(routed[d], n)
+				}
+			}
+		}
+
+		for r := range set.Rules {
+			if set.Rules[r].matches(i) {
+				matched = true
+
+				add(set.Rules[r].destinations(cfg))
+			}
+		}
+
+		if set.Default == routeDefaultAll || (set.Default == routeDefaultUnmatched && !matched) {
+			add(defaults)
+		}
+	}
+
+	return routed
+}
+
+// newRoutes ... creates a notifier for each destination, combining email
+// recipients who receive the same changes into a single message. Unexpected
+// resources are routed like high severity changes, and those no rule or
+// default routes are sent to the configured notifiers
+func newRoutes(
+	items []map[string]interface{},
+	unexpected []unexpectedResource,
+	set *routeRuleSet,
+	cfg *config,
+	sess client.ConfigProvider) ([]route, error) {
+	all := append([]map[string]interface{}{}, items...)
+	for _, u := range unexpected {
+		all = append(all, u.item())
+	}
+
+	routed := routeItems(all, set, cfg)
+	if err := routeUnexpected(routed, len(items), len(unexpected), cfg); err != nil {
+		return nil, err
+	}
+
+	emails := make(map[string][]string)
+
+	dests := make([]destination, 0, len(routed))
+	for d := range routed {
+		dests = append(dests, d)
+	}
+
+	sort.Slice(dests, func(a, b int) bool {
+		if dests[a].Notifier != dests[b].Notifier {
+			return dests[a].Notifier < dests[b].Notifier
+		}
+
+		return dests[a].Target < dests[b].Target
+	})
+
+	var routes []route
+
+	for _, d := range dests {
+		key := fmt.Sprint(routed[d])
+
+		if d.Notifier == notifierSES {
+			emails[key] = append(emails[key], d.Target)
+			continue
+		}
+
+		c := *cfg
+		c.SNSTopicArn, c.SlackWebhookURL, c.TeamsWebhookURL, c.WebhookURL = d.Target, d.Target, d.Target, d.Target
+		c.EventBusName = d.Target
+
+		n, err := newNotifier(d.Notifier, &c, sess)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, newRoute(n, items, unexpected, routed[d]))
+	}
+
+	keys := make([]string, 0, len(emails))
+	for k := range emails {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		c := *cfg
+		c.Recipients = emails[k]
+
+		n, err := newNotifier(notifierSES, &c, sess)
+		if err != nil {
+			return nil, err
+		}
+
+		routes = append(routes, newRoute(n, items, unexpected, routed[destination{notifierSES, emails[k][0]}]))
+	}
+
+	return routes, nil
+}
+
+// routeUnexpected ... adds the unexpected resources, indexed after the n
+// changes, that no destination receives to the configured notifiers, so an
+// allowlist finding is never dropped by the routing rules
+func routeUnexpected(routed map[destination][]int, n, unexpected int, cfg *config) error {
+	received := make(map[int]bool)
+
+	for _, indexes := range routed {
+		for _, i := range indexes {
+			received[i] = true
+		}
+	}
+
+	dests := configDestinations(cfg)
+
+	for i := n; i < n+unexpected; i++ {
+		if received[i] {
+			continue
+		}
+
+		if len(dests) == 0 {
+			return errors.New("no route or notifier configured for unexpected resources")
+		}
+
+		for _, d := range dests {
+			routed[d] = append(routed[d], i)
+			sort.Ints(routed[d])
+		}
+	}
+
+	return nil
+}
+
+// newRoute ... the route of a notifier receiving the changes and unexpected
+// resources at the indexes, the unexpected resources following the changes
+func newRoute(n Notifier, items []map[string]interface{}, unexpected []unexpectedResource, indexes []int) route {
+	rt := route{Notifier: n}
+
+	for _, i := range indexes {
+		if i < len(items) {
+			rt.Items = append(rt.Items, items[i])
+		} else {
+			rt.Unexpected = append(rt.Unexpected, unexpected[i-len(items)])
+		}
+	}
+
+	return rt
+}
+
+func selectItems(items []map[string]interface{}, indexes []int) []map[string]interface{} {
+	selected := make([]map[string]interface{}, 0, len(indexes))
+
+	for _, n := range indexes {
+		selected = append(selected, items[n])
+	}
+
+	return selected
+}
+
+// notifyRoutes ... sends each route a copy of the report holding only its
+// changes and unexpected resources, returning the first error after all routes have been attempted.
+// The archived report holds every change, so only routes sent every change
+// link to it
+func notifyRoutes(routes []route, r *report) (err error) {
+	for n, rt := range routes {
+		sub := *r
+		sub.Items, sub.Unexpected = rt.Items, rt.Unexpected
+
+		if len(rt.Items) != len(r.Items) || len(rt.Unexpected) != len(r.Unexpected) {
+			sub.ArchiveURL = ""
+			sub.Route = n + 1
+		}
+
+		if e := notify([]Notifier{rt.Notifier}, &sub); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+type mockNotifier struct {
+	Reports []report
+}
+
+func (m *mockNotifier) Notify(r *report) error {
+	m.Reports = append(m.Reports, *r)
+
+	return nil
+}
+
+// helper functions //
+func routingTestItems() []map[string]interface{} {
+	return []map[string]interface{}{
+		{
+			"ResourceId":   "sg-1",
+			"ResourceType": "AWS::EC2::SecurityGroup",
+			"AccountId":    "111111111111",
+			"AwsRegion":    "us-east-1",
+			"Tags":         map[string]interface{}{"Team": "network"},
+			severityKey:    "high",
+		},
+		{
+			"ResourceId":   "fn-1",
+			"ResourceType": "AWS::Lambda::Function",
+			"AccountId":    "111111111111",
+			"AwsRegion":    "us-west-2",
+			"Tags":         map[string]interface{}{"Team": "apps"},
+			severityKey:    "info",
+		},
+		{
+			"ResourceId":   "vpc-1",
+			"ResourceType": "AWS::EC2::VPC",
+			"AccountId":    "222222222222",
+			"AwsRegion":    "us-east-1",
+			severityKey:    "low",
+		},
+	}
+}
+
+// test functions //
+func TestRouteItems(t *testing.T) {
+	cfg := &config{
+		Notifiers:       []string{"ses"},
+		Sender:          "differ@example.com",
+		Recipients:      []string{"ops@example.com"},
+		SlackWebhookURL: "https://example.com/slack",
+	}
+	network := routeRule{Name: "network", ResourceTypes: []string{"AWS::EC2::*"}, Recipients: []string{"net@example.com"}}
+	tt := map[string]struct {
+		rules    string
+		expected map[destination][]int
+	}{
+		"by type, default unmatched": {
+			rules: `{"rules": [{"name": "network", "resource_types": ["AWS::EC2::*"], "recipients": ["net@example.com"]}]}`,
+			expected: map[destination][]int{
+				{notifierSES, "net@example.com"}: {0, 2},
+				{notifierSES, "ops@example.com"}: {1},
+			},
+		},
+		"by tag and severity to slack, default all": {
+			rules: `{"default": "all", "rules": [{"name": "network", "tags": {"Team": "net*"}, "min_severity": "high",` +
+				` "notifiers": ["slack"]}]}`,
+			expected: map[destination][]int{
+				{notifierSlack, "https://example.com/slack"}: {0},
+				{notifierSES, "ops@example.com"}:             {0, 1, 2},
+			},
+		},
+		"by account and region with override, default none": {
+			rules: `{"default": "none", "rules": [` +
+				`{"name": "a", "accounts": ["111111111111"], "regions": ["us-west-*"], "notifiers": ["webhook"], "webhook_url": "https://example.com/a"},` +
+				`{"name": "b", "accounts": ["222222222222"], "recipients": ["b@example.com", "ops@example.com"]}]}`,
+			expected: map[destination][]int{
+				{notifierWebhook, "https://example.com/a"}: {1},
+				{notifierSES, "b@example.com"}:             {2},
+				{notifierSES, "ops@example.com"}:           {2},
+			},
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			set, err := parseRouteRules([]byte(tc.rules))
+			chkErr(t, err)
+
+			routed := routeItems(routingTestItems(), &set, cfg)
+			if len(routed) != len(tc.expected) {
+				t.Fatalf("routeItems() failed. Expected: %v\nGot: %v", tc.expected, routed)
+			}
+
+			for d, expected := range tc.expected {
+				if got := routed[d]; !reflect.DeepEqual(got, expected) {
+					t.Errorf("routeItems() failed. Expected %v for %v, got: %v", expected, d, got)
+				}
+			}
+		})
+	}
+
+	if !network.matches(routingTestItems()[0]) || network.matches(routingTestItems()[1]) {
+		t.Errorf("matches() failed. Expected only EC2 resources to match")
+	}
+}
+
+func TestNewRoutes(t *testing.T) {
+	cfg := &config{
+		Notifiers:  []string{"ses"},
+		Sender:     "differ@example.com",
+		Recipients: []string{"ops@example.com"},
+	}
+	set, err := parseRouteRules([]byte(`{"default": "all", "rules": [` +
+		`{"name": "network", "resource_types": ["AWS::EC2::*"], "recipients": ["net@example.com", "sec@example.com"]},` +
+		`{"name": "apps", "resource_types": ["AWS::Lambda::*"], "notifiers": ["teams"], "teams_webhook_url": "https://example.com/teams"}]}`))
+	chkErr(t, err)
+
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+
+	routes, err := newRoutes(routingTestItems(), nil, &set, cfg, sess)
+	chkErr(t, err)
+
+	// teams, one email to net and sec, one email to ops
+	if len(routes) != 3 {
+		t.Fatalf("newRoutes() failed. Expected 3 routes, got: %d", len(routes))
+	}
+
+	if n, ok := routes[0].Notifier.(*TeamsNotifier); !ok || n.URL != "https://example.com/teams" || len(routes[0].Items) != 1 {
+		t.Errorf("newRoutes() failed. Unexpected teams route: %+v", routes[0])
+	}
+
+	expected := map[string]int{"net@example.com,sec@example.com": 2, "ops@example.com": 3}
+
+	for _, rt := range routes[1:] {
+		n, ok := rt.Notifier.(*SESNotifier)
+		if !ok {
+			t.Fatalf("newRoutes() failed. Expected SES notifier, got: %T", rt.Notifier)
+		}
+
+		key := strings.Join(n.Config.Recipients, ",")
+		if expected[key] != len(rt.Items) {
+			t.Errorf("newRoutes() failed. Expected %d items for %s, got: %d", expected[key], key, len(rt.Items))
+		}
+	}
+}
+
+func TestNewRoutesUnexpected(t *testing.T) {
+	cfg := &config{
+		Notifiers:  []string{"ses"},
+		Sender:     "differ@example.com",
+		Recipients: []string{"ops@example.com"},
+	}
+	set, err := parseRouteRules([]byte(`{"default": "none", "rules": [` +
+		`{"name": "network", "resource_types": ["AWS::EC2::*"], "recipients": ["net@example.com"]}]}`))
+	chkErr(t, err)
+
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String("us-east-1")}))
+	unexpected := []unexpectedResource{
+		{ResourceType: "AWS::EC2::Instance", ResourceID: "i-1", AccountID: "111111111111", Region: "us-east-1"},
+		{ResourceType: "AWS::S3::Bucket", ResourceID: "b-1", AccountID: "111111111111", Region: "us-east-1"},
+	}
+
+	// without changes, the network rule receives the instance and the
+	// bucket no rule matches goes to the configured notifiers
+	routes, err := newRoutes(nil, unexpected, &set, cfg, sess)
+	chkErr(t, err)
+
+	expected := map[string]string{"net@example.com": "i-1", "ops@example.com": "b-1"}
+	if len(routes) != len(expected) {
+		t.Fatalf("newRoutes() failed. Expected %d routes, got: %+v", len(expected), routes)
+	}
+
+	for _, rt := range routes {
+		key := strings.Join(rt.Notifier.(*SESNotifier).Config.Recipients, ",")
+		if len(rt.Items) != 0 || len(rt.Unexpected) != 1 || rt.Unexpected[0].ResourceID != expected[key] {
+			t.Errorf("newRoutes() failed. Expected %s to receive only %s, got: %+v", key, expected[key], rt)
+		}
+	}
+
+	if _, err := newRoutes(nil, unexpected, &set, &config{}, sess); err == nil {
+		t.Errorf("newRoutes() failed. Expected error for unexpected resources without a route or notifier")
+	}
+}
+
+func TestParseRouteRules(t *testing.T) {
+	tt := map[string]string{
+		"unknown default":  `{"default": "some", "rules": []}`,
+		"invalid pattern":  `{"rules": [{"name": "bad", "regions": ["["], "recipients": ["a@b.c"]}]}`,
+		"no destination":   `{"rules": [{"name": "bad", "regions": ["us-*"]}]}`,
+		"unknown severity": `{"rules": [{"name": "bad", "min_severity": "urgent", "recipients": ["a@b.c"]}]}`,
+	}
+
+	for name, rules := range tt {
+		rules := rules
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseRouteRules([]byte(rules)); err == nil {
+				t.Errorf("parseRouteRules() failed. Expected error")
+			}
+		})
+	}
+}
+
+func TestNotifyRoutes(t *testing.T) {
+	items := routingTestItems()
+	all, network := &mockNotifier{}, &mockNotifier{}
+	r := &report{Items: items, ArchiveURL: "https://archive/report.html"}
+
+	r.Unexpected = []unexpectedResource{{ResourceID: "i-1"}}
+
+	chkErr(t, notifyRoutes([]route{{Notifier: all, Items: items, Unexpected: r.Unexpected}, {Notifier: network, Items: items[:1]}}, r))
+
+	if len(all.Reports) != 1 || all.Reports[0].ArchiveURL != r.ArchiveURL || all.Reports[0].Route != 0 {
+		t.Errorf("notifyRoutes() failed. Expected the full report linked to the archive, got: %+v", all.Reports)
+	}
+
+	if len(network.Reports) != 1 || network.Reports[0].ArchiveURL != "" || network.Reports[0].Route != 2 ||
+		len(network.Reports[0].Items) != 1 || len(network.Reports[0].Unexpected) != 0 {
+		t.Errorf("notifyRoutes() failed. Expected the route's part without the archive link, got: %+v", network.Reports)
+	}
+
+	m := &mockS3{}
+	cfg := &config{S3Bucket: "logging", ArchivePrefix: "reports", ArchiveBucket: "archive"}
+
+	u, err := uploadFullReport(&network.Reports[0], "<p>sg-1</p>", "sg-1", []byte("[]"), m, cfg)
+	chkErr(t, err)
+
+	if _, ok := m.Puts["reports/oversized/0001/01/01/00010101T000000Z/route-2/report.html"]; !ok || !strings.Contains(u, "route-2") {
+		t.Errorf("uploadFullReport() failed. Expected the route's part uploaded separately, got: %s, %v", u, m.Puts)
+	}
+}
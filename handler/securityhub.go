@@ -0,0 +1,404 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+	"github.com/aws/aws-sdk-go/service/securityhub/securityhubiface"
+)
+
+const (
+	asffSchemaVersion = "2018-10-08"
+	asffGenerator     = "grace-config-differ"
+	asffBatchSize     = 100 // findings accepted by one BatchImportFindings call
+	asffMaxTitle      = 256
+	asffMaxText       = 1024
+)
+
+// What the Security Hub notifier sends: a finding for each changed resource,
+// or for each high or critical risk finding and unexpected resource
+const (
+	securityHubChanges  = "changes"
+	securityHubFindings = "findings"
+)
+
+// asffSeverities ... the ASFF severity label of each severity
+var asffSeverities = map[severity]string{
+	severityInfo:     securityhub.SeverityLabelInformational,
+	severityLow:      securityhub.SeverityLabelLow,
+	severityMedium:   securityhub.SeverityLabelMedium,
+	severityHigh:     securityhub.SeverityLabelHigh,
+	severityCritical: securityhub.SeverityLabelCritical,
+}
+
+// SecurityHubNotifier ... imports the report into Security Hub as AWS Security
+// Finding Format records, in batches
+type SecurityHubNotifier struct {
+	Client     securityhubiface.SecurityHubAPI
+	Mode       string
+	ProductArn string
+	Region     string
+}
+
+// Notify ... implements Notifier for Security Hub
+func (n *SecurityHubNotifier) Notify(r *report) error {
+	b := asffBuilder{Mode: n.Mode, ProductArn: n.ProductArn, Region: n.Region, Now: time.Now().UTC()}
+	findings := b.findings(r)
+
+	var failed []string
+
+	for start := 0; start < len(findings); start += asffBatchSize {
+		end := start + asffBatchSize
+		if end > len(findings) {
+			end = len(findings)
+		}
+
+		out, err := n.Client.BatchImportFindings(&securityhub.BatchImportFindingsInput{Findings: findings[start:end]})
+		if err != nil {
+			return err
+		}
+
+		for _, f := range out.FailedFindings {
+			failed = append(failed, fmt.Sprintf("%s: %s", aws.StringValue(f.Id), aws.StringValue(f.ErrorMessage)))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("security hub rejected %d of %d findings: %s", len(failed), len(findings), strings.Join(failed, "; "))
+	}
+
+	log.Printf("Imported %d findings into Security Hub\n", len(findings))
+
+	return nil
+}
+
+// asffBuilder ... converts reports to ASFF records. IDs are derived from the
+// resource and the change or rule, so importing the same finding again
+// updates it rather than adding a duplicate
+type asffBuilder struct {
+	Mode       string
+	ProductArn string // the custom integration's product ARN, the account's default when empty
+	Region     string
+	Now        time.Time
+}
+
+// findings ... the ASFF records of the report
+func (b *asffBuilder) findings(r *report) []*securityhub.AwsSecurityFinding {
+	var findings []*securityhub.AwsSecurityFinding
+
+	for _, i := range r.Items {
+		if b.Mode != securityHubFindings {
+			findings = append(findings, b.changeFinding(r, i))
+			continue
+		}
+
+		for _, f := range itemRisks(i) {
+			if f.Severity >= severityHigh {
+				findings = append(findings, b.riskFinding(r, i, f))
+			}
+		}
+	}
+
+	if b.Mode == securityHubFindings {
+		for n := range r.Unexpected {
+			// changed resources were flagged by their risk finding
+			if u := &r.Unexpected[n]; !u.Changed {
+				findings = append(findings, b.unexpectedFinding(r, u))
+			}
+		}
+	}
+
+	return findings
+}
+
+// changeFinding ... a record of one resource change, updated if the same
+// configuration state is reported again
+func (b *asffBuilder) changeFinding(r *report, i map[string]interface{}) *securityhub.AwsSecurityFinding {
+	state := stringValue(i, "ConfigurationStateId")
+	if state == "" {
+		state = stringValue(i, captureTimeKey)
+	}
+
+	label, resourceType := resourceLabel(i), stringValue(i, "ResourceType")
+	paths := changedPaths(i)
+
+	description := fmt.Sprintf("%s %s was %s.", resourceType, label, changeKind(i))
+	if len(paths) > 0 {
+		description += " Changed properties: " + strings.Join(paths, ", ")
+	}
+
+	f := b.base(i, "change/"+state, "change")
+	f.Title = aws.String(truncate(fmt.Sprintf("Configuration change to %s (%s)", label, resourceType), asffMaxTitle))
+	f.Description = aws.String(truncate(description, asffMaxText))
+	f.Severity = &securityhub.Severity{Label: aws.String(asffSeverities[itemSeverity(i)])}
+	f.Types = aws.StringSlice([]string{"Software and Configuration Checks"})
+	f.Remediation = b.remediation(i, "Review the change to "+label+" and revert it if it was not planned and approved.")
+	f.SourceUrl = b.sourceURL(r, i)
+
+	return f
+}
+
+// riskFinding ... a record of a rule matched by a property change, updated
+// for as long as the rule keeps matching the property
+func (b *asffBuilder) riskFinding(r *report, i map[string]interface{}, rf riskFinding) *securityhub.AwsSecurityFinding {
+	label := resourceLabel(i)
+
+	f := b.base(i, "rule/"+rf.Rule+"/"+rf.Path, "rule/"+rf.Rule)
+	f.Title = aws.String(truncate(fmt.Sprintf("%s: %s", label, rf.Reason), asffMaxTitle))
+	f.Description = aws.String(truncate(fmt.Sprintf("Rule %s matched the change to %s of %s %s: %s.",
+		rf.Rule, rf.Path, stringValue(i, "ResourceType"), label, rf.Reason), asffMaxText))
+	f.Severity = &securityhub.Severity{Label: aws.String(asffSeverities[rf.Severity])}
+	f.Types = aws.StringSlice([]string{"Software and Configuration Checks/AWS Security Best Practices"})
+	f.Remediation = b.remediation(i, fmt.Sprintf("Review %s of %s (%s) and revert the change if it was not approved.",
+		rf.Path, label, rf.Reason))
+	f.SourceUrl = b.sourceURL(r, i)
+
+	return f
+}
+
+// unexpectedFinding ... a record of an unchanged resource outside the allowlist
+func (b *asffBuilder) unexpectedFinding(r *report, u *unexpectedResource) *securityhub.AwsSecurityFinding {
+	i := map[string]interface{}{
+		"ResourceType": u.ResourceType,
+		"ResourceId":   u.ResourceID,
+		"ResourceName": u.Label,
+		"AccountId":    u.AccountID,
+		"AwsRegion":    u.Region,
+		"Arn":          u.Arn,
+	}
+
+	f := b.base(i, "rule/"+unexpectedRule+"/"+u.Property, "rule/"+unexpectedRule)
+	f.Title = aws.String(truncate(fmt.Sprintf("%s: unexpected resource", u.Label), asffMaxTitle))
+	f.Description = aws.String(truncate(fmt.Sprintf("%s %s is outside the allowlist: %s.", u.ResourceType, u.Label, u.Reason), asffMaxText))
+	f.Severity = &securityhub.Severity{Label: aws.String(securityhub.SeverityLabelHigh)}
+	f.Types = aws.StringSlice([]string{"Software and Configuration Checks/AWS Security Best Practices"})
+	f.Remediation = b.remediation(i, fmt.Sprintf("Remove %s, or update the allowlist if it is expected.", u.Label))
+	f.SourceUrl = b.sourceURL(r, i)
+
+	return f
+}
+
+// base ... the fields common to every record of the item: the ID, the
+// generator, the product, timestamps and the resource
+func (b *asffBuilder) base(i map[string]interface{}, id, generator string) *securityhub.AwsSecurityFinding {
+	account, region := stringValue(i, "AccountId"), stringValue(i, "AwsRegion")
+	if region == "" || region == "global" {
+		region = b.Region
+	}
+
+	partition := arnPartition(stringValue(i, "Arn"))
+	now := b.Now.Format(time.RFC3339)
+
+	productArn := b.ProductArn
+	if productArn == "" {
+		productArn = fmt.Sprintf("arn:%s:securityhub:%s:%s:product/%s/default", partition, b.Region, account, account)
+	}
+
+	observed := now
+	if t, err := time.Parse(time.RFC3339, stringValue(i, captureTimeKey)); err == nil {
+		observed = t.UTC().Format(time.RFC3339)
+	}
+
+	resourceID := stringValue(i, "Arn")
+	if resourceID == "" {
+		resourceID = stringValue(i, "ResourceId")
+	}
+
+	other := map[string]*string{"ConfigResourceType": aws.String(stringValue(i, "ResourceType"))}
+
+	for k, v := range map[string]string{
+		"ResourceId":   stringValue(i, "ResourceId"),
+		"ResourceName": stringValue(i, "ResourceName"),
+		"Owner":        stringValue(i, ownerKey),
+	} {
+		if v != "" {
+			other[k] = aws.String(truncate(v, asffMaxText))
+		}
+	}
+
+	resource := &securityhub.Resource{
+		Type:      aws.String(asffResourceType(stringValue(i, "ResourceType"))),
+		Id:        aws.String(resourceID),
+		Partition: aws.String(partition),
+		Region:    aws.String(region),
+		Details:   &securityhub.ResourceDetails{Other: other},
+	}
+
+	if tags, ok := i["Tags"].(map[string]interface{}); ok && len(tags) > 0 {
+		resource.Tags = make(map[string]*string, len(tags))
+
+		for k, v := range tags {
+			resource.Tags[k] = aws.String(fmt.Sprint(v))
+		}
+	}
+
+	return &securityhub.AwsSecurityFinding{
+		SchemaVersion: aws.String(asffSchemaVersion),
+		Id: aws.String(strings.Join([]string{asffGenerator, account, region, stringValue(i, "ResourceType"),
+			stringValue(i, "ResourceId"), id}, "/")),
+		ProductArn:      aws.String(productArn),
+		GeneratorId:     aws.String(asffGenerator + "/" + generator),
+		AwsAccountId:    aws.String(account),
+		CreatedAt:       aws.String(observed),
+		FirstObservedAt: aws.String(observed),
+		UpdatedAt:       aws.String(now),
+		Resources:       []*securityhub.Resource{resource},
+		RecordState:     aws.String(securityhub.RecordStateActive),
+	}
+}
+
+// remediation ... the recommendation text, linked to the resource's timeline
+// or console page when known
+func (b *asffBuilder) remediation(i map[string]interface{}, text string) *securityhub.Remediation {
+	rec := &securityhub.Recommendation{Text: aws.String(truncate(text, 512))}
+
+	for _, k := range []string{timelineURLKey, consoleURLKey} {
+		if u := stringValue(i, k); u != "" {
+			rec.Url = aws.String(u)
+			break
+		}
+	}
+
+	return &securityhub.Remediation{Recommendation: rec}
+}
+
+// sourceURL ... the archived report, or the resource's console page
+func (b *asffBuilder) sourceURL(r *report, i map[string]interface{}) *string {
+	if r.ArchiveURL != "" {
+		return aws.String(r.ArchiveURL)
+	}
+
+	if u := stringValue(i, consoleURLKey); u != "" {
+		return aws.String(u)
+	}
+
+	return nil
+}
+
+// asffResourceTypes ... the ASFF resource types of the Config resource types
+// Security Hub has details for. Their names do not follow the Config names,
+// e.g. AwsDynamoDbTable and AwsElbv2LoadBalancer
+var asffResourceTypes = map[string]string{
+	"AWS::ACM::Certificate":                     "AwsCertificateManagerCertificate",
+	"AWS::ApiGateway::RestApi":                  "AwsApiGatewayRestApi",
+	"AWS::ApiGateway::Stage":                    "AwsApiGatewayStage",
+	"AWS::ApiGatewayV2::Api":                    "AwsApiGatewayV2Api",
+	"AWS::ApiGatewayV2::Stage":                  "AwsApiGatewayV2Stage",
+	"AWS::Athena::WorkGroup":                    "AwsAthenaWorkGroup",
+	"AWS::AutoScaling::AutoScalingGroup":        "AwsAutoScalingAutoScalingGroup",
+	"AWS::AutoScaling::LaunchConfiguration":     "AwsAutoScalingLaunchConfiguration",
+	"AWS::Backup::BackupPlan":                   "AwsBackupBackupPlan",
+	"AWS::Backup::BackupVault":                  "AwsBackupBackupVault",
+	"AWS::Backup::RecoveryPoint":                "AwsBackupRecoveryPoint",
+	"AWS::CloudFormation::Stack":                "AwsCloudFormationStack",
+	"AWS::CloudFront::Distribution":             "AwsCloudFrontDistribution",
+	"AWS::CloudTrail::Trail":                    "AwsCloudTrailTrail",
+	"AWS::CloudWatch::Alarm":                    "AwsCloudWatchAlarm",
+	"AWS::CodeBuild::Project":                   "AwsCodeBuildProject",
+	"AWS::DMS::ReplicationInstance":             "AwsDmsReplicationInstance",
+	"AWS::DynamoDB::Table":                      "AwsDynamoDbTable",
+	"AWS::EC2::ClientVpnEndpoint":               "AwsEc2ClientVpnEndpoint",
+	"AWS::EC2::EIP":                             "AwsEc2Eip",
+	"AWS::EC2::Instance":                        "AwsEc2Instance",
+	"AWS::EC2::LaunchTemplate":                  "AwsEc2LaunchTemplate",
+	"AWS::EC2::NetworkAcl":                      "AwsEc2NetworkAcl",
+	"AWS::EC2::NetworkInterface":                "AwsEc2NetworkInterface",
+	"AWS::EC2::RouteTable":                      "AwsEc2RouteTable",
+	"AWS::EC2::SecurityGroup":                   "AwsEc2SecurityGroup",
+	"AWS::EC2::Subnet":                          "AwsEc2Subnet",
+	"AWS::EC2::TransitGateway":                  "AwsEc2TransitGateway",
+	"AWS::EC2::VPC":                             "AwsEc2Vpc",
+	"AWS::EC2::VPCEndpointService":              "AwsEc2VpcEndpointService",
+	"AWS::EC2::VPCPeeringConnection":            "AwsEc2VpcPeeringConnection",
+	"AWS::EC2::VPNConnection":                   "AwsEc2VpnConnection",
+	"AWS::EC2::Volume":                          "AwsEc2Volume",
+	"AWS::ECR::Repository":                      "AwsEcrRepository",
+	"AWS::ECS::Cluster":                         "AwsEcsCluster",
+	"AWS::ECS::Service":                         "AwsEcsService",
+	"AWS::ECS::TaskDefinition":                  "AwsEcsTaskDefinition",
+	"AWS::EFS::AccessPoint":                     "AwsEfsAccessPoint",
+	"AWS::EKS::Cluster":                         "AwsEksCluster",
+	"AWS::ElasticBeanstalk::Environment":        "AwsElasticBeanstalkEnvironment",
+	"AWS::ElasticLoadBalancing::LoadBalancer":   "AwsElbLoadBalancer",
+	"AWS::ElasticLoadBalancingV2::LoadBalancer": "AwsElbv2LoadBalancer",
+	"AWS::Elasticsearch::Domain":                "AwsElasticsearchDomain",
+	"AWS::Events::EventBus":                     "AwsEventsEventbus",
+	"AWS::GuardDuty::Detector":                  "AwsGuardDutyDetector",
+	"AWS::IAM::Group":                           "AwsIamGroup",
+	"AWS::IAM::Policy":                          "AwsIamPolicy",
+	"AWS::IAM::Role":                            "AwsIamRole",
+	"AWS::IAM::User":                            "AwsIamUser",
+	"AWS::KMS::Key":                             "AwsKmsKey",
+	"AWS::Kinesis::Stream":                      "AwsKinesisStream",
+	"AWS::Lambda::Function":                     "AwsLambdaFunction",
+	"AWS::MSK::Cluster":                         "AwsMskCluster",
+	"AWS::NetworkFirewall::Firewall":            "AwsNetworkFirewallFirewall",
+	"AWS::NetworkFirewall::FirewallPolicy":      "AwsNetworkFirewallFirewallPolicy",
+	"AWS::NetworkFirewall::RuleGroup":           "AwsNetworkFirewallRuleGroup",
+	"AWS::OpenSearch::Domain":                   "AwsOpenSearchServiceDomain",
+	"AWS::RDS::DBCluster":                       "AwsRdsDbCluster",
+	"AWS::RDS::DBClusterSnapshot":               "AwsRdsDbClusterSnapshot",
+	"AWS::RDS::DBInstance":                      "AwsRdsDbInstance",
+	"AWS::RDS::DBSecurityGroup":                 "AwsRdsDbSecurityGroup",
+	"AWS::RDS::DBSnapshot":                      "AwsRdsDbSnapshot",
+	"AWS::RDS::EventSubscription":               "AwsRdsEventSubscription",
+	"AWS::Redshift::Cluster":                    "AwsRedshiftCluster",
+	"AWS::Route53::HostedZone":                  "AwsRoute53HostedZone",
+	"AWS::S3::AccountPublicAccessBlock":         "AwsS3AccountPublicAccessBlock",
+	"AWS::S3::Bucket":                           "AwsS3Bucket",
+	"AWS::SNS::Topic":                           "AwsSnsTopic",
+	"AWS::SQS::Queue":                           "AwsSqsQueue",
+	"AWS::SSM::PatchCompliance":                 "AwsSsmPatchCompliance",
+	"AWS::SageMaker::NotebookInstance":          "AwsSageMakerNotebookInstance",
+	"AWS::SecretsManager::Secret":               "AwsSecretsManagerSecret",
+	"AWS::StepFunctions::StateMachine":          "AwsStepFunctionStateMachine",
+	"AWS::WAF::RateBasedRule":                   "AwsWafRateBasedRule",
+	"AWS::WAF::Rule":                            "AwsWafRule",
+	"AWS::WAF::RuleGroup":                       "AwsWafRuleGroup",
+	"AWS::WAF::WebACL":                          "AwsWafWebAcl",
+	"AWS::WAFRegional::RateBasedRule":           "AwsWafRegionalRateBasedRule",
+	"AWS::WAFRegional::Rule":                    "AwsWafRegionalRule",
+	"AWS::WAFRegional::RuleGroup":               "AwsWafRegionalRuleGroup",
+	"AWS::WAFRegional::WebACL":                  "AwsWafRegionalWebAcl",
+	"AWS::WAFv2::RuleGroup":                     "AwsWafv2RuleGroup",
+	"AWS::WAFv2::WebACL":                        "AwsWafv2WebAcl",
+	"AWS::XRay::EncryptionConfig":               "AwsXrayEncryptionConfig",
+}
+
+// asffResourceType ... the ASFF resource type of a Config resource type, Other
+// for the types Security Hub has no details for
+func asffResourceType(resourceType string) string {
+	if t, ok := asffResourceTypes[resourceType]; ok {
+		return t
+	}
+
+	return "Other"
+}
+
+// arnPartition ... the partition of an ARN, aws when unknown
+func arnPartition(arn string) string {
+	if parts := strings.SplitN(arn, ":", 3); len(parts) == 3 && parts[0] == "arn" && parts[1] != "" {
+		return parts[1]
+	}
+
+	return "aws"
+}
+
+// truncate ... s cut to at most n bytes on a rune boundary, marked as truncated
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	cut := n - len(truncatedMsg)
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+
+	return s[:cut] + truncatedMsg
+}
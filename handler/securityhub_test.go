@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/securityhub"
+	"github.com/aws/aws-sdk-go/service/securityhub/securityhubiface"
+)
+
+type mockSecurityHubClient struct {
+	securityhubiface.SecurityHubAPI
+	Batches [][]*securityhub.AwsSecurityFinding
+	Failed  int
+}
+
+func (m *mockSecurityHubClient) BatchImportFindings(in *securityhub.BatchImportFindingsInput) (*securityhub.BatchImportFindingsOutput, error) {
+	m.Batches = append(m.Batches, in.Findings)
+
+	out := &securityhub.BatchImportFindingsOutput{}
+	for _, f := range in.Findings[:m.Failed] {
+		out.FailedFindings = append(out.FailedFindings, &securityhub.ImportFindingsError{Id: f.Id, ErrorMessage: aws.String("invalid")})
+	}
+
+	return out, nil
+}
+
+// helper functions //
+func securityHubTestReport(t *testing.T) *report {
+	volume := configTestItem(t, "AWS::EC2::Volume", `{"encrypted": true}`, `{"encrypted": false}`)
+	volume["ResourceId"] = "vol-1"
+	volume["AccountId"] = "123456789012"
+	volume["AwsRegion"] = "us-gov-west-1"
+	volume["Arn"] = "arn:aws-us-gov:ec2:us-gov-west-1:123456789012:volume/vol-1"
+	volume["ConfigurationStateId"] = "1583636400000"
+	volume[captureTimeKey] = "2020-03-08T03:00:00.000Z"
+	volume["Tags"] = map[string]interface{}{"Owner": "ops"}
+	volume[timelineURLKey] = "https://console.aws.amazon.com/config/timeline"
+
+	tags := configTestItem(t, "AWS::S3::Bucket", `{"Description": "old"}`, `{"Description": "new"}`)
+	tags["ResourceId"] = "logs"
+	tags["AccountId"] = "123456789012"
+	tags["AwsRegion"] = "us-gov-west-1"
+
+	items := []map[string]interface{}{volume, tags}
+	scoreItems(items, defaultRiskRules())
+
+	return &report{
+		Items:      items,
+		Time:       time.Date(2020, 3, 8, 3, 0, 0, 0, time.UTC),
+		ArchiveURL: "https://archive/report.html",
+		Unexpected: []unexpectedResource{
+			{Label: "nb", ResourceType: "AWS::SageMaker::NotebookInstance", ResourceID: "nb", AccountID: "123456789012",
+				Region: "eu-west-3", Property: "ResourceType", Reason: "resource type AWS::SageMaker::NotebookInstance is not allowed"},
+			{Label: "vol-1", ResourceType: "AWS::EC2::Volume", ResourceID: "vol-1", Changed: true},
+		},
+	}
+}
+
+// test functions //
+func TestASFFFindings(t *testing.T) {
+	now := time.Date(2020, 3, 8, 4, 0, 0, 0, time.UTC)
+
+	tt := map[string]struct {
+		mode     string
+		expected []string
+	}{
+		"changes": {
+			mode: securityHubChanges,
+			expected: []string{
+				"grace-config-differ/123456789012/us-gov-west-1/AWS::EC2::Volume/vol-1/change/1583636400000",
+				"grace-config-differ/123456789012/us-gov-west-1/AWS::S3::Bucket/logs/change/",
+			},
+		},
+		"findings": {
+			mode: securityHubFindings,
+			expected: []string{
+				"grace-config-differ/123456789012/us-gov-west-1/AWS::EC2::Volume/vol-1/rule/encryption-disabled/Configuration.encrypted",
+				"grace-config-differ/123456789012/eu-west-3/AWS::SageMaker::NotebookInstance/nb/rule/unexpected-resource/ResourceType",
+			},
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			b := asffBuilder{Mode: tc.mode, Region: "us-gov-west-1", Now: now}
+			findings := b.findings(securityHubTestReport(t))
+
+			var ids []string
+			for _, f := range findings {
+				ids = append(ids, aws.StringValue(f.Id))
+			}
+
+			if strings.Join(ids, ",") != strings.Join(tc.expected, ",") {
+				t.Fatalf("findings() failed. Expected %v, got: %v", tc.expected, ids)
+			}
+
+			f := findings[0]
+			if aws.StringValue(f.ProductArn) != "arn:aws-us-gov:securityhub:us-gov-west-1:123456789012:product/123456789012/default" ||
+				aws.StringValue(f.SchemaVersion) != asffSchemaVersion || aws.StringValue(f.CreatedAt) != "2020-03-08T03:00:00Z" ||
+				aws.StringValue(f.UpdatedAt) != "2020-03-08T04:00:00Z" || aws.StringValue(f.Severity.Label) != securityhub.SeverityLabelHigh ||
+				aws.StringValue(f.SourceUrl) != "https://archive/report.html" {
+				t.Errorf("findings() failed. Unexpected finding: %v", f)
+			}
+
+			r := f.Resources[0]
+			if aws.StringValue(r.Type) != "AwsEc2Volume" || aws.StringValue(r.Id) != "arn:aws-us-gov:ec2:us-gov-west-1:123456789012:volume/vol-1" ||
+				aws.StringValue(r.Partition) != "aws-us-gov" || aws.StringValue(r.Tags["Owner"]) != "ops" ||
+				aws.StringValue(r.Details.Other["ResourceId"]) != "vol-1" {
+				t.Errorf("findings() failed. Unexpected resource: %v", r)
+			}
+
+			if aws.StringValue(f.Remediation.Recommendation.Url) != "https://console.aws.amazon.com/config/timeline" ||
+				aws.StringValue(f.Remediation.Recommendation.Text) == "" {
+				t.Errorf("findings() failed. Unexpected remediation: %v", f.Remediation)
+			}
+		})
+	}
+}
+
+func TestSecurityHubNotify(t *testing.T) {
+	r := securityHubTestReport(t)
+	for n := 0; n < 149; n++ {
+		i := map[string]interface{}{"ResourceType": "AWS::SQS::Queue", "ResourceId": fmt.Sprintf("q%d", n), "AccountId": "123456789012"}
+		r.Items = append(r.Items, i)
+	}
+
+	m := &mockSecurityHubClient{}
+	n := &SecurityHubNotifier{Client: m, Mode: securityHubChanges, Region: "us-east-1"}
+	chkErr(t, n.Notify(r))
+
+	if len(m.Batches) != 2 || len(m.Batches[0]) != asffBatchSize || len(m.Batches[1]) != 51 {
+		t.Errorf("Notify() failed. Expected batches of 100 and 51 findings, got %d batches", len(m.Batches))
+	}
+
+	m = &mockSecurityHubClient{Failed: 1}
+	n.Client = m
+
+	if err := n.Notify(r); err == nil || !strings.Contains(err.Error(), "rejected 2 of 151 findings") {
+		t.Errorf("Notify() failed. Expected the rejected findings, got: %v", err)
+	}
+
+	for s, expected := range map[string]string{
+		"AWS::EC2::SecurityGroup":                   "AwsEc2SecurityGroup",
+		"AWS::S3::Bucket":                           "AwsS3Bucket",
+		"AWS::EC2::VPC":                             "AwsEc2Vpc",
+		"AWS::RDS::DBInstance":                      "AwsRdsDbInstance",
+		"AWS::CloudTrail::Trail":                    "AwsCloudTrailTrail",
+		"AWS::DynamoDB::Table":                      "AwsDynamoDbTable",
+		"AWS::CloudFormation::Stack":                "AwsCloudFormationStack",
+		"AWS::ElasticLoadBalancingV2::LoadBalancer": "AwsElbv2LoadBalancer",
+		"AWS::ElasticBeanstalk::Environment":        "AwsElasticBeanstalkEnvironment",
+		"AWS::Config::ResourceCompliance":           "Other",
+		"Custom::Thing":                             "Other",
+	} {
+		if got := asffResourceType(s); got != expected {
+			t.Errorf("asffResourceType() failed. Expected %s, got: %s", expected, got)
+		}
+	}
+
+	for _, tc := range []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{strings.Repeat("a", 100), 10 + len(truncatedMsg), strings.Repeat("a", 10) + truncatedMsg},
+		{"aaaaaaaaa" + "é" + strings.Repeat("b", 100), 10 + len(truncatedMsg), "aaaaaaaaa" + truncatedMsg},
+	} {
+		if got := truncate(tc.s, tc.n); got != tc.expected || !utf8.ValidString(got) {
+			t.Errorf("truncate() failed. Expected %q, got: %q", tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	changeCreated  = "created"
+	changeModified = "modified"
+	changeDeleted  = "deleted"
+	subjectTime    = "2006-01-02 15:04Z"
+	topProperties  = 10
+)
+
+// executiveSummary ... headline counts rendered ahead of the per-resource report
+type executiveSummary struct {
+	Total        int
+	Created      int
+	Modified     int
+	Deleted      int
+	High         int
+	Drift        int
+	ByType       []changeCount
+	ByLocation   []changeCount
+	ByDeployment []changeCount
+	Properties   []propertyCount
+	HighRisk     []htmlSummaryItem
+	Unauthorized []htmlSummaryItem
+	Unexpected   []unexpectedResource
+}
+
+// changeCount ... created, modified and deleted resources for one type or account/region
+type changeCount struct {
+	Name     string
+	Created  int
+	Modified int
+	Deleted  int
+}
+
+// propertyCount ... the number of resources in which a property changed
+type propertyCount struct {
+	Path  string
+	Count int
+}
+
+// changeKind ... whether an item was created, modified or deleted
+func changeKind(item map[string]interface{}) string {
+	switch stringValue(item, "ConfigurationItemStatus") {
+	case "ResourceDeleted", "ResourceDeletedNotRecorded":
+		return changeDeleted
+	case "ResourceDiscovered":
+		return changeCreated
+	}
+
+	if _, ok := item["diffs"]; !ok {
+		// There was no snapshot of this item, so assume it is new
+		return changeCreated
+	}
+
+	return changeModified
+}
+
+// isHighRisk ... whether an item has been scored high or critical severity
+func isHighRisk(item map[string]interface{}) bool {
+	return itemSeverity(item) >= severityHigh
+}
+
+// summarize ... counts the changes in a set of items
+func summarize(items []map[string]interface{}) executiveSummary {
+	s := executiveSummary{Total: len(items)}
+	byType := make(map[string]*changeCount)
+	byLocation := make(map[string]*changeCount)
+	byDeployment := make(map[string]*changeCount)
+	deployed := false
+	properties := make(map[string]int)
+
+	for _, i := range items {
+		kind := changeKind(i)
+
+		d := itemDeployment(i)
+		deployed = deployed || d.Tool != ""
+
+		for _, c := range []*changeCount{
+			countFor(byType, stringValue(i, "ResourceType")),
+			countFor(byLocation, stringValue(i, "AccountId")+"/"+stringValue(i, "AwsRegion")),
+			countFor(byDeployment, d.Title()),
+		} {
+			c.add(kind)
+		}
+
+		switch kind {
+		case changeCreated:
+			s.Created++
+		case changeDeleted:
+			s.Deleted++
+		default:
+			s.Modified++
+		}
+
+		for _, p := range changedPaths(i) {
+			properties[p]++
+		}
+
+		if m := itemTerraform(i); m != nil && m.Status == terraformDrift {
+			s.Drift++
+		}
+
+		if isUnauthorized(i) {
+			s.Unauthorized = append(s.Unauthorized, htmlSummaryItem{
+				Label:   resourceLabel(i),
+				Type:    stringValue(i, "ResourceType"),
+				Changes: strings.Join(changedPaths(i), ", "),
+			})
+		}
+
+		if isHighRisk(i) {
+			s.High++
+			s.HighRisk = append(s.HighRisk, htmlSummaryItem{
+				Label:   resourceLabel(i),
+				Type:    stringValue(i, "ResourceType"),
+				Changes: strings.Join(changedPaths(i), ", "),
+			})
+		}
+	}
+
+	s.ByType = sortedCounts(byType)
+	s.ByLocation = sortedCounts(byLocation)
+
+	if deployed {
+		s.ByDeployment = sortedCounts(byDeployment)
+	}
+
+	for p, n := range properties {
+		s.Properties = append(s.Properties, propertyCount{Path: p, Count: n})
+	}
+
+	sort.Slice(s.Properties, func(i, j int) bool {
+		if s.Properties[i].Count != s.Properties[j].Count {
+			return s.Properties[i].Count > s.Properties[j].Count
+		}
+
+		return s.Properties[i].Path < s.Properties[j].Path
+	})
+
+	if len(s.Properties) > topProperties {
+		s.Properties = s.Properties[:topProperties]
+	}
+
+	return s
+}
+
+func countFor(m map[string]*changeCount, name string) *changeCount {
+	c, ok := m[name]
+	if !ok {
+		c = &changeCount{Name: name}
+		m[name] = c
+	}
+
+	return c
+}
+
+func (c *changeCount) add(kind string) {
+	switch kind {
+	case changeCreated:
+		c.Created++
+	case changeDeleted:
+		c.Deleted++
+	default:
+		c.Modified++
+	}
+}
+
+func sortedCounts(m map[string]*changeCount) []changeCount {
+	counts := make([]changeCount, 0, len(m))
+
+	for _, c := range m {
+		counts = append(counts, *c)
+	}
+
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].Name < counts[j].Name
+	})
+
+	return counts
+}
+
+// summary ... the executive summary of the report's changes and of the
+// resources outside the allowlist
+func (r *report) summary() executiveSummary {
+	s := summarize(r.Items)
+	s.Unexpected = r.Unexpected
+
+	return s
+}
+
+// headline ... the change counts used in subject lines, e.g. "12 changes (2 high)"
+func (s executiveSummary) headline() string {
+	h := fmt.Sprintf("%d change", s.Total)
+	if s.Total != 1 {
+		h += "s"
+	}
+
+	var notes []string
+
+	if s.High > 0 {
+		notes = append(notes, fmt.Sprintf("%d high", s.High))
+	}
+
+	if len(s.Unauthorized) > 0 {
+		notes = append(notes, fmt.Sprintf("%d unauthorized", len(s.Unauthorized)))
+	}
+
+	if len(s.Unexpected) > 0 {
+		notes = append(notes, fmt.Sprintf("%d unexpected", len(s.Unexpected)))
+	}
+
+	if len(notes) > 0 {
+		h += " (" + strings.Join(notes, ", ") + ")"
+	}
+
+	return h
+}
+
+// summaryToText ... renders the executive summary as plain text
+func summaryToText(s executiveSummary) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "\nSummary: %d created, %d modified, %d deleted", s.Created, s.Modified, s.Deleted)
+
+	if s.High > 0 {
+		fmt.Fprintf(&sb, ", %d high risk", s.High)
+	}
+
+	if s.Drift > 0 {
+		fmt.Fprintf(&sb, ", %d drifted from Terraform state", s.Drift)
+	}
+
+	sb.WriteString("\n")
+
+	for _, section := range []struct {
+		name   string
+		counts []changeCount
+	}{
+		{"By resource type", s.ByType},
+		{"By account/region", s.ByLocation},
+		{"By deployment", s.ByDeployment},
+	} {
+		if len(section.counts) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&sb, "\n%s%s\n", textIndent, section.name)
+
+		for _, c := range section.counts {
+			fmt.Fprintf(&sb, "%s%s%s: %d created, %d modified, %d deleted\n",
+				textIndent, textIndent, c.Name, c.Created, c.Modified, c.Deleted)
+		}
+	}
+
+	if len(s.Properties) > 0 {
+		fmt.Fprintf(&sb, "\n%sMost changed properties\n", textIndent)
+
+		for _, p := range s.Properties {
+			fmt.Fprintf(&sb, "%s%s%s: %d\n", textIndent, textIndent, p.Path, p.Count)
+		}
+	}
+
+	for _, section := range []struct {
+		name  string
+		items []htmlSummaryItem
+	}{
+		{"High risk", s.HighRisk},
+		{"Unauthorized (no approved change ticket)", s.Unauthorized},
+	} {
+		if len(section.items) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&sb, "\n%s%s\n", textIndent, section.name)
+
+		for _, i := range section.items {
+			fmt.Fprintf(&sb, "%s%s%s (%s): %s\n", textIndent, textIndent, i.Label, i.Type, i.Changes)
+		}
+	}
+
+	if len(s.Unexpected) > 0 {
+		fmt.Fprintf(&sb, "\n%sUnexpected resources (outside the allowlist)\n", textIndent)
+
+		for _, u := range s.Unexpected {
+			fmt.Fprintf(&sb, "%s%s%s (%s, %s): %s\n", textIndent, textIndent, u.Label, u.ResourceType, u.Region, u.Reason)
+		}
+	}
+
+	return sb.String()
+}
+
+// reportSubject ... the subject line used by all notifiers, carrying the headline counts
+func reportSubject(r *report) string {
+	switch {
+	case r.Digest != nil:
+		return fmt.Sprintf("%s digest: %s – %s", r.Digest.Title(), r.summary().headline(), r.Time.UTC().Format(subjectTime))
+	case r.Maintenance != nil:
+		return fmt.Sprintf("Maintenance window %s: %s – %s", r.Maintenance.Name, r.summary().headline(),
+			r.Time.UTC().Format(subjectTime))
+	case r.Inventory != nil:
+		return fmt.Sprintf("Inventory: %d resources (%d added, %d removed) – %s", len(r.Inventory.Resources),
+			len(r.Inventory.Added), len(r.Inventory.Removed), r.Time.UTC().Format(subjectTime))
+	}
+
+	return fmt.Sprintf("%s – %s", r.summary().headline(), r.Time.UTC().Format(subjectTime))
+}
+
+// asciiSubject ... the subject with the dash replaced for sinks limited to ASCII
+func asciiSubject(r *report) string {
+	return strings.ReplaceAll(reportSubject(r), "–", "-")
+}
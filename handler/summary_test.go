@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helper functions //
+func summaryTestItems(t *testing.T) []map[string]interface{} {
+	// each resource is tagged, and the first bucket's acl changed too
+	modified := func(name, typ, region, severity, acl string) map[string]interface{} {
+		item := `{"ResourceId": %q, "ResourceName": %q, "ResourceType": %q, "AccountId": "123456789012",
+			"AwsRegion": %q, "ConfigurationItemStatus": "OK", "Configuration": {"acl": %q}%s}`
+
+		i := changedTestItem(t, fmt.Sprintf(item, name, name, typ, region, "private", ""),
+			fmt.Sprintf(item, name, name, typ, region, acl, `, "Tags": {"team": "apps"}`))
+		i[severityKey] = severity
+
+		return i
+	}
+
+	return []map[string]interface{}{
+		modified("bucket-1", "AWS::S3::Bucket", "us-east-1", "high", "public-read"),
+		modified("bucket-2", "AWS::S3::Bucket", "us-east-1", "", "private"),
+		modified("role-1", "AWS::IAM::Role", "global", "low", "private"),
+		{
+			"ResourceName":            "bucket-3",
+			"ResourceType":            "AWS::S3::Bucket",
+			"AccountId":               "123456789012",
+			"AwsRegion":               "us-east-1",
+			"ConfigurationItemStatus": "ResourceDeleted",
+			"diffs":                   map[string]interface{}{},
+		},
+		{
+			"ResourceName":            "bucket-4",
+			"ResourceType":            "AWS::S3::Bucket",
+			"AccountId":               "123456789012",
+			"AwsRegion":               "us-west-2",
+			"ConfigurationItemStatus": "ResourceDiscovered",
+		},
+	}
+}
+
+// test functions //
+func TestSummarize(t *testing.T) {
+	s := summarize(summaryTestItems(t))
+
+	if s.Total != 5 || s.Created != 1 || s.Modified != 3 || s.Deleted != 1 || s.High != 1 {
+		t.Errorf("summarize() failed. Unexpected counts: %+v", s)
+	}
+
+	expectedTypes := []changeCount{
+		{Name: "AWS::IAM::Role", Modified: 1},
+		{Name: "AWS::S3::Bucket", Created: 1, Modified: 2, Deleted: 1},
+	}
+	if len(s.ByType) != len(expectedTypes) {
+		t.Fatalf("summarize() failed. Expected by type: %v, got: %v", expectedTypes, s.ByType)
+	}
+
+	for n, c := range expectedTypes {
+		if s.ByType[n] != c {
+			t.Errorf("summarize() failed. Expected by type %d: %+v, got: %+v", n, c, s.ByType[n])
+		}
+	}
+
+	if len(s.ByLocation) != 3 || s.ByLocation[1].Name != "123456789012/us-east-1" || s.ByLocation[1].Modified != 2 {
+		t.Errorf("summarize() failed. Unexpected by account/region: %+v", s.ByLocation)
+	}
+
+	if len(s.Properties) != 2 || s.Properties[0] != (propertyCount{Path: "Tags", Count: 3}) {
+		t.Errorf("summarize() failed. Unexpected properties: %+v", s.Properties)
+	}
+
+	if len(s.HighRisk) != 1 || s.HighRisk[0].Label != "bucket-1" {
+		t.Errorf("summarize() failed. Unexpected high risk: %+v", s.HighRisk)
+	}
+}
+
+func TestReportSubject(t *testing.T) {
+	tm := time.Date(2026, 10, 16, 10, 0, 0, 0, time.FixedZone("EST", -5*60*60))
+	tt := map[string]struct {
+		items    []map[string]interface{}
+		expected string
+	}{
+		"counts with high": {
+			items:    summaryTestItems(t),
+			expected: "5 changes (1 high) – 2026-10-16 15:00Z",
+		},
+		"single change": {
+			items:    summaryTestItems(t)[1:2],
+			expected: "1 change – 2026-10-16 15:00Z",
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			r := &report{Items: tc.items, Time: tm}
+
+			if s := reportSubject(r); s != tc.expected {
+				t.Errorf("reportSubject() failed. Expected: %q, got: %q", tc.expected, s)
+			}
+
+			if s := asciiSubject(r); s != strings.ReplaceAll(tc.expected, "–", "-") {
+				t.Errorf("asciiSubject() failed. Got: %q", s)
+			}
+		})
+	}
+}
+
+func TestSummaryRendering(t *testing.T) {
+	r := &report{Items: summaryTestItems(t), Time: time.Date(2020, 1, 30, 13, 35, 19, 0, time.UTC)}
+
+	h, err := reportToHTML(r)
+	chkErr(t, err)
+
+	text, err := reportToText(r)
+	chkErr(t, err)
+
+	for _, s := range []string{"1 created, 3 modified, 1 deleted", "123456789012/us-west-2", "High Risk", "Tags"} {
+		if !strings.Contains(h, s) {
+			t.Errorf("reportToHTML() failed. Expected %q in summary", s)
+		}
+	}
+
+	for _, s := range []string{"Summary: 1 created, 3 modified, 1 deleted, 1 high risk", "Tags: 3", "bucket-1 (AWS::S3::Bucket)"} {
+		if !strings.Contains(text, s) {
+			t.Errorf("reportToText() failed. Expected %q in:\n%s", s, text)
+		}
+	}
+
+	if strings.Index(h, "<h2>Summary</h2>") > strings.Index(h, `class="resource" colspan=2>bucket-1`) {
+		t.Errorf("reportToHTML() failed. Expected summary before the resource rows")
+	}
+}
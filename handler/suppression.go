@@ -0,0 +1,311 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// What happens to changes matching an active suppression
+const (
+	suppressDrop     = "drop"
+	suppressAnnotate = "annotate"
+)
+
+const suppressedKey = "Suppressed"
+
+// suppression ... an acknowledged change. Changes to resources matching the
+// resource type and ID globs whose property path matches the path expression
+// are suppressed until the suppression expires
+type suppression struct {
+	ID             string    `json:"id"`
+	ResourceType   string    `json:"resource_type"`
+	ResourceID     string    `json:"resource_id"`
+	Path           string    `json:"path"`
+	Expires        time.Time `json:"expires"`
+	AcknowledgedBy string    `json:"acknowledged_by"`
+	Reason         string    `json:"reason"`
+	Created        time.Time `json:"created"`
+
+	path *regexp.Regexp
+}
+
+// suppressionStore ... the JSON document holding the suppressions
+type suppressionStore struct {
+	Suppressions []suppression `json:"suppressions"`
+}
+
+// suppressedChange ... a property change matched by a suppression, recorded
+// on the item when suppressions annotate rather than drop changes
+type suppressedChange struct {
+	Path           string    `json:"path"`
+	ID             string    `json:"id"`
+	AcknowledgedBy string    `json:"acknowledged_by"`
+	Reason         string    `json:"reason"`
+	Expires        time.Time `json:"expires"`
+}
+
+// compile ... validates the suppression and compiles its path expression
+func (s *suppression) compile() (err error) {
+	for _, p := range []string{s.ResourceType, s.ResourceID} {
+		if _, err = path.Match(p, ""); err != nil {
+			return fmt.Errorf("suppression %s: invalid pattern %q: %v", s.ID, p, err)
+		}
+	}
+
+	if s.Path != "" {
+		if s.path, err = regexp.Compile(s.Path); err != nil {
+			return fmt.Errorf("suppression %s: %v", s.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// active ... whether the suppression has not expired at t
+func (s *suppression) active(t time.Time) bool {
+	return s.Expires.After(t)
+}
+
+// matches ... whether the suppression covers a property of the item
+func (s *suppression) matches(item map[string]interface{}, propertyPath string) bool {
+	for _, m := range []struct {
+		pattern string
+		value   string
+	}{
+		{s.ResourceType, stringValue(item, "ResourceType")},
+		{s.ResourceID, stringValue(item, "ResourceId")},
+	} {
+		if m.pattern == "" {
+			continue
+		}
+
+		if ok, _ := path.Match(m.pattern, m.value); !ok {
+			return false
+		}
+	}
+
+	return s.path == nil || s.path.MatchString(propertyPath)
+}
+
+// parseSuppressions ... parses and compiles a suppression store
+func parseSuppressions(b []byte) (store suppressionStore, err error) {
+	if err = json.Unmarshal(b, &store); err != nil {
+		return store, err
+	}
+
+	for n := range store.Suppressions {
+		if err = store.Suppressions[n].compile(); err != nil {
+			return store, err
+		}
+	}
+
+	return store, nil
+}
+
+// suppressionsLocation ... the bucket and key of the suppression store
+func suppressionsLocation(cfg *config) (string, string) {
+	bucket := cfg.SuppressionsBucket
+	if bucket == "" {
+		bucket = cfg.S3Bucket
+	}
+
+	return bucket, cfg.SuppressionsKey
+}
+
+// getSuppressions ... reads the suppression store, returning an empty store if
+// none has been written yet
+func getSuppressions(svc s3iface.S3API, bucket, key string) (suppressionStore, error) {
+	s, err := getObject(svc, bucket, key)
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return suppressionStore{}, nil
+		}
+
+		return suppressionStore{}, err
+	}
+
+	return parseSuppressions([]byte(s))
+}
+
+// putSuppressions ... writes the suppression store
+func putSuppressions(svc s3iface.S3API, bucket, key, kmsKeyArn string, store suppressionStore) error {
+	b, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return putObject(svc, bucket, key, "application/json", kmsKeyArn, b)
+}
+
+// loadSuppressions ... the suppression store in S3, or nil when suppressions are not configured
+func loadSuppressions(cfg *config, svc s3iface.S3API) (*suppressionStore, error) {
+	bucket, key := suppressionsLocation(cfg)
+	if key == "" {
+		return nil, nil
+	}
+
+	store, err := getSuppressions(svc, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("using %d suppressions from s3://%s/%s\n", len(store.Suppressions), bucket, key)
+
+	return &store, nil
+}
+
+// checkSuppressionMode ... whether the mode is drop or annotate
+func checkSuppressionMode(mode string) error {
+	if mode != suppressDrop && mode != suppressAnnotate {
+		return fmt.Errorf("unknown suppression_mode %q, expected %s or %s", mode, suppressDrop, suppressAnnotate)
+	}
+
+	return nil
+}
+
+// applySuppressions ... drops or annotates the changes matching an active
+// suppression, returning the items that still have changes to report. Changes
+// the rules score high or critical are annotated rather than dropped, so an
+// acknowledgement cannot hide a risk it was not meant to cover
+func applySuppressions(
+	items []map[string]interface{},
+	store *suppressionStore,
+	mode string,
+	rules []riskRule,
+	t time.Time) []map[string]interface{} {
+	if store == nil {
+		return items
+	}
+
+	var active []*suppression
+
+	for n := range store.Suppressions {
+		if s := &store.Suppressions[n]; s.active(t) {
+			active = append(active, s)
+		}
+	}
+
+	if len(active) == 0 {
+		return items
+	}
+
+	kept := make([]map[string]interface{}, 0, len(items))
+
+	for _, i := range items {
+		var suppressed []suppressedChange
+
+		dropped := 0
+		diffs, _ := i["diffs"].(map[string]interface{})
+
+		for _, c := range itemChanges(i) {
+			s := matchingSuppression(active, i, c.Path)
+			if s == nil {
+				continue
+			}
+
+			if mode == suppressDrop && changeSeverity(i, c, rules) < severityHigh {
+				deleteDiff(diffs, c.Path)
+				dropped++
+
+				continue
+			}
+
+			suppressed = append(suppressed, suppressedChange{
+				Path:           c.Path,
+				ID:             s.ID,
+				AcknowledgedBy: s.AcknowledgedBy,
+				Reason:         s.Reason,
+				Expires:        s.Expires,
+			})
+		}
+
+		if len(suppressed) > 0 {
+			i[suppressedKey] = suppressed
+		}
+
+		if dropped == 0 {
+			kept = append(kept, i)
+			continue
+		}
+
+		log.Printf("suppressed %d changes to %s\n", dropped, resourceLabel(i))
+
+		// the capture time and state ID left behind are not a change to report
+		if hasPropertyChanges(diffs) {
+			kept = append(kept, i)
+		}
+	}
+
+	return kept
+}
+
+// matchingSuppression ... the first active suppression covering a property of
+// the item, or nil
+func matchingSuppression(active []*suppression, item map[string]interface{}, propertyPath string) *suppression {
+	for _, s := range active {
+		if s.matches(item, propertyPath) {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// deleteDiff ... removes a property change from the nested diffs of an item,
+// removing nested diffs left empty
+func deleteDiff(diffs map[string]interface{}, propertyPath string) {
+	for k, v := range diffs {
+		if k == propertyPath {
+			delete(diffs, k)
+			return
+		}
+
+		if nested, ok := nestedDiffs(v); ok && strings.HasPrefix(propertyPath, k+".") {
+			deleteDiff(nested, strings.TrimPrefix(propertyPath, k+"."))
+
+			if len(nested) == 0 {
+				delete(diffs, k)
+			}
+
+			return
+		}
+	}
+}
+
+// itemSuppressed ... the suppressed changes of an item, whether set by
+// applySuppressions or read back from JSON
+func itemSuppressed(i map[string]interface{}) []suppressedChange {
+	var suppressed []suppressedChange
+
+	switch v := i[suppressedKey].(type) {
+	case []suppressedChange:
+		return v
+	case []interface{}:
+		decodeItemValue(v, &suppressed)
+	}
+
+	return suppressed
+}
+
+// newSuppressionID ... a random identifier for a new suppression
+func newSuppressionID() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helper functions //
+func suppressionTestItems(t *testing.T) []map[string]interface{} {
+	// items carry the capture metadata diffed with every change
+	item := func(resourceType, id, previous, current string) map[string]interface{} {
+		resource := `{"ResourceType": %q, "ResourceId": %q, "ConfigurationItemCaptureTime": %q,
+			"ConfigurationStateId": %q, "Configuration": %s}`
+
+		return changedTestItem(t, fmt.Sprintf(resource, resourceType, id, "2020-03-09T00:00:00Z", "1", previous),
+			fmt.Sprintf(resource, resourceType, id, "2020-03-10T00:00:00Z", "2", current))
+	}
+
+	return []map[string]interface{}{
+		item("AWS::EC2::SecurityGroup", "sg-1", `{"ipPermissions": [2], "description": "a"}`, `{"ipPermissions": [1], "description": "b"}`),
+		item("AWS::EC2::SecurityGroup", "sg-2", `{"ipPermissions": [2]}`, `{"ipPermissions": [1]}`),
+		item("AWS::SQS::Queue", "q-1", `{"delay": 0}`, `{"delay": 5}`),
+	}
+}
+
+// test functions //
+func TestApplySuppressions(t *testing.T) {
+	now := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	store, err := parseSuppressions([]byte(`{"suppressions": [
+		{"id": "s1", "resource_type": "AWS::EC2::*", "path": "^Configuration\\.ipPermissions$",
+		 "expires": "2020-03-11T00:00:00Z", "acknowledged_by": "alex", "reason": "CHG-1"},
+		{"id": "s2", "resource_id": "q-1", "expires": "2020-03-01T00:00:00Z", "acknowledged_by": "sam", "reason": "CHG-0"}
+	]}`))
+	chkErr(t, err)
+
+	high, err := parseRiskRules([]byte(`{"rules": [{"name": "sg", "path": "ipPermissions", "severity": "high"}]}`), nil)
+	chkErr(t, err)
+
+	tt := map[string]struct {
+		mode     string
+		rules    []riskRule
+		kept     int
+		expected []string
+	}{
+		"drop": {
+			mode:     suppressDrop,
+			kept:     2,
+			expected: []string{"sg-1:Configuration.description", "q-1:Configuration.delay"},
+		},
+		"drop high severity": {
+			mode:  suppressDrop,
+			rules: high,
+			kept:  3,
+			expected: []string{"sg-1:Configuration.description", "sg-1:Configuration.ipPermissions",
+				"sg-2:Configuration.ipPermissions", "q-1:Configuration.delay"},
+		},
+		"annotate": {
+			mode: suppressAnnotate,
+			kept: 3,
+			expected: []string{"sg-1:Configuration.description", "sg-1:Configuration.ipPermissions",
+				"sg-2:Configuration.ipPermissions", "q-1:Configuration.delay"},
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			items := applySuppressions(suppressionTestItems(t), &store, tc.mode, tc.rules, now)
+
+			if len(items) != tc.kept {
+				t.Errorf("applySuppressions() failed. Expected %d items kept, got %d: %v", tc.kept, len(items), items)
+			}
+
+			var got []string
+
+			for _, i := range items {
+				for _, c := range itemChanges(i) {
+					if !clusterIgnored[c.Path] {
+						got = append(got, stringValue(i, "ResourceId")+":"+c.Path)
+					}
+				}
+			}
+
+			if strings.Join(got, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("applySuppressions() failed. Expected: %v\nGot: %v", tc.expected, got)
+			}
+
+			if tc.mode == suppressDrop && tc.rules == nil {
+				return
+			}
+
+			s := itemSuppressed(items[0])
+			if len(s) != 1 || s[0].ID != "s1" || s[0].AcknowledgedBy != "alex" || len(itemSuppressed(items[2])) != 0 {
+				t.Errorf("applySuppressions() failed. Unexpected annotations: %v", s)
+			}
+
+			text, err := itemsToText(items, 0)
+			chkErr(t, err)
+
+			if !strings.Contains(text, "Suppressed Configuration.ipPermissions: CHG-1 (acknowledged by alex until 2020-03-11T00:00:00Z)") {
+				t.Errorf("itemsToText() failed. Expected suppression in:\n%s", text)
+			}
+		})
+	}
+}
+
+func TestCheckSuppressionMode(t *testing.T) {
+	tt := map[string]bool{
+		suppressDrop:     true,
+		suppressAnnotate: true,
+		"":               false,
+		"hide":           false,
+	}
+
+	for mode, expected := range tt {
+		mode, expected := mode, expected
+		t.Run(mode, func(t *testing.T) {
+			if err := checkSuppressionMode(mode); (err == nil) != expected {
+				t.Errorf("checkSuppressionMode() failed. Expected valid: %v, got error: %v", expected, err)
+			}
+		})
+	}
+}
+
+func TestParseSuppressions(t *testing.T) {
+	tt := map[string]string{
+		"invalid glob":       `{"suppressions": [{"id": "a", "resource_type": "["}]}`,
+		"invalid expression": `{"suppressions": [{"id": "a", "path": "("}]}`,
+		"invalid json":       `{"suppressions": {}}`,
+	}
+
+	for name, s := range tt {
+		s := s
+		t.Run(name, func(t *testing.T) {
+			if _, err := parseSuppressions([]byte(s)); err == nil {
+				t.Errorf("parseSuppressions() failed. Expected error")
+			}
+		})
+	}
+}
+
+func TestSuppressCLI(t *testing.T) {
+	m := &mockS3{}
+	out := &bytes.Buffer{}
+	now := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	c := &suppressCLI{Client: m, Out: out, Now: func() time.Time { return now }}
+	store := []string{"-bucket", "bucket", "-key", "suppressions.json"}
+
+	chkErr(t, c.run(append([]string{"add", "-resource-id", "sg-1", "-path", "^Tags", "-expires", "24h",
+		"-by", "alex", "-reason", "CHG-1"}, store...)))
+
+	stored, err := getSuppressions(m, "bucket", "suppressions.json")
+	chkErr(t, err)
+
+	if len(stored.Suppressions) != 1 || !stored.Suppressions[0].Expires.Equal(now.Add(24*time.Hour)) {
+		t.Fatalf("suppress add failed. Unexpected store: %+v", stored)
+	}
+
+	id := stored.Suppressions[0].ID
+
+	chkErr(t, c.run(append([]string{"expire", "-id", id}, store...)))
+
+	out.Reset()
+	chkErr(t, c.run(append([]string{"list"}, store...)))
+
+	if !strings.Contains(out.String(), id+"  expired") || !strings.Contains(out.String(), "CHG-1") {
+		t.Errorf("suppress list failed. Expected expired suppression in:\n%s", out)
+	}
+
+	for _, args := range [][]string{
+		{"add", "-resource-id", "sg-1", "-by", "alex"},
+		{"add", "-resource-id", "sg-1", "-by", "alex", "-reason", "r", "-expires", "-1h"},
+		{"expire", "-id", "unknown"},
+		{"remove"},
+	} {
+		if err := c.run(append(args, store...)); err == nil {
+			t.Errorf("suppress %v failed. Expected error", args)
+		}
+	}
+}
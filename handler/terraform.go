@@ -0,0 +1,588 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// How a change relates to the Terraform state declaring the resource
+const (
+	terraformDeployed   = "deployed"   // the changed properties match the state
+	terraformDrift      = "drift"      // a changed property diverges from the state
+	terraformUnverified = "unverified" // in state, but no changed property maps to an attribute
+)
+
+const (
+	terraformKey      = "Terraform"
+	terraformS3Scheme = "s3://"
+)
+
+// terraformType ... the Config resource type of an aws_* Terraform resource
+// type and the attribute holding its Config resource ID
+type terraformType struct {
+	ResourceType string
+	IDAttribute  string
+}
+
+// terraformTypes ... the Terraform resource types mapped to Config resource
+// types. Resources of other types are still matched by ARN
+var terraformTypes = map[string]terraformType{
+	"aws_cloudwatch_metric_alarm": {"AWS::CloudWatch::Alarm", "alarm_name"},
+	"aws_db_instance":             {"AWS::RDS::DBInstance", "resource_id"},
+	"aws_dynamodb_table":          {"AWS::DynamoDB::Table", "name"},
+	"aws_ebs_volume":              {"AWS::EC2::Volume", "id"},
+	"aws_efs_file_system":         {"AWS::EFS::FileSystem", "id"},
+	"aws_eip":                     {"AWS::EC2::EIP", "id"},
+	"aws_iam_group":               {"AWS::IAM::Group", "unique_id"},
+	"aws_iam_policy":              {"AWS::IAM::Policy", "policy_id"},
+	"aws_iam_role":                {"AWS::IAM::Role", "unique_id"},
+	"aws_iam_user":                {"AWS::IAM::User", "unique_id"},
+	"aws_instance":                {"AWS::EC2::Instance", "id"},
+	"aws_internet_gateway":        {"AWS::EC2::InternetGateway", "id"},
+	"aws_kms_key":                 {"AWS::KMS::Key", "key_id"},
+	"aws_lambda_function":         {"AWS::Lambda::Function", "function_name"},
+	"aws_nat_gateway":             {"AWS::EC2::NatGateway", "id"},
+	"aws_network_acl":             {"AWS::EC2::NetworkAcl", "id"},
+	"aws_network_interface":       {"AWS::EC2::NetworkInterface", "id"},
+	"aws_rds_cluster":             {"AWS::RDS::DBCluster", "cluster_resource_id"},
+	"aws_route_table":             {"AWS::EC2::RouteTable", "id"},
+	"aws_s3_bucket":               {"AWS::S3::Bucket", "id"},
+	"aws_security_group":          {"AWS::EC2::SecurityGroup", "id"},
+	"aws_subnet":                  {"AWS::EC2::Subnet", "id"},
+	"aws_vpc":                     {"AWS::EC2::VPC", "id"},
+	"aws_vpc_endpoint":            {"AWS::EC2::VPCEndpoint", "id"},
+}
+
+// terraformState ... the parts of a version 4 Terraform state file used to
+// find the declared attributes of each resource
+type terraformState struct {
+	Version   int `json:"version"`
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey   interface{}            `json:"index_key"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// terraformInstance ... one resource instance declared in a state file
+type terraformInstance struct {
+	Address    string
+	Source     string
+	Attributes map[string]interface{}
+}
+
+// terraformIndex ... the managed aws_* resource instances of the configured
+// state files, by Config resource key and by ARN
+type terraformIndex struct {
+	byKey map[string]*terraformInstance
+	byArn map[string]*terraformInstance
+}
+
+// terraformMatch ... how a change relates to the state declaring the resource
+type terraformMatch struct {
+	Address string          `json:"address"`
+	Source  string          `json:"source"`
+	Status  string          `json:"status"`
+	Drift   []terraformDiff `json:"drift,omitempty"`
+}
+
+// terraformDiff ... a changed property whose current value differs from state
+type terraformDiff struct {
+	Path    string `json:"path"`
+	State   string `json:"state"`
+	Current string `json:"current"`
+}
+
+// loadTerraformStates ... reads the configured state files, local paths or
+// s3://bucket/key URLs, returning nil when none are configured
+func loadTerraformStates(cfg *config, svc s3iface.S3API) (*terraformIndex, error) {
+	if len(cfg.TerraformStates) == 0 {
+		return nil, nil
+	}
+
+	index := &terraformIndex{byKey: make(map[string]*terraformInstance), byArn: make(map[string]*terraformInstance)}
+
+	for _, source := range cfg.TerraformStates {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			continue
+		}
+
+		b, err := readTerraformState(svc, source)
+		if err != nil {
+			return nil, fmt.Errorf("error reading terraform state %s: %v", source, err)
+		}
+
+		if err := index.add(source, b); err != nil {
+			return nil, fmt.Errorf("error parsing terraform state %s: %v", source, err)
+		}
+
+		log.Printf("using terraform state %s\n", source)
+	}
+
+	return index, nil
+}
+
+func readTerraformState(svc s3iface.S3API, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, terraformS3Scheme) {
+		return os.ReadFile(source)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(source, terraformS3Scheme), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("expected s3://bucket/key")
+	}
+
+	s, err := getObject(svc, parts[0], parts[1])
+
+	return []byte(s), err
+}
+
+// add ... indexes the managed aws_* resource instances of a state file
+func (x *terraformIndex) add(source string, b []byte) error {
+	var state terraformState
+	if err := json.Unmarshal(b, &state); err != nil {
+		return err
+	}
+
+	if state.Version != 4 {
+		return fmt.Errorf("unsupported state version %d", state.Version)
+	}
+
+	for _, r := range state.Resources {
+		if r.Mode != "managed" || !strings.HasPrefix(r.Type, "aws_") {
+			continue
+		}
+
+		for _, i := range r.Instances {
+			inst := &terraformInstance{
+				Address:    terraformAddress(r.Module, r.Type, r.Name, i.IndexKey),
+				Source:     source,
+				Attributes: i.Attributes,
+			}
+
+			if arn, _ := i.Attributes["arn"].(string); arn != "" {
+				x.byArn[arn] = inst
+			}
+
+			if t, ok := terraformTypes[r.Type]; ok {
+				if id := terraformString(i.Attributes[t.IDAttribute]); id != "" {
+					x.byKey[t.ResourceType+"|"+id] = inst
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// terraformAddress ... the resource address of an instance, as shown by
+// terraform state list
+func terraformAddress(module, resourceType, name string, key interface{}) string {
+	address := resourceType + "." + name
+	if module != "" {
+		address = module + "." + address
+	}
+
+	switch k := key.(type) {
+	case float64:
+		address += "[" + strconv.FormatFloat(k, 'f', -1, 64) + "]"
+	case string:
+		address += "[" + strconv.Quote(k) + "]"
+	}
+
+	return address
+}
+
+// lookup ... the state instance declaring the item's resource, if any
+func (x *terraformIndex) lookup(item map[string]interface{}) *terraformInstance {
+	if inst, ok := x.byKey[resourceKey(item)]; ok {
+		return inst
+	}
+
+	if arn := stringValue(item, "Arn"); arn != "" {
+		return x.byArn[arn]
+	}
+
+	return nil
+}
+
+// correlateTerraform ... labels each change to a resource declared in the
+// Terraform state as deployed when the changed properties match the declared
+// attributes, or as drift when any diverges from them. Properties the
+// redactor hides are not compared, as the state holds them in plaintext
+func correlateTerraform(items []map[string]interface{}, index *terraformIndex, r *redactor) {
+	if index == nil {
+		return
+	}
+
+	for _, i := range items {
+		inst := index.lookup(i)
+		if inst == nil {
+			continue
+		}
+
+		match := &terraformMatch{Address: inst.Address, Source: inst.Source, Status: terraformUnverified}
+
+		switch changeKind(i) {
+		case changeDeleted:
+			// the state still declares the deleted resource
+			match.Status = terraformDrift
+		case changeCreated:
+			match.Status = terraformDeployed
+		default:
+			for _, c := range itemChanges(i) {
+				drift, compared := declaredDrift(inst.Attributes, c, r)
+				if !compared {
+					continue
+				}
+
+				if len(drift) == 0 {
+					if match.Status == terraformUnverified {
+						match.Status = terraformDeployed
+					}
+
+					continue
+				}
+
+				match.Status = terraformDrift
+				match.Drift = append(match.Drift, drift...)
+			}
+		}
+
+		i[terraformKey] = match
+	}
+}
+
+// declaredDrift ... the properties of a change that differ from the state,
+// and whether any of them maps to a state attribute. The tags are compared
+// with tags_all, or tags for older providers, and configuration properties
+// with the snake_case attribute of the same name
+func declaredDrift(attributes map[string]interface{}, c propertyChange, r *redactor) ([]terraformDiff, bool) {
+	parts := strings.Split(c.Path, ".")
+
+	switch {
+	case c.Path == "Tags":
+		for _, name := range []string{"tags_all", "tags"} {
+			if tags, ok := attributes[name].(map[string]interface{}); ok {
+				current, _ := c.Current.(map[string]interface{})
+				return tagDrift(tags, current), true
+			}
+		}
+
+		return nil, false
+	case len(parts) < 2 || parts[0] != "Configuration":
+		return nil, false
+	}
+
+	declared, ok := declaredValue(attributes, parts[1:])
+	if !ok {
+		return nil, false
+	}
+
+	return compareDeclared(declared, c.Current, c.Path, r)
+}
+
+// tagDrift ... the tags whose current value differs from the declared tags,
+// including tags added or removed outside Terraform
+func tagDrift(declared, current map[string]interface{}) []terraformDiff {
+	var drift []terraformDiff
+
+	for _, k := range sortedKeys(declared, current) {
+		if !sameValue(declared[k], current[k]) {
+			drift = append(drift, terraformDiff{Path: "Tags." + k, State: compactJSON(declared[k]), Current: compactJSON(current[k])})
+		}
+	}
+
+	return drift
+}
+
+// declaredValue ... the state attribute at a Config property path, descending
+// through nested blocks, which the state holds as lists of one object
+func declaredValue(attributes map[string]interface{}, parts []string) (interface{}, bool) {
+	var v interface{} = attributes
+
+	for _, p := range parts {
+		if list, ok := v.([]interface{}); ok {
+			if n, err := strconv.Atoi(p); err == nil {
+				if n >= len(list) {
+					return nil, false
+				}
+
+				v = list[n]
+
+				continue
+			}
+
+			if len(list) != 1 {
+				return nil, false
+			}
+
+			v = list[0]
+		}
+
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if v, ok = declaredKey(m, p); !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// compareDeclared ... the values under path that differ from the state, and
+// whether any could be compared. Objects are compared by the properties
+// the state declares, ignoring the rest, arrays of
+// objects element by element and arrays of values as sets. Redacted values
+// are skipped so the plaintext state value is never reported
+func compareDeclared(declared, current interface{}, path string, r *redactor) ([]terraformDiff, bool) {
+	if r.redacts(path) {
+		return nil, false
+	}
+
+	// a nested block is a list of one object in the state
+	if list, ok := declared.([]interface{}); ok && len(list) == 1 {
+		if _, ok := current.(map[string]interface{}); ok {
+			declared = list[0]
+		}
+	}
+
+	switch cur := current.(type) {
+	case map[string]interface{}:
+		block, ok := declared.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		return compareDeclaredBlock(block, cur, path, r)
+	case []interface{}:
+		list, ok := declared.([]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		return compareDeclaredList(list, cur, path, r)
+	}
+
+	// a scalar cannot be compared with a block
+	switch declared.(type) {
+	case map[string]interface{}, []interface{}:
+		return nil, false
+	}
+
+	if sameValue(declared, current) {
+		return nil, true
+	}
+
+	return []terraformDiff{{Path: path, State: compactJSON(declared), Current: compactJSON(current)}}, true
+}
+
+// compareDeclaredBlock ... the properties of an object that differ from the
+// properties the state declares for it
+func compareDeclaredBlock(block, cur map[string]interface{}, path string, r *redactor) ([]terraformDiff, bool) {
+	var (
+		drift    []terraformDiff
+		compared bool
+	)
+
+	name, value := nameValueKeys(cur)
+
+	for _, k := range sortedKeys(cur) {
+		v, ok := declaredKey(block, k)
+		if !ok {
+			continue
+		}
+
+		// the value of a name/value pair is redacted by its name
+		if k == value && r.redacts(path+"."+stringValue(cur, name)) {
+			continue
+		}
+
+		d, c := compareDeclared(v, cur[k], path+"."+k, r)
+		drift = append(drift, d...)
+		compared = compared || c
+	}
+
+	return drift, compared
+}
+
+// compareDeclaredList ... the elements of an array that differ from the state,
+// comparing arrays of values as sets
+func compareDeclaredList(list, cur []interface{}, path string, r *redactor) ([]terraformDiff, bool) {
+	diff := []terraformDiff{{Path: path, State: compactJSON(list), Current: compactJSON(cur)}}
+
+	if isScalarList(cur) && isScalarList(list) {
+		if sameSet(list, cur) {
+			return nil, true
+		}
+
+		return diff, true
+	}
+
+	if len(list) != len(cur) {
+		return diff, true
+	}
+
+	var (
+		drift    []terraformDiff
+		compared bool
+	)
+
+	for n := range cur {
+		d, c := compareDeclared(list[n], cur[n], path+"."+strconv.Itoa(n), r)
+		drift = append(drift, d...)
+		compared = compared || c
+	}
+
+	return drift, compared
+}
+
+func isScalarList(list []interface{}) bool {
+	for _, v := range list {
+		switch v.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+
+	return true
+}
+
+// sameSet ... whether two lists of values hold the same values in any order
+func sameSet(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	count := make(map[string]int)
+
+	for _, v := range a {
+		count[terraformString(v)]++
+	}
+
+	for _, v := range b {
+		if count[terraformString(v)]--; count[terraformString(v)] < 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// sortedKeys ... the keys of the maps, sorted and without duplicates
+func sortedKeys(maps ...map[string]interface{}) []string {
+	var keys []string
+
+	for _, m := range maps {
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	return uniqueStrings(keys...)
+}
+
+// sameValue ... whether a state attribute and a Config value are equal,
+// treating numbers, booleans and their string forms alike and null as empty
+func sameValue(declared, current interface{}) bool {
+	if reflect.DeepEqual(declared, current) {
+		return true
+	}
+
+	return terraformString(declared) == terraformString(current)
+}
+
+func terraformString(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	}
+
+	return fmt.Sprint(v)
+}
+
+// declaredKey ... the state value of a Config property. Map keys, such as
+// environment variable names and tag keys, are held verbatim, while schema
+// attributes are the snake_case of the property name
+func declaredKey(block map[string]interface{}, k string) (interface{}, bool) {
+	if v, ok := block[k]; ok {
+		return v, true
+	}
+
+	v, ok := block[snakeCase(k)]
+
+	return v, ok
+}
+
+// snakeCase ... converts a camelCase or PascalCase Config property name to
+// the snake_case of Terraform attributes, keeping acronyms together
+func snakeCase(s string) string {
+	runes := []rune(s)
+
+	var sb strings.Builder
+
+	for n, r := range runes {
+		if unicode.IsUpper(r) && n > 0 {
+			prevLower := unicode.IsLower(runes[n-1]) || unicode.IsDigit(runes[n-1])
+			nextLower := n+1 < len(runes) && unicode.IsLower(runes[n+1])
+
+			if prevLower || (unicode.IsUpper(runes[n-1]) && nextLower) {
+				sb.WriteRune('_')
+			}
+		}
+
+		sb.WriteRune(unicode.ToLower(r))
+	}
+
+	return sb.String()
+}
+
+// itemTerraform ... the Terraform correlation of an item, whether set by
+// correlateTerraform or read back from JSON
+func itemTerraform(i map[string]interface{}) *terraformMatch {
+	switch v := i[terraformKey].(type) {
+	case *terraformMatch:
+		return v
+	case map[string]interface{}:
+		var m terraformMatch
+		if decodeItemValue(v, &m) {
+			return &m
+		}
+	}
+
+	return nil
+}
+
+// terraformStatus ... the Terraform status of an item, empty when it is not
+// declared in a state file
+func terraformStatus(i map[string]interface{}) string {
+	if m := itemTerraform(i); m != nil {
+		return m.Status
+	}
+
+	return ""
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const terraformTestState = `{
+	"version": 4,
+	"resources": [
+		{"mode": "managed", "type": "aws_instance", "name": "web", "module": "module.app", "instances": [
+			{"index_key": 0, "attributes": {"id": "i-1", "instance_type": "t3.large",
+				"tags_all": {"Env": "prod", "Name": "web"}, "instance_state": "running",
+				"metadata_options": [{"http_tokens": "required", "http_endpoint": "enabled"}]}}
+		]},
+		{"mode": "managed", "type": "aws_sqs_queue", "name": "jobs", "instances": [
+			{"attributes": {"arn": "arn:aws:sqs:us-east-1:111111111111:jobs", "delay_seconds": 5}}
+		]},
+		{"mode": "data", "type": "aws_vpc", "name": "default", "instances": [{"attributes": {"id": "vpc-1"}}]}
+	]
+}`
+
+// helper functions //
+func terraformTestIndex(t *testing.T) *terraformIndex {
+	path := filepath.Join(t.TempDir(), "terraform.tfstate")
+	chkErr(t, os.WriteFile(path, []byte(terraformTestState), 0600))
+
+	index, err := loadTerraformStates(&config{TerraformStates: []string{path}}, &mockS3{})
+	chkErr(t, err)
+
+	return index
+}
+
+// test functions //
+func TestCorrelateTerraform(t *testing.T) {
+	index := terraformTestIndex(t)
+	instance := func(instanceType, env, tokens string) string {
+		return `{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-1", "Tags": {"Env": "` + env + `", "Name": "web"},
+			"Configuration": {"instanceType": "` + instanceType + `", "state": {"code": 16, "name": "running"},
+				"metadataOptions": {"httpTokens": "` + tokens + `", "httpEndpoint": "enabled", "state": "applied"}}}`
+	}
+	tt := map[string]struct {
+		previous string
+		current  string
+		expected string
+		drift    string
+	}{
+		"deployed": {
+			previous: instance("t3.small", "prod", "optional"),
+			current:  instance("t3.large", "prod", "required"),
+			expected: terraformDeployed,
+		},
+		"drift": {
+			previous: instance("t3.large", "prod", "required"),
+			current:  instance("t3.large", "dev", "optional"),
+			expected: terraformDrift,
+			drift:    "Configuration.metadataOptions.httpTokens,Tags.Env",
+		},
+		"tag added": {
+			previous: instance("t3.large", "prod", "required"),
+			current: `{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-1", "Tags": {"Env": "prod", "Name": "web", "Temp": "x"},
+				"Configuration": {"instanceType": "t3.large", "metadataOptions": {"httpTokens": "required", "httpEndpoint": "enabled"}}}`,
+			expected: terraformDrift,
+			drift:    "Tags.Temp",
+		},
+		"matched by arn": {
+			previous: `{"ResourceType": "AWS::SQS::Queue", "ResourceId": "https://sqs/jobs", "Arn": "arn:aws:sqs:us-east-1:111111111111:jobs",
+				"Configuration": {"DelaySeconds": "0"}}`,
+			current: `{"ResourceType": "AWS::SQS::Queue", "ResourceId": "https://sqs/jobs", "Arn": "arn:aws:sqs:us-east-1:111111111111:jobs",
+				"Configuration": {"DelaySeconds": "5"}}`,
+			expected: terraformDeployed,
+		},
+		"unverified": {
+			previous: `{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-1", "Configuration": {"state": {"code": 16, "name": "running"}}}`,
+			current:  `{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-1", "Configuration": {"state": {"code": 80, "name": "stopped"}}}`,
+			expected: terraformUnverified,
+		},
+		"deleted": {
+			previous: `{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-1", "ConfigurationItemStatus": "OK"}`,
+			current:  `{"ResourceType": "AWS::EC2::Instance", "ResourceId": "i-1", "ConfigurationItemStatus": "ResourceDeleted"}`,
+			expected: terraformDrift,
+		},
+		"not in state": {
+			previous: `{"ResourceType": "AWS::EC2::VPC", "ResourceId": "vpc-1", "Configuration": {"cidrBlock": "10.0.0.0/16"}}`,
+			current:  `{"ResourceType": "AWS::EC2::VPC", "ResourceId": "vpc-1", "Configuration": {"cidrBlock": "10.1.0.0/16"}}`,
+		},
+	}
+
+	for name, tc := range tt {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			item := changedTestItem(t, tc.previous, tc.current)
+
+			correlateTerraform([]map[string]interface{}{item}, index, nil)
+
+			m := itemTerraform(item)
+			if tc.expected == "" {
+				if m != nil {
+					t.Errorf("correlateTerraform() failed. Expected no match, got: %+v", m)
+				}
+
+				return
+			}
+
+			if m == nil || m.Status != tc.expected {
+				t.Fatalf("correlateTerraform() failed. Expected %s, got: %+v", tc.expected, m)
+			}
+
+			var paths []string
+			for _, d := range m.Drift {
+				paths = append(paths, d.Path)
+			}
+
+			if strings.Join(paths, ",") != tc.drift {
+				t.Errorf("correlateTerraform() failed. Expected drift of %q, got: %+v", tc.drift, m.Drift)
+			}
+		})
+	}
+
+	item := changedTestItem(t, tt["drift"].previous, tt["drift"].current)
+	correlateTerraform([]map[string]interface{}{item}, index, nil)
+
+	text, err := reportToText(&report{Items: []map[string]interface{}{item}})
+	chkErr(t, err)
+
+	for _, s := range []string{"1 drifted from Terraform state", `Terraform: drift (module.app.aws_instance.web[0] in`,
+		`Tags.Env: state "prod", current "dev"`, `Configuration.metadataOptions.httpTokens: state "required", current "optional"`} {
+		if !strings.Contains(text, s) {
+			t.Errorf("reportToText() failed. Expected %q in:\n%s", s, text)
+		}
+	}
+}
+
+func TestCorrelateTerraformRedaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "terraform.tfstate")
+	chkErr(t, os.WriteFile(path, []byte(`{"version": 4, "resources": [
+		{"mode": "managed", "type": "aws_lambda_function", "name": "fn", "instances": [
+			{"attributes": {"function_name": "fn", "memory_size": 128,
+				"environment": [{"variables": {"api_key": "hunter2", "LOG_LEVEL": "info"}}]}}]}]}`), 0600))
+
+	index, err := loadTerraformStates(&config{TerraformStates: []string{path}}, &mockS3{})
+	chkErr(t, err)
+
+	r := newRedactor(&config{RedactPatterns: []string{"api_key"}, RedactionSalt: "salt"})
+	function := `{"ResourceType": "AWS::Lambda::Function", "ResourceId": "fn",
+		"Configuration": {"memorySize": %d, "environment": {"variables": {"api_key": %q, "LOG_LEVEL": %q}}}}`
+
+	var previous, current map[string]interface{}
+
+	chkErr(t, json.Unmarshal([]byte(fmt.Sprintf(function, 128, "hunter2", "info")), &previous))
+	chkErr(t, json.Unmarshal([]byte(fmt.Sprintf(function, 256, "hunter3", "debug")), &current))
+	r.redactItems([]map[string]interface{}{previous, current})
+	diffAgainstSnapshot([]map[string]interface{}{current}, []map[string]interface{}{previous})
+
+	correlateTerraform([]map[string]interface{}{current}, index, r)
+
+	// variable names are matched verbatim, and the redacted api_key is skipped
+	m := itemTerraform(current)
+	if m == nil || m.Status != terraformDrift || len(m.Drift) != 2 ||
+		m.Drift[0].Path != "Configuration.environment.variables.LOG_LEVEL" || m.Drift[1].Path != "Configuration.memorySize" {
+		t.Fatalf("correlateTerraform() failed. Expected drift of LOG_LEVEL and the memory size only, got: %+v", m)
+	}
+
+	text, err := reportToText(&report{Items: []map[string]interface{}{current}})
+	chkErr(t, err)
+
+	if strings.Contains(text, "hunter") || strings.Contains(compactJSON(current), "hunter") {
+		t.Errorf("correlateTerraform() failed. Expected no plaintext secret in:\n%s", text)
+	}
+}
+
+func TestLoadTerraformStates(t *testing.T) {
+	m := &mockS3{Puts: map[string][]byte{"env/prod.tfstate": []byte(terraformTestState)}}
+
+	index, err := loadTerraformStates(&config{TerraformStates: []string{"s3://state-bucket/env/prod.tfstate"}}, m)
+	chkErr(t, err)
+
+	if inst := index.byKey["AWS::EC2::Instance|i-1"]; inst == nil || inst.Address != "module.app.aws_instance.web[0]" {
+		t.Errorf("loadTerraformStates() failed. Expected the instance to be indexed, got: %+v", inst)
+	}
+
+	if _, ok := index.byKey["AWS::EC2::VPC|vpc-1"]; ok {
+		t.Errorf("loadTerraformStates() failed. Expected data sources to be ignored")
+	}
+
+	for _, source := range []string{"s3://state-bucket", "/no/such/terraform.tfstate"} {
+		if _, err := loadTerraformStates(&config{TerraformStates: []string{source}}, m); err == nil {
+			t.Errorf("loadTerraformStates() failed. Expected error for %s", source)
+		}
+	}
+}
+
+func TestSnakeCase(t *testing.T) {
+	for in, expected := range map[string]string{
+		"instanceType":    "instance_type",
+		"VpcId":           "vpc_id",
+		"DBInstanceClass": "db_instance_class",
+		"ipv6CidrBlock":   "ipv6_cidr_block",
+		"kmsKeyARN":       "kms_key_arn",
+	} {
+		if got := snakeCase(in); got != expected {
+			t.Errorf("snakeCase() failed. Expected %s for %s, got: %s", expected, in, got)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	textIndent = "  "
+	tabPadding = 2
+)
+
+// reportToText ... renders the complete plain text report body
+func reportToText(r *report) (string, error) {
+	if r.Inventory != nil {
+		return inventoryToText(r.Inventory), nil
+	}
+
+	text, err := deploymentsToText(r.Items, r.ClusterThreshold)
+	if err != nil {
+		return "", err
+	}
+
+	header := fmt.Sprintf("Configuration Changes at %v (+/- %v min)\nSnapshot: %s\n", r.Time, window, r.snapshotName())
+	switch {
+	case r.Digest != nil:
+		header = digestToText(r.Digest)
+	case r.Maintenance != nil:
+		header = fmt.Sprintf("Configuration Changes During Maintenance Window %s: %v to %v\n"+
+			"Net change of each resource over the window. These changes were held until the window closed.\n",
+			r.Maintenance.Name, r.Maintenance.Start, r.Maintenance.End)
+	}
+
+	if r.ArchiveURL != "" {
+		header += fmt.Sprintf("Archive: %s\n", r.ArchiveURL)
+	}
+
+	return header + summaryToText(r.summary()) + text, nil
+}
+
+// deploymentsToText ... renders the items as plain text under a heading for
+// each deployment, or ungrouped when no item belongs to a deployment
+func deploymentsToText(items []map[string]interface{}, threshold int) (string, error) {
+	groups := groupByDeployment(items)
+	if groups == nil {
+		return itemsToText(items, threshold)
+	}
+
+	var sb strings.Builder
+
+	for _, g := range groups {
+		fmt.Fprintf(&sb, "\n%s\n%s\n", g.Title(), strings.Repeat("=", len(g.Title())))
+
+		if g.Tool == "" {
+			sb.WriteString("Resources not deployed by a CloudFormation stack or Terraform workspace.\n" +
+				"These may be changes made outside of the deployment pipeline.\n")
+		}
+
+		text, err := itemsToText(g.Items, threshold)
+		if err != nil {
+			return "", err
+		}
+
+		sb.WriteString(text)
+	}
+
+	return sb.String(), nil
+}
+
+// itemsToText ... renders the items as plain text, listing identical changes
+// to at least threshold resources once
+func itemsToText(items []map[string]interface{}, threshold int) (string, error) {
+	var sb strings.Builder
+
+	for _, cluster := range clusterItems(items, threshold) {
+		i := cluster[0]
+
+		if len(cluster) > 1 {
+			i = clusterRepresentative(cluster)
+			fmt.Fprintf(&sb, "\n%d resources (%s)", len(cluster), stringValue(i, "ResourceType"))
+		} else {
+			fmt.Fprintf(&sb, "\n%s (%s)", resourceLabel(i), stringValue(i, "ResourceType"))
+		}
+
+		if steps := digestSteps(i); steps > 1 {
+			fmt.Fprintf(&sb, " (%d changes)", steps)
+		}
+
+		if _, ok := i["diffs"]; !ok {
+			// There was no snapshot of this item, so assume it is new
+			slice, err := json.MarshalIndent(i, textIndent, textIndent)
+			if err != nil {
+				return "", err
+			}
+
+			fmt.Fprintf(&sb, " (New Item)\n%s%s\n", textIndent, slice)
+
+			continue
+		}
+
+		sb.WriteString("\n")
+
+		if len(cluster) > 1 {
+			labels := make([]string, 0, len(cluster))
+			for _, m := range clusterMembers(cluster) {
+				labels = append(labels, m.Label)
+			}
+
+			fmt.Fprintf(&sb, "%sResources: %s\n", textIndent, strings.Join(labels, ", "))
+		}
+
+		writeAnnotationsText(&sb, i)
+
+		s, err := changesToText(itemChanges(i))
+		if err != nil {
+			return "", err
+		}
+
+		sb.WriteString(s)
+	}
+
+	return sb.String(), nil
+}
+
+// writeAnnotationsText ... writes what is known about a change beyond its
+// properties: the owner, risks, maintenance window, suppressions, change
+// ticket, Terraform status and CloudTrail actors
+func writeAnnotationsText(sb *strings.Builder, i map[string]interface{}) {
+	if owner := stringValue(i, ownerKey); owner != "" {
+		fmt.Fprintf(sb, "%sOwner: %s (%s)\n", textIndent, owner, stringValue(i, ownerSourceKey))
+	}
+
+	for _, f := range itemRisks(i) {
+		fmt.Fprintf(sb, "%s[%s] %s (%s)\n", textIndent, f.Severity, f.Reason, f.Path)
+	}
+
+	if w := stringValue(i, maintenanceKey); w != "" {
+		fmt.Fprintf(sb, "%sIn maintenance window %s\n", textIndent, w)
+	}
+
+	for _, c := range itemSuppressed(i) {
+		fmt.Fprintf(sb, "%sSuppressed %s: %s (acknowledged by %s until %s)\n",
+			textIndent, c.Path, c.Reason, c.AcknowledgedBy, c.Expires.Format(time.RFC3339))
+	}
+
+	writeAuthorizationText(sb, itemAuthorization(i))
+	writeTerraformText(sb, itemTerraform(i))
+
+	for _, a := range itemActors(i) {
+		fmt.Fprintf(sb, "%sChanged by %s (%s at %v from %s)\n",
+			textIndent, a.PrincipalArn, a.EventName, a.EventTime, a.SourceIPAddress)
+	}
+}
+
+// writeAuthorizationText ... writes the change ticket authorizing a change,
+// or that none does
+func writeAuthorizationText(sb *strings.Builder, a *changeAuthorization) {
+	switch {
+	case a == nil:
+	case a.Status != changeAuthorized:
+		fmt.Fprintf(sb, "%sUNAUTHORIZED: no approved change ticket covers this change\n", textIndent)
+	case a.URL != "":
+		fmt.Fprintf(sb, "%sChange ticket: %s (%s)\n", textIndent, a.Ticket, a.URL)
+	default:
+		fmt.Fprintf(sb, "%sChange ticket: %s\n", textIndent, a.Ticket)
+	}
+}
+
+// writeTerraformText ... writes the Terraform status of a change and its drift
+func writeTerraformText(sb *strings.Builder, m *terraformMatch) {
+	if m == nil {
+		return
+	}
+
+	fmt.Fprintf(sb, "%sTerraform: %s", textIndent, m.Status)
+	if m.Address != "" {
+		fmt.Fprintf(sb, " (%s in %s)", m.Address, m.Source)
+	}
+
+	sb.WriteString("\n")
+
+	for _, d := range m.Drift {
+		fmt.Fprintf(sb, "%s%s%s: state %s, current %s\n", textIndent, textIndent, d.Path, d.State, d.Current)
+	}
+}
+
+func changesToText(changes []propertyChange) (string, error) {
+	var (
+		table strings.Builder
+		long  strings.Builder
+		rows  int
+	)
+
+	w := tabwriter.NewWriter(&table, 0, 0, tabPadding, ' ', 0)
+	fmt.Fprintf(w, "%sProperty\tPrevious\tCurrent\n", textIndent)
+
+	for _, c := range changes {
+		a, b, err := myMarshal(c.Previous, c.Current)
+		if err != nil {
+			return "", err
+		}
+
+		if len(a) <= shortFldLen && len(b) <= shortFldLen {
+			fmt.Fprintf(w, "%s%s\t%s\t%s\n", textIndent, c.Path, a, b)
+			rows++
+
+			continue
+		}
+
+		d, err := textDiff(c.Previous, c.Current)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(&long, "%s%s\n", textIndent, c.Path)
+
+		for _, l := range splitLines(d) {
+			fmt.Fprintf(&long, "%s%s%s\n", textIndent, textIndent, l)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+
+	if rows == 0 {
+		return long.String(), nil
+	}
+
+	return table.String() + long.String(), nil
+}
+
+// textDiff ... unified diff of the pretty-printed JSON of two values
+func textDiff(old, newer interface{}) (string, error) {
+	a, err := prettyJSON(old)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := prettyJSON(newer)
+	if err != nil {
+		return "", err
+	}
+
+	return unifiedDiff(a, b, diffContext), nil
+}
+
+// prettyJSON ... indented JSON without HTML escaping, so policies and scripts
+// read as written
+func prettyJSON(v interface{}) (string, error) {
+	var b strings.Builder
+
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", textIndent)
+
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
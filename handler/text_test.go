@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseItemsToText(t *testing.T) {
+	long := strings.Repeat("x", shortFldLen)
+	resource := `{"ResourceId": "testID1", "ResourceName": %q, "ResourceType": "testType1",
+		"Configuration": {"policy": [%q, %q]}}`
+	item := changedTestItem(t, fmt.Sprintf(resource, "oldName1", long, "a"), fmt.Sprintf(resource, "testName1", long, "b"))
+	expected := `
+testName1 (testType1)
+  Property      Previous    Current
+  ResourceName  "oldName1"  "testName1"
+  Configuration.policy
+    --- previous
+    +++ current
+    @@ -1,4 +1,4 @@
+     [
+       "` + long + `",
+    -  "a"
+    +  "b"
+     ]
+`
+
+	str, err := itemsToText([]map[string]interface{}{item}, 0)
+	if err != nil {
+		t.Fatalf("itemsToText() failed. Unexpected error: %v", err)
+	}
+
+	if str != expected {
+		t.Errorf("itemsToText() failed. Expected:\n%s\nGot:\n%s", expected, str)
+	}
+
+	delete(item, "diffs")
+
+	str, err = itemsToText([]map[string]interface{}{item}, 0)
+	if err != nil {
+		t.Fatalf("itemsToText() failed. Unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(str, "\ntestName1 (testType1) (New Item)\n  {\n") {
+		t.Errorf("itemsToText() failed. Unexpected new item rendering:\n%s", str)
+	}
+}
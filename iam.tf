@@ -30,13 +30,17 @@ resource "aws_iam_policy" "self" {
   "Statement": [
     {
       "Action": [
+        "cloudtrail:LookupEvents",
         "config:DescribeConfigRuleEvaluationStatus",
         "config:GetResourceConfigHistory",
         "config:ListDiscoveredResources",
+        "events:PutEvents",
         "logs:CreateLogGroup",
         "logs:CreateLogStream",
         "logs:PutLogEvents",
-        "ses:SendRawEmail"
+        "securityhub:BatchImportFindings",
+        "ses:SendRawEmail",
+        "sns:Publish"
       ],
       "Effect": "Allow",
       "Resource": "*"
@@ -49,14 +53,37 @@ resource "aws_iam_policy" "self" {
       "Effect": "Allow",
       "Resource": [
         "${local.s3_bucket_arn}",
-        "${local.s3_bucket_arn}/*"
+        "${local.s3_bucket_arn}/*",
+        "${local.template_bucket_arn}/*",
+        "${local.risk_rules_bucket_arn}/*",
+        "${local.routing_rules_bucket_arn}/*",
+        "${local.suppressions_bucket_arn}/*",
+        "${local.maintenance_bucket_arn}/*",
+        "${local.approved_changes_arn}/*",
+        "${local.allowlist_bucket_arn}/*"
       ]
     },
+    {
+      "Action": [
+        "s3:PutObject"
+      ],
+      "Effect": "Allow",
+      "Resource": "${local.s3_bucket_arn}/${var.archive_prefix}/oversized/*"
+    },
+    {
+      "Action": [
+        "s3:DeleteObject",
+        "s3:PutObject"
+      ],
+      "Effect": "Allow",
+      "Resource": "${local.s3_bucket_arn}/${var.archive_prefix}/held/*"
+    },
     {
       "Effect": "Allow",
       "Action": [
         "kms:Decrypt",
-        "kms:Encrypt"
+        "kms:Encrypt",
+        "kms:GenerateDataKey"
       ],
       "Resource": "${var.kms_key_arn}"
     },
@@ -67,6 +94,15 @@ resource "aws_iam_policy" "self" {
         "ssm:GetParameter"
       ],
       "Resource": "arn:aws:ssm:${local.region}:${local.account_id}:parameter/${var.ssm_parameter_store}"
+    },
+    {
+      "Effect": "Allow",
+      "Action": [
+        "ssm:PutParameter",
+        "ssm:GetParameter",
+        "ssm:DeleteParameter"
+      ],
+      "Resource": "arn:aws:ssm:${local.region}:${local.account_id}:parameter/${var.ssm_parameter_store}-held-changes"
     }
   ]
 }
@@ -78,3 +114,67 @@ resource "aws_iam_role_policy_attachment" "iam_role_policy_attachment" {
   role       = aws_iam_role.self.name
   policy_arn = aws_iam_policy.self.arn
 }
+
+resource "aws_iam_role_policy" "archive" {
+  count = var.archive_bucket == "" ? 0 : 1
+  name  = "${local.app_name}-archive"
+  role  = aws_iam_role.self.id
+
+  policy = <<EOF
+{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Action": [
+        "s3:GetObject",
+        "s3:PutObject"
+      ],
+      "Effect": "Allow",
+      "Resource": "arn:aws:s3:::${var.archive_bucket}/${var.archive_prefix}/*"
+    },
+    {
+      "Action": [
+        "s3:ListBucket"
+      ],
+      "Effect": "Allow",
+      "Resource": "arn:aws:s3:::${var.archive_bucket}",
+      "Condition": {
+        "StringLike": {
+          "s3:prefix": "${var.archive_prefix}/*"
+        }
+      }
+    },
+    {
+      "Effect": "Allow",
+      "Action": [
+        "kms:GenerateDataKey"
+      ],
+      "Resource": "${var.kms_key_arn}"
+    }
+  ]
+}
+EOF
+
+}
+
+resource "aws_iam_role_policy" "terraform_states" {
+  count = length(local.terraform_state_arns) == 0 ? 0 : 1
+  name  = "${local.app_name}-terraform-states"
+  role  = aws_iam_role.self.id
+
+  policy = <<EOF
+{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Action": [
+        "s3:GetObject"
+      ],
+      "Effect": "Allow",
+      "Resource": ${jsonencode(local.terraform_state_arns)}
+    }
+  ]
+}
+EOF
+
+}
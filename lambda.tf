@@ -15,12 +15,55 @@ resource "aws_lambda_function" "self" {
 
   environment {
     variables = {
-      sender              = var.sender
-      recipients          = var.recipients
-      char_set            = var.char_set
-      s3_bucket           = var.s3_bucket
-      ssm_parameter_store = var.ssm_parameter_store
-      kms_key_arn         = var.kms_key_arn
+      sender                     = var.sender
+      recipients                 = var.recipients
+      char_set                   = var.char_set
+      s3_bucket                  = var.s3_bucket
+      ssm_parameter_store        = var.ssm_parameter_store
+      kms_key_arn                = var.kms_key_arn
+      notifiers                  = var.notifiers
+      sns_topic_arn              = var.sns_topic_arn
+      slack_webhook_url          = var.slack_webhook_url
+      teams_webhook_url          = var.teams_webhook_url
+      webhook_url                = var.webhook_url
+      securityhub_mode           = var.securityhub_mode
+      securityhub_product_arn    = var.securityhub_product_arn
+      event_bus_name             = var.event_bus_name
+      event_source               = var.event_source
+      template_bucket            = var.template_bucket
+      template_key               = var.template_key
+      archive_bucket             = var.archive_bucket
+      archive_prefix             = var.archive_prefix
+      archive_url                = var.archive_url
+      max_email_size             = var.max_email_size
+      presign_expiry             = var.presign_expiry
+      max_diff_lines             = var.max_diff_lines
+      cluster_threshold          = var.cluster_threshold
+      cloudtrail_attribution     = var.cloudtrail_attribution
+      cloudtrail_max_lookups     = var.cloudtrail_max_lookups
+      risk_rules_bucket          = var.risk_rules_bucket
+      risk_rules_key             = var.risk_rules_key
+      min_severity               = var.min_severity
+      routing_rules_bucket       = var.routing_rules_bucket
+      routing_rules_key          = var.routing_rules_key
+      owner_tags                 = var.owner_tags
+      default_owner              = var.default_owner
+      cc_owners                  = var.cc_owners
+      owner_email_domains        = var.owner_email_domains
+      workspace_tags             = var.workspace_tags
+      terraform_states           = var.terraform_states
+      approved_changes_bucket    = var.approved_changes_bucket
+      approved_changes_key       = var.approved_changes_key
+      change_authority_url       = var.change_authority_url
+      redact_patterns            = var.redact_patterns
+      redaction_salt             = var.redaction_salt
+      allowlist_bucket           = var.allowlist_bucket
+      allowlist_key              = var.allowlist_key
+      suppressions_bucket        = var.suppressions_bucket
+      suppressions_key           = var.suppressions_key
+      suppression_mode           = var.suppression_mode
+      maintenance_windows_bucket = var.maintenance_windows_bucket
+      maintenance_windows_key    = var.maintenance_windows_key
     }
   }
 }
@@ -43,3 +86,72 @@ resource "aws_s3_bucket_notification" "self" {
     filter_suffix       = ".json.gz"
   }
 }
+
+resource "aws_cloudwatch_event_rule" "digest" {
+  for_each            = local.digest_schedules
+  name                = "${local.app_name}-${each.key}-digest"
+  description         = "Sends the ${each.key} configuration change digest"
+  schedule_expression = each.value
+}
+
+resource "aws_cloudwatch_event_target" "digest" {
+  for_each = local.digest_schedules
+  rule     = aws_cloudwatch_event_rule.digest[each.key].name
+  arn      = aws_lambda_function.self.arn
+  input    = jsonencode({ digest = each.key })
+}
+
+resource "aws_lambda_permission" "digest" {
+  for_each      = local.digest_schedules
+  statement_id  = "AllowExecutionFrom${title(each.key)}Digest"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.self.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.digest[each.key].arn
+}
+
+resource "aws_cloudwatch_event_rule" "inventory" {
+  count               = var.inventory_schedule == "" ? 0 : 1
+  name                = "${local.app_name}-inventory"
+  description         = "Writes the component inventory"
+  schedule_expression = var.inventory_schedule
+}
+
+resource "aws_cloudwatch_event_target" "inventory" {
+  count = var.inventory_schedule == "" ? 0 : 1
+  rule  = aws_cloudwatch_event_rule.inventory[0].name
+  arn   = aws_lambda_function.self.arn
+  input = jsonencode({ inventory = true })
+}
+
+resource "aws_lambda_permission" "inventory" {
+  count         = var.inventory_schedule == "" ? 0 : 1
+  statement_id  = "AllowExecutionFromInventory"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.self.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.inventory[0].arn
+}
+
+resource "aws_cloudwatch_event_rule" "held_changes" {
+  count               = local.held_changes_enabled ? 1 : 0
+  name                = "${local.app_name}-held-changes"
+  description         = "Reports the changes held during maintenance windows that have closed"
+  schedule_expression = var.held_changes_schedule
+}
+
+resource "aws_cloudwatch_event_target" "held_changes" {
+  count = local.held_changes_enabled ? 1 : 0
+  rule  = aws_cloudwatch_event_rule.held_changes[0].name
+  arn   = aws_lambda_function.self.arn
+  input = jsonencode({ held_changes = true })
+}
+
+resource "aws_lambda_permission" "held_changes" {
+  count         = local.held_changes_enabled ? 1 : 0
+  statement_id  = "AllowExecutionFromHeldChanges"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.self.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.held_changes[0].arn
+}
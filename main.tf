@@ -6,4 +6,26 @@ locals {
   app_name      = "grace-${var.appenv}-config-differ"
   region        = data.aws_region.current.name
   s3_bucket_arn = "arn:aws:s3:::${var.s3_bucket}"
+
+  template_bucket_arn      = var.template_bucket == "" ? local.s3_bucket_arn : "arn:aws:s3:::${var.template_bucket}"
+  risk_rules_bucket_arn    = var.risk_rules_bucket == "" ? local.s3_bucket_arn : "arn:aws:s3:::${var.risk_rules_bucket}"
+  routing_rules_bucket_arn = var.routing_rules_bucket == "" ? local.s3_bucket_arn : "arn:aws:s3:::${var.routing_rules_bucket}"
+  suppressions_bucket_arn  = var.suppressions_bucket == "" ? local.s3_bucket_arn : "arn:aws:s3:::${var.suppressions_bucket}"
+  maintenance_bucket_arn   = var.maintenance_windows_bucket == "" ? local.s3_bucket_arn : "arn:aws:s3:::${var.maintenance_windows_bucket}"
+  approved_changes_arn     = var.approved_changes_bucket == "" ? local.s3_bucket_arn : "arn:aws:s3:::${var.approved_changes_bucket}"
+  allowlist_bucket_arn     = var.allowlist_bucket == "" ? local.s3_bucket_arn : "arn:aws:s3:::${var.allowlist_bucket}"
+
+  # object ARNs of the Terraform state files read from S3
+  terraform_state_arns = [for s in split(",", var.terraform_states) :
+    "arn:aws:s3:::${substr(trimspace(s), 5, -1)}" if substr(trimspace(s), 0, 5) == "s3://"
+  ]
+
+  # digest name => schedule expression, for the digests that are enabled
+  digest_schedules = { for k, v in {
+    daily  = var.daily_digest_schedule
+    weekly = var.weekly_digest_schedule
+  } : k => v if v != "" }
+
+  # held changes are only reported on a schedule when maintenance windows are configured
+  held_changes_enabled = var.maintenance_windows_key != "" && var.held_changes_schedule != ""
 }
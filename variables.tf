@@ -12,17 +12,19 @@ variable "source_file" {
 
 variable "sender" {
   type        = string
-  description = "(required) eMail address of sender for AWS SES"
+  description = "(optional) eMail address of sender for AWS SES (required by the ses notifier)"
+  default     = ""
 }
 
 variable "recipients" {
   type        = string
-  description = "(required) comma delimited list of AWS SES eMail recipients"
+  description = "(optional) comma delimited list of AWS SES eMail recipients (required by the ses notifier)"
+  default     = ""
 }
 
 variable "char_set" {
   type        = string
-  description = "(optional) character set (Default: UTF-8)"
+  description = "(optional) character set used to encode the email bodies (Default: UTF-8)"
   default     = "UTF-8"
 }
 
@@ -40,3 +42,289 @@ variable "ssm_parameter_store" {
   type        = string
   description = "(required) Name of AWS parameter store for LastSuccessfulEvaluationTime"
 }
+
+variable "notifiers" {
+  type        = string
+  description = "(optional) comma delimited list of notification sinks (ses | sns | slack | teams | webhook | securityhub | eventbridge)"
+  default     = "ses"
+}
+
+variable "sns_topic_arn" {
+  type        = string
+  description = "(optional) ARN of SNS topic for the sns notifier"
+  default     = ""
+}
+
+variable "slack_webhook_url" {
+  type        = string
+  description = "(optional) Slack incoming webhook HTTPS URL for the slack notifier"
+  default     = ""
+  sensitive   = true
+}
+
+variable "teams_webhook_url" {
+  type        = string
+  description = "(optional) Microsoft Teams incoming webhook HTTPS URL for the teams notifier"
+  default     = ""
+  sensitive   = true
+}
+
+variable "webhook_url" {
+  type        = string
+  description = "(optional) HTTPS URL for the generic JSON webhook notifier"
+  default     = ""
+  sensitive   = true
+}
+
+variable "securityhub_mode" {
+  type        = string
+  description = "(optional) what the securityhub notifier imports: a finding per change (changes) or per high or critical risk finding (findings)"
+  default     = "changes"
+}
+
+variable "securityhub_product_arn" {
+  type        = string
+  description = "(optional) product ARN of the Security Hub integration, defaults to the account's default product"
+  default     = ""
+}
+
+variable "event_bus_name" {
+  type        = string
+  description = "(optional) name or ARN of the EventBridge bus for the eventbridge notifier"
+  default     = "default"
+}
+
+variable "event_source" {
+  type        = string
+  description = "(optional) source of the events published by the eventbridge notifier"
+  default     = "grace.config-differ"
+}
+
+variable "template_bucket" {
+  type        = string
+  description = "(optional) S3 bucket containing a custom HTML report template (Default: s3_bucket)"
+  default     = ""
+}
+
+variable "template_key" {
+  type        = string
+  description = "(optional) S3 key of a custom HTML report template overriding the embedded defaults"
+  default     = ""
+}
+
+variable "archive_bucket" {
+  type        = string
+  description = "(optional) S3 bucket where every report is archived (archiving is disabled when empty)"
+  default     = ""
+}
+
+variable "archive_prefix" {
+  type        = string
+  description = "(optional) S3 key prefix for archived reports and the index"
+  default     = "config-differ"
+}
+
+variable "archive_url" {
+  type        = string
+  description = "(optional) base URL serving the archive prefix (Default: link to the S3 console)"
+  default     = ""
+}
+
+variable "max_email_size" {
+  type        = number
+  description = "(optional) maximum raw email size in bytes before the report is compressed and summarized (Default: SES limit of 10 MB)"
+  default     = 10485760
+}
+
+variable "presign_expiry" {
+  type        = string
+  description = "(optional) validity of presigned links to oversized reports as a Go duration (e.g. 1h); links stop working sooner if the function's session credentials expire first"
+  default     = "1h"
+}
+
+variable "max_diff_lines" {
+  type        = number
+  description = "(optional) lines of each long property diff shown in HTML reports before linking to the archived full diff (0 for no limit)"
+  default     = 200
+}
+
+variable "cluster_threshold" {
+  type        = number
+  description = "(optional) identical changes to at least this many resources are shown once in reports, listing the resources (0 to disable)"
+  default     = 3
+}
+
+variable "cloudtrail_attribution" {
+  type        = bool
+  description = "(optional) look up the CloudTrail events behind each change to report who made it"
+  default     = true
+}
+
+variable "cloudtrail_max_lookups" {
+  type        = number
+  description = "(optional) the most CloudTrail LookupEvents requests made per run; later changes are not attributed (0 for no limit)"
+  default     = 200
+}
+
+variable "risk_rules_bucket" {
+  type        = string
+  description = "(optional) S3 bucket containing custom risk rules (Default: s3_bucket)"
+  default     = ""
+}
+
+variable "risk_rules_key" {
+  type        = string
+  description = "(optional) S3 key of a JSON risk rule set extending or replacing the embedded defaults"
+  default     = ""
+}
+
+variable "min_severity" {
+  type        = string
+  description = "(optional) lowest severity that triggers notifications (info | low | medium | high | critical)"
+  default     = "info"
+}
+
+variable "routing_rules_bucket" {
+  type        = string
+  description = "(optional) S3 bucket containing notification routing rules (Default: s3_bucket)"
+  default     = ""
+}
+
+variable "routing_rules_key" {
+  type        = string
+  description = "(optional) S3 key of a JSON routing rule set sending each recipient only their changes"
+  default     = ""
+}
+
+variable "owner_tags" {
+  type        = string
+  description = "(optional) comma delimited list of tag names holding the owner of a resource, in order of preference"
+  default     = "Owner,Team,Contact"
+}
+
+variable "default_owner" {
+  type        = string
+  description = "(optional) owner of resources without owner tags"
+  default     = ""
+}
+
+variable "cc_owners" {
+  type        = bool
+  description = "(optional) email owners that are email addresses the changes to their resources"
+  default     = false
+}
+
+variable "owner_email_domains" {
+  type        = string
+  description = "(optional) comma delimited list of email domain globs, e.g. example.gov,*.example.gov, of the owners emailed by cc_owners"
+  default     = ""
+}
+
+variable "workspace_tags" {
+  type        = string
+  description = "(optional) comma delimited list of tag names holding the Terraform workspace that deployed a resource, in order of preference"
+  default     = "Workspace"
+}
+
+variable "terraform_states" {
+  type        = string
+  description = "(optional) comma delimited list of Terraform state files, as s3://bucket/key URLs or local paths, used to label changes as deployed or drift (disabled when empty)"
+  default     = ""
+}
+
+variable "approved_changes_bucket" {
+  type        = string
+  description = "(optional) S3 bucket containing the approved change feed (Default: s3_bucket)"
+  default     = ""
+}
+
+variable "approved_changes_key" {
+  type        = string
+  description = "(optional) S3 key of a JSON or CSV feed of approved change records used to mark changes authorized or unauthorized"
+  default     = ""
+}
+
+variable "change_authority_url" {
+  type        = string
+  description = "(optional) HTTPS endpoint returning the approved change records for a period"
+  default     = ""
+}
+
+variable "redact_patterns" {
+  type        = string
+  description = "(optional) comma delimited list of key name and property path patterns whose configuration values are replaced with a salted hash in reports (disabled when empty)"
+  default     = "*password*,*secret*,*token*,*credential*,*privatekey*,Environment.Variables.*,UserData"
+}
+
+variable "redaction_salt" {
+  type        = string
+  description = "(optional) salt of the hashes replacing redacted values, keeping them comparable across reports (Default: random for each report)"
+  default     = ""
+  sensitive   = true
+}
+
+variable "allowlist_bucket" {
+  type        = string
+  description = "(optional) S3 bucket containing the allowlist (Default: s3_bucket)"
+  default     = ""
+}
+
+variable "allowlist_key" {
+  type        = string
+  description = "(optional) S3 key of a JSON allowlist of resource types and regions; resources outside it are reported as high severity"
+  default     = ""
+}
+
+variable "daily_digest_schedule" {
+  type        = string
+  description = "(optional) EventBridge schedule expression sending the daily digest, e.g. cron(0 12 * * ? *) (disabled when empty)"
+  default     = ""
+}
+
+variable "weekly_digest_schedule" {
+  type        = string
+  description = "(optional) EventBridge schedule expression sending the weekly digest, e.g. cron(0 12 ? * MON *) (disabled when empty)"
+  default     = ""
+}
+
+variable "inventory_schedule" {
+  type        = string
+  description = "(optional) EventBridge schedule expression writing the component inventory, e.g. cron(0 6 * * ? *) (disabled when empty, requires archive_bucket)"
+  default     = ""
+}
+
+variable "suppressions_bucket" {
+  type        = string
+  description = "(optional) S3 bucket containing the suppression store (Default: s3_bucket)"
+  default     = ""
+}
+
+variable "suppressions_key" {
+  type        = string
+  description = "(optional) S3 key of the JSON store of acknowledged changes (suppression is disabled when empty)"
+  default     = ""
+}
+
+variable "suppression_mode" {
+  type        = string
+  description = "(optional) whether acknowledged changes are removed from reports or shown as suppressed (drop | annotate)"
+  default     = "drop"
+}
+
+variable "maintenance_windows_bucket" {
+  type        = string
+  description = "(optional) S3 bucket containing the maintenance windows (Default: s3_bucket)"
+  default     = ""
+}
+
+variable "maintenance_windows_key" {
+  type        = string
+  description = "(optional) S3 key of a JSON list of maintenance windows that hold or tag the changes made during them"
+  default     = ""
+}
+
+variable "held_changes_schedule" {
+  type        = string
+  description = "(optional) EventBridge schedule expression reporting the changes held during maintenance windows that have closed (disabled when empty or without maintenance_windows_key)"
+  default     = "rate(15 minutes)"
+}